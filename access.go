@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	notAllowedText = "Sorry, this is a private bot, it doesn't work here"
+	notAdminText   = "Only admins of the chat can do that"
+)
+
+// idSet makes a set of ids, or nil if there are none
+func idSet(ids []int64) map[int64]bool {
+	if len(ids) == 0 {
+		return nil
+	}
+	set := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// allowed reports if the bot works for the update from the chat and the user.
+// Updates out of chats, like poll answers and inline queries, are always allowed:
+// they only touch chats which have been allowed before
+func (s *Srv) allowed(chat *tele.Chat, u *tele.User) bool {
+	if chat == nil || len(s.allowedChats) == 0 && len(s.allowedUsers) == 0 {
+		return true
+	}
+	return s.allowedChats[chat.ID] || u != nil && s.allowedUsers[u.ID]
+}
+
+// authorize rejects updates from chats and users out of the allowlist with a polite reply
+func (s *Srv) authorize(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		chat, u := c.Chat(), c.Sender()
+		if s.allowed(chat, u) {
+			return next(c)
+		}
+		s.rejected.inc(rejectNotAllowed)
+		s.log(c).Info("update is rejected, the chat and the user aren't allowed")
+		if c.Callback() != nil {
+			return c.Respond(&tele.CallbackResponse{Text: notAllowedText})
+		}
+		return c.Send(notAllowedText)
+	}
+}
+
+// adminOnly lets only admins run the handler in groups, like /clear and settings commands.
+// Everyone is an admin of private chats
+func (s *Srv) adminOnly(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if !s.adminCommands {
+			return next(c)
+		}
+		ok, err := s.isAdmin(c)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return next(c)
+		}
+		if c.Callback() != nil {
+			return c.Respond(&tele.CallbackResponse{Text: notAdminText})
+		}
+		return c.Send(notAdminText)
+	}
+}
+
+// isAdmin reports if the sender of the update is an admin or the creator of the group.
+// Anonymous admins send messages on behalf of the group itself
+func (s *Srv) isAdmin(c tele.Context) (bool, error) {
+	chat, u := c.Chat(), c.Sender()
+	if chat == nil || chat.Type != tele.ChatGroup && chat.Type != tele.ChatSuperGroup {
+		return true, nil
+	}
+	if msg := c.Message(); msg != nil && msg.SenderChat != nil && msg.SenderChat.ID == chat.ID {
+		return true, nil
+	}
+	if u == nil {
+		return false, nil
+	}
+	member, err := s.bot.ChatMemberOf(chat, u)
+	if err != nil {
+		return false, fmt.Errorf("can't get chat member: %w", err)
+	}
+	return member.Role == tele.Creator || member.Role == tele.Administrator, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestSrv_Allowlist(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	srv := NewServer(db, bot)
+	srv.allowedChats = idSet([]int64{1})
+	srv.allowedUsers = idSet([]int64{7})
+	stranger := &tele.Chat{ID: 2}
+
+	bot.cmd(t, 1, "/add", "milk")
+	bot.cmdIn(t, stranger, testUser, "/add", "tea")
+	if got := bot.sent[2]; len(got) != 1 || got[0] != notAllowedText {
+		t.Errorf("replies to not allowed chat = %v, want %q", got, notAllowedText)
+	}
+	if items, _ := db.List(2, defaultList); len(items) != 0 {
+		t.Errorf("items of not allowed chat = %v", items)
+	}
+
+	// taps are answered by a notification, not by a message
+	bot.tap(t, 2, 1, checkBtn, "0")
+	if got := len(bot.sent[2]); got != 1 {
+		t.Errorf("tap in not allowed chat has sent %d messages", got-1)
+	}
+
+	// allowed users work in any chat
+	bot.cmdIn(t, stranger, &tele.User{ID: 7}, "/add", "tea")
+	if items, _ := db.List(2, defaultList); len(items) != 1 {
+		t.Errorf("items added by allowed user = %v", items)
+	}
+	if items, _ := db.List(1, defaultList); len(items) != 1 {
+		t.Errorf("items of allowed chat = %v", items)
+	}
+}
+
+func TestSrv_AdminCommands(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	srv := NewServer(db, bot)
+	srv.adminCommands = true
+	group := &tele.Chat{ID: -100, Type: tele.ChatSuperGroup}
+	admin := &tele.User{ID: 7}
+	bot.admins[group.ID] = []int64{admin.ID}
+
+	lastReply := func(chatID int64) interface{} { return bot.sent[chatID][len(bot.sent[chatID])-1] }
+	view := func(chatID int64) string {
+		settings, _ := db.Settings(chatID)
+		return settings.View
+	}
+
+	// members of the group work with lists, but can't change settings
+	bot.cmdIn(t, group, testUser, "/add", "milk")
+	bot.cmdIn(t, group, testUser, "/view", "checklist")
+	if got := lastReply(group.ID); got != notAdminText {
+		t.Errorf("/view of member = %q, want %q", got, notAdminText)
+	}
+	if got := view(group.ID); got != "" {
+		t.Errorf("view changed by member = %q", got)
+	}
+	bot.cmdIn(t, group, admin, "/view", "checklist")
+	if got := view(group.ID); got != viewChecklist {
+		t.Errorf("view changed by admin = %q", got)
+	}
+
+	// anonymous admins send messages on behalf of the group
+	c := &fakeContext{bot: bot, msg: &tele.Message{Text: "/live on", Chat: group, SenderChat: group, Sender: &tele.User{ID: 1087968824}}}
+	if err := bot.handlers[liveCmd](c); err != nil {
+		t.Fatalf("/live of anonymous admin: %v", err)
+	}
+	if settings, _ := db.Settings(group.ID); !settings.Live {
+		t.Errorf("/live of anonymous admin hasn't been applied")
+	}
+
+	// the confirmation of /clear is checked too, since anyone could tap it
+	bot.cmdIn(t, group, admin, "/clear", "")
+	sent := len(bot.sent[group.ID])
+	c = &fakeContext{bot: bot, msg: &tele.Message{ID: bot.lastID, Chat: group, Sender: testUser}, data: defaultList, tapped: true}
+	if err := bot.handlers[clearListBtn.CallbackUnique()](c); err != nil {
+		t.Fatalf("clear tap of member: %v", err)
+	}
+	if items, _ := db.List(group.ID, defaultList); len(items) != 1 || len(bot.sent[group.ID]) != sent {
+		t.Errorf("list has been cleared by member, items: %v", items)
+	}
+
+	// everyone is an admin of private chats
+	bot.cmd(t, 1, "/view", "checklist")
+	if got := view(1); got != viewChecklist {
+		t.Errorf("view of private chat = %q", got)
+	}
+
+	srv.adminCommands = false
+	bot.cmdIn(t, group, testUser, "/view", "poll")
+	if got := view(group.ID); got != viewPoll {
+		t.Errorf("view changed by member without admin commands = %q", got)
+	}
+}
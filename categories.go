@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// otherSection is for items which are not found in the dictionary, it's the last aisle by default
+const otherSection = "other"
+
+// defaultAisles is the order of sections in chats which haven't set their own by /aisles
+var defaultAisles = []string{
+	"produce", "bakery", "meat", "fish", "dairy", "frozen", "pantry", "drinks", "household", otherSection,
+}
+
+var sectionRx = regexp.MustCompile(`^\w{1,32}$`)
+
+// builtinCategories are keywords of every section, in english and russian.
+// A keyword matches the whole item name or any word of it, regardless of case and number
+var builtinCategories = map[string][]string{
+	"produce": {
+		"apple", "banana", "orange", "lemon", "lime", "grape", "pear", "peach", "plum", "berry", "strawberry",
+		"blueberry", "avocado", "tomato", "potato", "onion", "garlic", "carrot", "cucumber", "pepper",
+		"cabbage", "lettuce", "salad", "spinach", "broccoli", "zucchini", "mushroom", "herbs", "dill",
+		"parsley", "ginger", "kiwi", "melon", "watermelon",
+		"яблоко", "банан", "апельсин", "лимон", "груша", "персик", "ягоды", "клубника",
+		"помидор", "томат", "картофель", "картошка", "лук", "чеснок", "морковь", "огурец",
+		"огурцы", "перец", "капуста", "салат", "шпинат", "кабачок", "грибы", "укроп",
+		"петрушка", "зелень", "имбирь", "киви", "арбуз", "дыня",
+	},
+	"bakery": {
+		"bread", "baguette", "bun", "roll", "croissant", "bagel", "cake", "pie", "tortilla",
+		"хлеб", "батон", "багет", "булка", "булочка", "круассан", "лаваш", "торт", "пирог",
+	},
+	"meat": {
+		"meat", "beef", "pork", "chicken", "turkey", "lamb", "mince", "bacon", "ham", "sausage", "steak",
+		"мясо", "говядина", "свинина", "курица", "индейка", "баранина", "фарш", "бекон",
+		"ветчина", "колбаса", "сосиски",
+	},
+	"fish": {
+		"fish", "salmon", "tuna", "cod", "shrimp", "prawn", "herring",
+		"рыба", "лосось", "семга", "тунец", "треска", "креветки", "сельдь", "селедка",
+	},
+	"dairy": {
+		"milk", "cheese", "butter", "yogurt", "yoghurt", "cream", "kefir", "egg", "cottage cheese",
+		"sour cream",
+		"молоко", "сыр", "масло", "йогурт", "сливки", "кефир", "яйца", "яйцо", "творог",
+		"сметана", "ряженка",
+	},
+	"frozen": {
+		"ice cream", "frozen", "dumplings", "pizza",
+		"мороженое", "пельмени", "вареники", "пицца",
+	},
+	"pantry": {
+		"rice", "pasta", "spaghetti", "flour", "sugar", "salt", "oil", "vinegar", "cereal", "oats", "oatmeal",
+		"beans", "lentils", "honey", "jam", "sauce", "ketchup", "mayo", "mayonnaise", "mustard", "spices",
+		"chocolate", "cookies", "crackers", "chips", "nuts", "canned",
+		"рис", "макароны", "спагетти", "мука", "сахар", "соль", "крупа", "гречка", "овсянка",
+		"хлопья", "фасоль", "чечевица", "мед", "варенье", "соус", "кетчуп", "майонез",
+		"горчица", "специи", "шоколад", "печенье", "орехи", "консервы",
+	},
+	"drinks": {
+		"water", "juice", "soda", "cola", "beer", "wine", "coffee", "tea", "lemonade",
+		"вода", "сок", "газировка", "кола", "пиво", "вино", "кофе", "чай", "лимонад",
+	},
+	"household": {
+		"toilet paper", "paper towels", "napkins", "soap", "shampoo", "detergent", "sponge", "trash bags",
+		"toothpaste", "batteries", "foil",
+		"туалетная бумага", "бумажные полотенца", "салфетки", "мыло", "шампунь",
+		"порошок", "губки", "мешки для мусора", "зубная паста", "батарейки", "фольга",
+	},
+}
+
+// categorizer finds store section of items by keywords
+type categorizer struct {
+	// keywords are normalized as item keys with folded plurals
+	keywords map[string]string
+	sections []string
+}
+
+// newCategorizer makes categorizer of built-in dictionary extended by extra keywords of sections
+func newCategorizer(extra map[string][]string) *categorizer {
+	cz := &categorizer{keywords: make(map[string]string), sections: append([]string(nil), defaultAisles...)}
+	add := func(dict map[string][]string) {
+		for section, keywords := range dict {
+			section = strings.ToLower(section)
+			if !containsString(cz.sections, section) {
+				cz.sections = append(cz.sections, section)
+			}
+			for _, k := range keywords {
+				cz.keywords[singular(foldName(k))] = section
+			}
+		}
+	}
+	add(builtinCategories)
+	add(extra)
+	return cz
+}
+
+// section returns section of the item name: the whole name is looked up first, then every word
+// from the last one, so "oat milk" goes to dairy, "молоко овсяное" as well
+func (cz *categorizer) section(name string) string {
+	key := singular(foldName(name))
+	if section, ok := cz.keywords[key]; ok {
+		return section
+	}
+	words := strings.Fields(key)
+	for i := len(words) - 1; i >= 0; i-- {
+		if section, ok := cz.keywords[singularWord(words[i])]; ok {
+			return section
+		}
+	}
+	return otherSection
+}
+
+// itemSection returns section of the item in the chat, overrides set by /category win
+// unless their section is gone from the config
+func (s *Srv) itemSection(settings ChatSettings, item Item) string {
+	if section, ok := settings.Categories[s.itemKey(item.Name)]; ok && containsString(s.categories.sections, section) {
+		return section
+	}
+	return s.categories.section(item.Name)
+}
+
+// aisles returns order of sections in the chat: sections set by /aisles go first, the rest keep the default order
+func (s *Srv) aisles(settings ChatSettings) []string {
+	order := append([]string(nil), settings.Aisles...)
+	for _, section := range s.categories.sections {
+		if !containsString(order, section) {
+			order = append(order, section)
+		}
+	}
+	return order
+}
+
+// listItems returns items of the list ordered by sections in the aisle order of the chat,
+// items of the same section keep their order
+func (s *Srv) listItems(chatID int64, list string) ([]Item, ChatSettings, error) {
+	settings, err := s.db.Settings(chatID)
+	if err != nil {
+		return nil, settings, fmt.Errorf("can't get settings: %w", err)
+	}
+	items, err := s.db.List(chatID, list)
+	if err != nil {
+		return nil, settings, fmt.Errorf("can't get items: %w", err)
+	}
+
+	rank := make(map[string]int)
+	for i, section := range s.aisles(settings) {
+		rank[section] = i
+	}
+	ranks := make([]int, len(items))
+	for i, item := range items {
+		ranks[i] = rank[s.itemSection(settings, item)]
+	}
+	sort.Stable(byRank{items, ranks})
+	return items, settings, nil
+}
+
+type byRank struct {
+	items []Item
+	ranks []int
+}
+
+func (b byRank) Len() int           { return len(b.items) }
+func (b byRank) Less(i, j int) bool { return b.ranks[i] < b.ranks[j] }
+func (b byRank) Swap(i, j int) {
+	b.items[i], b.items[j] = b.items[j], b.items[i]
+	b.ranks[i], b.ranks[j] = b.ranks[j], b.ranks[i]
+}
+
+// sectionsLine names sections of items in order of their appearance, it's empty if nothing is categorized
+func (s *Srv) sectionsLine(settings ChatSettings, items []Item) string {
+	var sections []string
+	for _, item := range items {
+		if section := s.itemSection(settings, item); !containsString(sections, section) {
+			sections = append(sections, section)
+		}
+	}
+	if len(sections) == 1 && sections[0] == otherSection {
+		return ""
+	}
+	return strings.Join(sections, " → ")
+}
+
+// setCategory sets section of the item in the chat: "/category oat milk dairy".
+// "auto" section brings back the one from the dictionary. If the last word is not a section,
+// the section of the whole item is shown
+func (s *Srv) setCategory(c tele.Context) error {
+	chatID := c.Chat().ID
+	usage := fmt.Sprintf("Usage: %s <item> <section>, sections: %s", categoryCmd, strings.Join(s.categories.sections, ", "))
+	words := strings.Fields(cmdPayload(c.Message().Text))
+	if len(words) == 0 {
+		return c.Send(usage)
+	}
+
+	settings, err := s.db.Settings(chatID)
+	if err != nil {
+		return fmt.Errorf("can't get settings: %w", err)
+	}
+	section := strings.ToLower(words[len(words)-1])
+	if len(words) == 1 || section != "auto" && !containsString(s.categories.sections, section) {
+		item := parseItem(strings.Join(words, " "))
+		return c.Send(fmt.Sprintf("%s goes to %s\n%s", item.Name, s.itemSection(settings, item), usage))
+	}
+	name := parseItem(strings.Join(words[:len(words)-1], " ")).Name
+
+	key := s.itemKey(name)
+	if section == "auto" {
+		delete(settings.Categories, key)
+		section = s.categories.section(name)
+	} else {
+		if settings.Categories == nil {
+			settings.Categories = make(map[string]string)
+		}
+		settings.Categories[key] = section
+	}
+	if err := s.db.SetSettings(chatID, settings); err != nil {
+		return fmt.Errorf("can't save settings: %w", err)
+	}
+
+	return c.Send(fmt.Sprintf("%s goes to %s", name, section))
+}
+
+// setAisles sets order of sections in the chat: "/aisles bakery, produce, dairy".
+// Without sections it shows the current order, "reset" brings back the default one
+func (s *Srv) setAisles(c tele.Context) error {
+	chatID := c.Chat().ID
+	settings, err := s.db.Settings(chatID)
+	if err != nil {
+		return fmt.Errorf("can't get settings: %w", err)
+	}
+
+	payload := strings.ToLower(strings.TrimSpace(cmdPayload(c.Message().Text)))
+	switch payload {
+	case "":
+		return c.Send("Aisles: " + strings.Join(s.aisles(settings), " → "))
+	case "reset":
+		settings.Aisles = nil
+	default:
+		known := s.categories.sections
+		var order []string
+		for _, section := range strings.FieldsFunc(payload, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' }) {
+			if !containsString(known, section) {
+				return c.Send(fmt.Sprintf("Unknown section %q, known ones: %s", section, strings.Join(known, ", ")))
+			}
+			if !containsString(order, section) {
+				order = append(order, section)
+			}
+		}
+		settings.Aisles = order
+	}
+
+	if err := s.db.SetSettings(chatID, settings); err != nil {
+		return fmt.Errorf("can't save settings: %w", err)
+	}
+	return c.Send("Aisles: " + strings.Join(s.aisles(settings), " → "))
+}
+
+func containsString(ss []string, s string) bool {
+	for i := range ss {
+		if ss[i] == s {
+			return true
+		}
+	}
+	return false
+}
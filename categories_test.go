@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestCategorizer_Section(t *testing.T) {
+	cz := newCategorizer(map[string][]string{
+		"Pets":  {"cat food", "litter"},
+		"dairy": {"tofu"},
+	})
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"milk", "dairy"},
+		{"Tomatoes", "produce"},
+		{"oat milk", "dairy"},
+		{"milk chocolate", "pantry"},
+		{"ice cream", "frozen"},
+		{"Toilet Paper", "household"},
+		{"Помидоры", "produce"},
+		{"молоко овсяное", "dairy"},
+		{"cat food", "pets"},
+		{"tofu", "dairy"},
+		{"screws", otherSection},
+		{"", otherSection},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cz.section(tt.name); got != tt.want {
+				t.Errorf("section(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+	if got := cz.sections[len(cz.sections)-1]; got != "pets" {
+		t.Errorf("the last section = %q, want pets", got)
+	}
+}
+
+func TestSrv_CategoriesAndAisles(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	NewServer(db, bot)
+
+	lastReply := func() interface{} { return bot.sent[1][len(bot.sent[1])-1] }
+	options := func() []string {
+		p, ok := bot.sent[1][len(bot.sent[1])-2].(*tele.Poll)
+		if !ok {
+			t.Fatalf("poll hasn't been sent")
+		}
+		var got []string
+		for _, o := range p.Options {
+			got = append(got, o.Text)
+		}
+		return got
+	}
+	assertOptions := func(step string, want ...string) {
+		t.Helper()
+		if got := options(); !reflect.DeepEqual(got, want) {
+			t.Errorf("poll options %s = %v, want %v", step, got, want)
+		}
+	}
+
+	bot.cmd(t, 1, "/add", "soap, milk, tomatoes, kitty litter, bread")
+	bot.cmd(t, 1, "/list", "")
+	assertOptions("by default aisles", "tomatoes", "bread", "milk", "soap", "kitty litter")
+	poll := bot.sent[1][len(bot.sent[1])-2].(*tele.Poll)
+	if poll.Question != "Shopping list, page: 1/1\nproduce → bakery → dairy → household → other" {
+		t.Errorf("poll question = %q", poll.Question)
+	}
+
+	bot.cmd(t, 1, "/category", "Kitty Litter household")
+	if got := lastReply(); got != "Kitty Litter goes to household" {
+		t.Errorf("/category reply = %q", got)
+	}
+	bot.cmd(t, 1, "/category", "kitty litter")
+	if got := lastReply(); got != "kitty litter goes to household\nUsage: /category <item> <section>, "+
+		"sections: produce, bakery, meat, fish, dairy, frozen, pantry, drinks, household, other" {
+		t.Errorf("/category of item = %q", got)
+	}
+
+	bot.cmd(t, 1, "/aisles", "household, Dairy")
+	if got := lastReply(); got != "Aisles: household → dairy → produce → bakery → meat → fish → "+
+		"frozen → pantry → drinks → other" {
+		t.Errorf("/aisles reply = %q", got)
+	}
+	bot.cmd(t, 1, "/aisles", "dairy, snacks")
+	if got := lastReply(); got != `Unknown section "snacks", `+
+		`known ones: produce, bakery, meat, fish, dairy, frozen, pantry, drinks, household, other` {
+		t.Errorf("/aisles with unknown section = %q", got)
+	}
+	bot.cmd(t, 1, "/list", "")
+	assertOptions("by chat aisles", "soap", "kitty litter", "milk", "tomatoes", "bread")
+
+	// other chats keep the default order
+	bot.cmd(t, 2, "/add", "soap, milk")
+	bot.cmd(t, 2, "/list", "")
+	if p := bot.sent[2][len(bot.sent[2])-2].(*tele.Poll); p.Options[0].Text != "milk" {
+		t.Errorf("the first option of another chat = %q, want milk", p.Options[0].Text)
+	}
+
+	bot.cmd(t, 1, "/category", "kitty litter auto")
+	bot.cmd(t, 1, "/aisles", "reset")
+	bot.cmd(t, 1, "/list", "")
+	assertOptions("after reset", "tomatoes", "bread", "milk", "soap", "kitty litter")
+
+	// checklists are ordered as well
+	bot.cmd(t, 1, "/view", "checklist")
+	bot.cmd(t, 1, "/list", "")
+	if got := lastReply(); got != "Shopping list\nproduce → bakery → dairy → household → other" {
+		t.Errorf("checklist text = %q", got)
+	}
+}
+
+func TestSrv_ItemSectionOfRemovedSection(t *testing.T) {
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	srv := NewServer(db, newFakeBot())
+	srv.categories = newCategorizer(map[string][]string{"pets": {"litter"}})
+
+	settings := ChatSettings{Categories: map[string]string{
+		srv.itemKey("milk"):   "pets",
+		srv.itemKey("litter"): "household",
+	}}
+	if got := srv.itemSection(settings, Item{Name: "milk"}); got != "pets" {
+		t.Errorf("section of milk = %q, want pets", got)
+	}
+
+	// the section is removed from the config, so the dictionary one is used
+	srv.categories = newCategorizer(nil)
+	if got := srv.itemSection(settings, Item{Name: "milk"}); got != "dairy" {
+		t.Errorf("section of milk after pets are gone = %q, want dairy", got)
+	}
+	if got := srv.itemSection(settings, Item{Name: "litter"}); got != "household" {
+		t.Errorf("section of litter = %q, want household", got)
+	}
+}
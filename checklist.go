@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const checklistPageSize = 10
+
+// endpoints of checklist inline buttons
+var (
+	checkBtn = tele.Btn{Unique: "check"}
+	pageBtn  = tele.Btn{Unique: "page"}
+	clearBtn = tele.Btn{Unique: "clear"}
+)
+
+// checklist is the list message with inline buttons, one button per item
+type checklist struct {
+	msgID int
+	list  string
+	items []Item
+	// checked keeps who has checked the item, nil if it's unchecked
+	checked []*tele.User
+	page    int
+}
+
+func newChecklist(list string, items []Item) *checklist {
+	return &checklist{list: list, items: items, checked: make([]*tele.User, len(items))}
+}
+
+func (cl *checklist) pages() int {
+	return (len(cl.items) + checklistPageSize - 1) / checklistPageSize
+}
+
+// markup renders current page of the checklist as inline keyboard
+func (cl *checklist) markup() *tele.ReplyMarkup {
+	rm := &tele.ReplyMarkup{}
+
+	var rows []tele.Row
+	for i := cl.page * checklistPageSize; i < len(cl.items) && i < (cl.page+1)*checklistPageSize; i++ {
+		text := cl.items[i].String()
+		if cl.checked[i] != nil {
+			text = strikethrough(text)
+		}
+		rows = append(rows, rm.Row(rm.Data(text, checkBtn.Unique, cl.list, strconv.Itoa(i))))
+	}
+
+	if pages := cl.pages(); pages > 1 {
+		rows = append(rows, rm.Row(
+			rm.Data("«", pageBtn.Unique, cl.list, strconv.Itoa((cl.page+pages-1)%pages)),
+			rm.Data(fmt.Sprintf("%d/%d", cl.page+1, pages), pageBtn.Unique, cl.list, strconv.Itoa(cl.page)),
+			rm.Data("»", pageBtn.Unique, cl.list, strconv.Itoa((cl.page+1)%pages)),
+		))
+	}
+	rows = append(rows, rm.Row(rm.Data("Clear checked", clearBtn.Unique, cl.list)))
+
+	rm.Inline(rows...)
+	return rm
+}
+
+// strikethrough crosses out s with combining long stroke, because buttons can't be formatted
+func strikethrough(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		b.WriteRune(r)
+		b.WriteRune('\u0336')
+	}
+	return b.String()
+}
+
+// listKey identifies a list of particular chat
+type listKey struct {
+	chatID int64
+	list   string
+}
+
+// checklists keeps the last checklist of every list sent into every chat
+type checklists struct {
+	lists map[listKey]*checklist
+
+	mu sync.Mutex
+}
+
+func newChecklists() *checklists {
+	return &checklists{lists: make(map[listKey]*checklist)}
+}
+
+// set makes cl the current checklist of its list in chatID
+func (cls *checklists) set(chatID int64, cl *checklist) {
+	cls.mu.Lock()
+	defer cls.mu.Unlock()
+	cls.lists[listKey{chatID, cl.list}] = cl
+}
+
+// update calls fn for checklist of the list in chatID if msgID is still the current one,
+// and returns rendered markup. If fn returns false, nothing has been changed and markup is nil
+func (cls *checklists) update(chatID int64, list string, msgID int, fn func(cl *checklist) bool) (rm *tele.ReplyMarkup, ok bool) {
+	cls.mu.Lock()
+	defer cls.mu.Unlock()
+
+	cl, ok := cls.lists[listKey{chatID, list}]
+	if !ok || cl.msgID != msgID {
+		return nil, false
+	}
+	if fn(cl) {
+		rm = cl.markup()
+	}
+	return rm, true
+}
+
+// checkedItem is an item checked in checklist by the user
+type checkedItem struct {
+	item Item
+	by   *tele.User
+}
+
+// takeChecked returns checked items of the list checklist and unchecks them
+func (cls *checklists) takeChecked(chatID int64, list string) []checkedItem {
+	cls.mu.Lock()
+	defer cls.mu.Unlock()
+
+	cl, ok := cls.lists[listKey{chatID, list}]
+	if !ok {
+		return nil
+	}
+	return cl.takeChecked()
+}
+
+// takeChecked returns checked items and unchecks them
+func (cl *checklist) takeChecked() []checkedItem {
+	var items []checkedItem
+	for i, by := range cl.checked {
+		if by != nil {
+			items = append(items, checkedItem{item: cl.items[i], by: by})
+			cl.checked[i] = nil
+		}
+	}
+	return items
+}
+
+// showChecklist sends the list as a message with inline button for every item
+func (s *Srv) showChecklist(chat *tele.Chat, list string) error {
+	items, settings, err := s.listItems(chat.ID, list)
+	if err != nil {
+		return err
+	}
+
+	if len(items) == 0 {
+		return s.send(chat, "List is empty")
+	}
+
+	title := listTitle(list)
+	if sections := s.sectionsLine(settings, items); sections != "" {
+		title += "\n" + sections
+	}
+	cl := newChecklist(list, items)
+	msg, err := s.bot.Send(chat, title, cl.markup())
+	if err != nil {
+		return fmt.Errorf("can't send checklist: %w", err)
+	}
+	cl.msgID = msg.ID
+	s.checklists.set(chat.ID, cl)
+
+	return nil
+}
+
+// checklistData parses "list|number" data of checklist buttons
+func checklistData(data string) (list string, n int, err error) {
+	list, num, _ := strings.Cut(data, "|")
+	if num == "" {
+		return list, 0, nil
+	}
+	n, err = strconv.Atoi(num)
+	return list, n, err
+}
+
+// toggleItem checks or unchecks item by tap on its button
+func (s *Srv) toggleItem(c tele.Context) error {
+	list, i, err := checklistData(c.Data())
+	if err != nil {
+		return fmt.Errorf("can't parse item index: %w", err)
+	}
+
+	rm, ok := s.checklists.update(c.Chat().ID, list, c.Message().ID, func(cl *checklist) bool {
+		if i < 0 || i >= len(cl.items) {
+			return false
+		}
+		if cl.checked[i] != nil {
+			cl.checked[i] = nil
+		} else {
+			cl.checked[i] = orAnonymous(c.Sender())
+		}
+		return true
+	})
+
+	return s.editChecklist(c, rm, ok)
+}
+
+// turnPage shows another page of the checklist
+func (s *Srv) turnPage(c tele.Context) error {
+	list, page, err := checklistData(c.Data())
+	if err != nil {
+		return fmt.Errorf("can't parse page: %w", err)
+	}
+
+	rm, ok := s.checklists.update(c.Chat().ID, list, c.Message().ID, func(cl *checklist) bool {
+		if page == cl.page || page < 0 || page >= cl.pages() {
+			return false
+		}
+		cl.page = page
+		return true
+	})
+
+	return s.editChecklist(c, rm, ok)
+}
+
+// clearChecked removes checked items and redraws the checklist with the rest of the list.
+// Nothing is removed by outdated checklists, items checked in the current one are left to it
+func (s *Srv) clearChecked(c tele.Context) error {
+	chatID := c.Chat().ID
+	list, _, err := checklistData(c.Data())
+	if err != nil {
+		return fmt.Errorf("can't parse list: %w", err)
+	}
+
+	var checked []checkedItem
+	if _, ok := s.checklists.update(chatID, list, c.Message().ID, func(cl *checklist) bool {
+		checked = cl.takeChecked()
+		return false
+	}); !ok {
+		return s.editChecklist(c, nil, false)
+	}
+	at := s.now()
+	removed, err := s.buyChecked(chatID, list, checked, at)
+	if err != nil {
+		return err
+	}
+	if err := s.journal(chatID, doneCmd, list, removed, at); err != nil {
+		return err
+	}
+
+	items, _, err := s.listItems(chatID, list)
+	if err != nil {
+		return err
+	}
+
+	rm, ok := s.checklists.update(chatID, list, c.Message().ID, func(cl *checklist) bool {
+		*cl = checklist{msgID: cl.msgID, list: list, items: items, checked: make([]*tele.User, len(items)), page: cl.page}
+		if cl.page >= cl.pages() {
+			cl.page = 0
+		}
+		return true
+	})
+
+	return s.editChecklist(c, rm, ok)
+}
+
+// removeChecked removes from the list all items checked in its checklist and returns them
+func (s *Srv) removeChecked(chatID int64, list string, at time.Time) ([]Item, error) {
+	return s.buyChecked(chatID, list, s.checklists.takeChecked(chatID, list), at)
+}
+
+// buyChecked removes checked items from the list and returns them
+func (s *Srv) buyChecked(chatID int64, list string, items []checkedItem, at time.Time) ([]Item, error) {
+	var removed []Item
+	for _, checked := range items {
+		if err := s.buy(chatID, list, checked.item, checked.by, at); err != nil {
+			return removed, err
+		}
+		removed = append(removed, checked.item)
+	}
+	return removed, nil
+}
+
+// orAnonymous returns empty user instead of nil, so the item is still checked
+func orAnonymous(u *tele.User) *tele.User {
+	if u == nil {
+		return &tele.User{}
+	}
+	return u
+}
+
+// editChecklist replaces checklist buttons in place and answers the callback
+func (s *Srv) editChecklist(c tele.Context, rm *tele.ReplyMarkup, ok bool) error {
+	if !ok {
+		return c.Respond(&tele.CallbackResponse{Text: fmt.Sprintf("This list is outdated, use %s", listCmd)})
+	}
+	if rm != nil {
+		if _, err := s.bot.EditReplyMarkup(c.Message(), rm); err != nil {
+			return fmt.Errorf("can't edit checklist: %w", err)
+		}
+	}
+	return c.Respond()
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets in printed config
+const redacted = "***"
+
+// Config is the effective configuration of the bot.
+// Every option is taken from the first place it's set in: flags, env vars, config file, defaults
+type Config struct {
+	Token string `yaml:"token"`
+
+	// Storage is a backend of ItemStorager: inmem or sqlite
+	Storage    string `yaml:"storage"`
+	DumpPath   string `yaml:"dump_path"`
+	SQLitePath string `yaml:"sqlite_path"`
+	// SnapshotInterval is how often inmem storage is dumped if it's changed, never if it's zero
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+	// DumpBackups is how many previous dumps are kept
+	DumpBackups int `yaml:"dump_backups"`
+	// ShutdownTimeout is how long in-flight updates and scheduled jobs are waited for on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Poller is the way to get updates: long polling or webhook
+	Poller      string        `yaml:"poller"`
+	PollTimeout time.Duration `yaml:"poll_timeout"`
+	Webhook     webhookConfig `yaml:"webhook"`
+	// MetricsListen is the local address of /metrics, /healthz and /readyz, they are off if it's empty
+	MetricsListen string `yaml:"metrics_listen"`
+
+	LogLevel string `yaml:"log_level"`
+	// LogFormat is text (key=value pairs) or json
+	LogFormat string `yaml:"log_format"`
+	// AllowedChats and AllowedUsers are the only chats the bot works in and the only users it works for
+	// in other chats. Everyone is allowed if both are empty
+	AllowedChats []int64 `yaml:"allowed_chats"`
+	AllowedUsers []int64 `yaml:"allowed_users"`
+	// AdminCommands lets only admins of groups /clear lists and change settings of the chat
+	AdminCommands bool `yaml:"admin_commands"`
+	// RateLimit is how many updates a minute every chat and every user could send, no limit if it's zero.
+	// RateBurst is how many of them could be sent at once
+	RateLimit int `yaml:"rate_limit"`
+	RateBurst int `yaml:"rate_burst"`
+	// MaxItems, MaxLists and MaxItemLen are quotas of every chat: items in a list, lists in a chat
+	// and letters in an item. Zero means no limit
+	MaxItems   int `yaml:"max_items"`
+	MaxLists   int `yaml:"max_lists"`
+	MaxItemLen int `yaml:"max_item_len"`
+	// DefaultView is used in chats which haven't chosen the view by /view
+	DefaultView string `yaml:"default_view"`
+	// FoldPlurals makes "tomato" and "tomatoes" the same item
+	FoldPlurals bool `yaml:"fold_plurals"`
+	// Categories are extra keywords of store sections, set by config file only
+	Categories map[string][]string `yaml:"categories"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Storage:          "inmem",
+		DumpPath:         dumpFile,
+		SQLitePath:       sqliteFile,
+		SnapshotInterval: time.Minute,
+		DumpBackups:      3,
+		ShutdownTimeout:  defaultShutdownTimeout,
+		Poller:           "long",
+		PollTimeout:      10 * time.Second,
+		Webhook:          webhookConfig{Listen: ":8443"},
+		LogLevel:         "info",
+		LogFormat:        "text",
+		DefaultView:      viewPoll,
+		FoldPlurals:      true,
+		AdminCommands:    true,
+		RateLimit:        30,
+		RateBurst:        10,
+		MaxItems:         200,
+		MaxLists:         20,
+		MaxItemLen:       100,
+	}
+}
+
+// limits returns storage quotas of the config
+func (c Config) limits() Limits {
+	return Limits{Items: c.MaxItems, Lists: c.MaxLists, ItemLen: c.MaxItemLen}
+}
+
+// option is a single config option, which could be set by env var and flag.
+// Options without flag name are set by env var and config file only
+type option struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+func stringOpt(field func(c *Config) *string) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func durationOpt(field func(c *Config) *time.Duration) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func boolOpt(field func(c *Config) *bool) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func idsOpt(field func(c *Config) *[]int64) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		var ids []int64
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		*field(c) = ids
+		return nil
+	}
+}
+
+func intOpt(field func(c *Config) *int) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+var options = []option{
+	{"", "SCBOT_TG_TOKEN", "telegram bot token", stringOpt(func(c *Config) *string { return &c.Token })},
+	{"storage", "SCBOT_STORAGE", "storage backend: inmem or sqlite", stringOpt(func(c *Config) *string { return &c.Storage })},
+	{"dump-path", "SCBOT_DUMP_PATH", "path of gob dump", stringOpt(func(c *Config) *string { return &c.DumpPath })},
+	{"sqlite-path", "SCBOT_SQLITE_PATH", "path of sqlite db", stringOpt(func(c *Config) *string { return &c.SQLitePath })},
+	{"snapshot-interval", "SCBOT_SNAPSHOT_INTERVAL", "how often inmem storage is dumped, like 1m, 0 to dump on shutdown only", durationOpt(func(c *Config) *time.Duration { return &c.SnapshotInterval })},
+	{"dump-backups", "SCBOT_DUMP_BACKUPS", "how many previous dumps are kept", intOpt(func(c *Config) *int { return &c.DumpBackups })},
+	{"shutdown-timeout", "SCBOT_SHUTDOWN_TIMEOUT", "how long in-flight updates are waited for on shutdown, like 5s", durationOpt(func(c *Config) *time.Duration { return &c.ShutdownTimeout })},
+	{"poller", "SCBOT_POLLER", "the way to get updates: long or webhook", stringOpt(func(c *Config) *string { return &c.Poller })},
+	{"poll-timeout", "SCBOT_POLL_TIMEOUT", "timeout of long polling, like 10s", durationOpt(func(c *Config) *time.Duration { return &c.PollTimeout })},
+	{"webhook-listen", "SCBOT_WEBHOOK_LISTEN", "local address of webhook listener", stringOpt(func(c *Config) *string { return &c.Webhook.Listen })},
+	{"webhook-url", "SCBOT_WEBHOOK_URL", "public url of webhook", stringOpt(func(c *Config) *string { return &c.Webhook.PublicURL })},
+	{"webhook-cert", "SCBOT_WEBHOOK_CERT", "tls cert of webhook listener", stringOpt(func(c *Config) *string { return &c.Webhook.Cert })},
+	{"webhook-key", "SCBOT_WEBHOOK_KEY", "tls key of webhook listener", stringOpt(func(c *Config) *string { return &c.Webhook.Key })},
+	{"webhook-secret-path", "SCBOT_WEBHOOK_SECRET_PATH", "secret path of webhook", stringOpt(func(c *Config) *string { return &c.Webhook.SecretPath })},
+	{"metrics-listen", "SCBOT_METRICS_LISTEN", "local address of /metrics, /healthz and /readyz listener, like :9090, off if empty", stringOpt(func(c *Config) *string { return &c.MetricsListen })},
+	{"log-level", "SCBOT_LOG_LEVEL", "log level: debug, info, warn or error", stringOpt(func(c *Config) *string { return &c.LogLevel })},
+	{"log-format", "SCBOT_LOG_FORMAT", "log format: text or json", stringOpt(func(c *Config) *string { return &c.LogFormat })},
+	{"allowed-chats", "SCBOT_ALLOWED_CHATS", "comma separated ids of allowed chats, everyone is allowed if both chats and users are empty", idsOpt(func(c *Config) *[]int64 { return &c.AllowedChats })},
+	{"allowed-users", "SCBOT_ALLOWED_USERS", "comma separated ids of users allowed in any chat", idsOpt(func(c *Config) *[]int64 { return &c.AllowedUsers })},
+	{"admin-commands", "SCBOT_ADMIN_COMMANDS", "let only admins of groups clear lists and change settings", boolOpt(func(c *Config) *bool { return &c.AdminCommands })},
+	{"rate-limit", "SCBOT_RATE_LIMIT", "updates a minute of every chat and user, 0 for no limit", intOpt(func(c *Config) *int { return &c.RateLimit })},
+	{"rate-burst", "SCBOT_RATE_BURST", "updates every chat and user could send at once", intOpt(func(c *Config) *int { return &c.RateBurst })},
+	{"max-items", "SCBOT_MAX_ITEMS", "items in a list, 0 for no limit", intOpt(func(c *Config) *int { return &c.MaxItems })},
+	{"max-lists", "SCBOT_MAX_LISTS", "lists in a chat, 0 for no limit", intOpt(func(c *Config) *int { return &c.MaxLists })},
+	{"max-item-len", "SCBOT_MAX_ITEM_LEN", "letters in an item, 0 for no limit", intOpt(func(c *Config) *int { return &c.MaxItemLen })},
+	{"default-view", "SCBOT_DEFAULT_VIEW", "default view of lists: poll or checklist", stringOpt(func(c *Config) *string { return &c.DefaultView })},
+	{"fold-plurals", "SCBOT_FOLD_PLURALS", "treat singular and plural of english and russian item names as the same item", boolOpt(func(c *Config) *bool { return &c.FoldPlurals })},
+}
+
+// loadConfig builds config from defaults, config file, env vars and flags, the latter wins.
+// Config file is set by --config flag or SCBOT_CONFIG env var
+func loadConfig(args []string, getenv func(string) string) (cfg Config, printConfig bool, err error) {
+	fs := flag.NewFlagSet("scbot", flag.ContinueOnError)
+
+	path := fs.String("config", getenv("SCBOT_CONFIG"), "path of yaml config file ($SCBOT_CONFIG)")
+	fs.BoolVar(&printConfig, "print-config", false, "print effective config and exit")
+
+	flags := make(map[string]string)
+	for _, o := range options {
+		if o.flag == "" {
+			continue
+		}
+		name := o.flag
+		fs.Func(name, fmt.Sprintf("%s ($%s)", o.usage, o.env), func(v string) error {
+			flags[name] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, false, fmt.Errorf("can't parse flags: %w", err)
+	}
+
+	cfg = defaultConfig()
+	if *path != "" {
+		if err := readConfigFile(*path, &cfg); err != nil {
+			return cfg, false, err
+		}
+	}
+
+	for _, o := range options {
+		if v := getenv(o.env); v != "" {
+			if err := o.set(&cfg, v); err != nil {
+				return cfg, false, fmt.Errorf("can't parse %s: %w", o.env, err)
+			}
+		}
+	}
+	// flags are applied in order of options, not in order of args, but it doesn't matter
+	for _, o := range options {
+		if v, ok := flags[o.flag]; ok {
+			if err := o.set(&cfg, v); err != nil {
+				return cfg, false, fmt.Errorf("can't parse --%s: %w", o.flag, err)
+			}
+		}
+	}
+
+	return cfg, printConfig, nil
+}
+
+// readConfigFile reads yaml config over cfg, unknown fields are errors
+func readConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can't read config: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("can't parse config %s: %w", path, err)
+	}
+	return nil
+}
+
+// validate checks all options and returns all found problems at once
+func (c Config) validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Token != "", "token is not set")
+	check(c.Storage == "inmem" || c.Storage == "sqlite", "unknown storage %q, want inmem or sqlite", c.Storage)
+	check(c.DumpPath != "", "dump path is not set")
+	check(c.Storage != "sqlite" || c.SQLitePath != "", "sqlite path is not set")
+	check(c.SnapshotInterval >= 0, "snapshot interval can't be negative, got %s", c.SnapshotInterval)
+	check(c.DumpBackups >= 0, "dump backups can't be negative, got %d", c.DumpBackups)
+	check(c.ShutdownTimeout > 0, "shutdown timeout should be positive, got %s", c.ShutdownTimeout)
+	check(c.Poller == "long" || c.Poller == "webhook", "unknown poller %q, want long or webhook", c.Poller)
+	if c.Poller == "webhook" {
+		check(c.Webhook.Listen != "", "webhook listen address is not set")
+		check(c.Webhook.PublicURL != "", "webhook url is not set")
+		check((c.Webhook.Cert == "") == (c.Webhook.Key == ""), "both webhook cert and key should be set")
+	} else {
+		check(c.PollTimeout > 0, "poll timeout should be positive, got %s", c.PollTimeout)
+	}
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "unknown log level %q, want debug, info, warn or error", c.LogLevel)
+	}
+	check(c.LogFormat == "text" || c.LogFormat == "json", "unknown log format %q, want text or json", c.LogFormat)
+	check(c.RateLimit >= 0, "rate limit can't be negative, got %d", c.RateLimit)
+	check(c.RateLimit == 0 || c.RateBurst > 0, "rate burst should be positive, got %d", c.RateBurst)
+	check(c.MaxItems >= 0 && c.MaxLists >= 0 && c.MaxItemLen >= 0, "quotas can't be negative")
+	check(c.DefaultView == viewPoll || c.DefaultView == viewChecklist,
+		"unknown default view %q, want %s or %s", c.DefaultView, viewPoll, viewChecklist)
+	for section := range c.Categories {
+		check(sectionRx.MatchString(strings.ToLower(section)), "invalid category %q, want letters, digits and _ only", section)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// print writes config as yaml with secrets redacted
+func (c Config) print(w io.Writer) error {
+	if c.Token != "" {
+		c.Token = redacted
+	}
+	if c.Webhook.SecretPath != "" {
+		c.Webhook.SecretPath = redacted
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return fmt.Errorf("can't encode config: %w", err)
+	}
+	return enc.Close()
+}
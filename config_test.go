@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("can't write config: %v", err)
+	}
+	return path
+}
+
+func envOf(env map[string]string) func(string) string {
+	return func(key string) string { return env[key] }
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := writeConfig(t, `
+token: file-token
+storage: sqlite
+sqlite_path: /data/items.db
+poll_timeout: 5s
+log_level: warn
+allowed_chats: [1, 2]
+admin_commands: false
+webhook:
+  url: https://bot.example.com
+  secret_path: file-secret
+`)
+
+	env := map[string]string{
+		"SCBOT_CONFIG":        path,
+		"SCBOT_TG_TOKEN":      "env-token",
+		"SCBOT_POLL_TIMEOUT":  "20s",
+		"SCBOT_DEFAULT_VIEW":  "checklist",
+		"SCBOT_DUMP_BACKUPS":  "5",
+		"SCBOT_FOLD_PLURALS":  "false",
+		"SCBOT_ALLOWED_USERS": "42",
+	}
+	args := []string{"--poll-timeout", "30s", "--allowed-chats", "3, -100500", "--snapshot-interval", "0"}
+
+	cfg, printConfig, err := loadConfig(args, envOf(env))
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if printConfig {
+		t.Errorf("printConfig = true")
+	}
+
+	want := defaultConfig()
+	want.Token = "env-token"
+	want.Storage = "sqlite"
+	want.SQLitePath = "/data/items.db"
+	want.PollTimeout = 30 * time.Second
+	want.SnapshotInterval = 0
+	want.DumpBackups = 5
+	want.FoldPlurals = false
+	want.LogLevel = "warn"
+	want.AllowedChats = []int64{3, -100500}
+	want.AllowedUsers = []int64{42}
+	want.AdminCommands = false
+	want.DefaultView = viewChecklist
+	want.Webhook.PublicURL = "https://bot.example.com"
+	want.Webhook.SecretPath = "file-secret"
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("loadConfig() = %+v, want %+v", cfg, want)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		wantErr string
+	}{
+		{"unknown flag", []string{"--nope"}, nil, "can't parse flags"},
+		{"missing file", []string{"--config", "/nope/config.yml"}, nil, "can't read config"},
+		{"unknown field", []string{"--config", writeConfig(t, "strage: sqlite")}, nil, "field strage not found"},
+		{"bad duration", nil, map[string]string{"SCBOT_POLL_TIMEOUT": "soon"}, "SCBOT_POLL_TIMEOUT"},
+		{"bad chat id", []string{"--allowed-chats", "1,two"}, nil, "--allowed-chats"},
+		{"bad backups", nil, map[string]string{"SCBOT_DUMP_BACKUPS": "many"}, "SCBOT_DUMP_BACKUPS"},
+		{"bad bool", []string{"--fold-plurals", "maybe"}, nil, "--fold-plurals"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := loadConfig(tt.args, envOf(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	valid := defaultConfig()
+	valid.Token = "token"
+
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"no token", func(c *Config) { c.Token = "" }, "token is not set"},
+		{"unknown storage", func(c *Config) { c.Storage = "redis" }, `unknown storage "redis"`},
+		{"unknown poller", func(c *Config) { c.Poller = "carrier pigeon" }, `unknown poller "carrier pigeon"`},
+		{"zero timeout", func(c *Config) { c.PollTimeout = 0 }, "poll timeout should be positive"},
+		{"webhook without url", func(c *Config) { c.Poller = "webhook" }, "webhook url is not set"},
+		{"webhook cert without key", func(c *Config) {
+			c.Poller, c.Webhook.PublicURL, c.Webhook.Cert = "webhook", "https://bot.example.com", "cert.pem"
+		}, "both webhook cert and key should be set"},
+		{"negative snapshot interval", func(c *Config) { c.SnapshotInterval = -time.Second }, "snapshot interval can't be negative"},
+		{"negative backups", func(c *Config) { c.DumpBackups = -1 }, "dump backups can't be negative"},
+		{"zero shutdown timeout", func(c *Config) { c.ShutdownTimeout = 0 }, "shutdown timeout should be positive"},
+		{"invalid category", func(c *Config) { c.Categories = map[string][]string{"pet food": {"kibble"}} }, `invalid category "pet food"`},
+		{"unknown log level", func(c *Config) { c.LogLevel = "loud" }, `unknown log level "loud"`},
+		{"unknown log format", func(c *Config) { c.LogFormat = "xml" }, `unknown log format "xml"`},
+		{"unknown view", func(c *Config) { c.DefaultView = "table" }, `unknown default view "table"`},
+		{"rate limit without burst", func(c *Config) { c.RateBurst = 0 }, "rate burst should be positive"},
+		{"negative quota", func(c *Config) { c.MaxLists = -1 }, "quotas can't be negative"},
+		{"all problems at once", func(c *Config) { c.Token, c.Storage = "", "redis" }, `token is not set; unknown storage "redis"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.change(&cfg)
+			err := cfg.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_Print(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Token = "123:secret-token"
+	cfg.Webhook.SecretPath = "secret-path"
+	cfg.AllowedChats = []int64{1}
+
+	buf := new(bytes.Buffer)
+	if err := cfg.print(buf); err != nil {
+		t.Fatalf("print() error = %v", err)
+	}
+	out := buf.String()
+	for _, secret := range []string{"secret-token", "secret-path"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("print() shows %q:\n%s", secret, out)
+		}
+	}
+	for _, want := range []string{"token: '***'", "secret_path: '***'", "poll_timeout: 10s", "storage: inmem", "- 1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("print() has no %q:\n%s", want, out)
+		}
+	}
+	if cfg.Token != "123:secret-token" {
+		t.Errorf("print() changed the config")
+	}
+
+	// printed config could be read back
+	path := writeConfig(t, out)
+	got, _, err := loadConfig([]string{"--config", path}, envOf(nil))
+	if err != nil {
+		t.Fatalf("loadConfig() of printed config error = %v", err)
+	}
+	if got.PollTimeout != cfg.PollTimeout || !reflect.DeepEqual(got.AllowedChats, cfg.AllowedChats) {
+		t.Errorf("loadConfig() of printed config = %+v", got)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// dumpVersion is the version of dumpData format. Dumps without version are legacy ones
+const dumpVersion = 4
+
+// dumpData is everything stored in gob dump
+type dumpData struct {
+	Version  int
+	Lists    map[int64]map[string][]Item
+	Settings map[int64]ChatSettings
+	History  map[int64][]Purchase
+	Undo     map[int64][]UndoEntry
+	// Polls are open polls by their tg ids
+	Polls map[string]OpenPoll
+	// Staples are recurring items by their ids
+	Staples map[int64]Staple
+	// Reminders are scheduled posts of lists by their ids
+	Reminders map[int64]Reminder
+	// Recipes of every chat by their folded names
+	Recipes map[int64]map[string]Recipe
+	// UserChats are chats of every user, where the user talked to the bot
+	UserChats map[int64][]UserChat
+}
+
+// legacy dump formats, from the newest to the oldest
+type (
+	dumpDataV3 struct {
+		Items    map[int64][]Item
+		Settings map[int64]ChatSettings
+	}
+	dumpDataV2 struct {
+		Items    map[int64][]string
+		Settings map[int64]ChatSettings
+	}
+	dumpDataV1 map[int64][]string
+)
+
+// writeDump encodes data in gob and saves them on disk, keeping the number of previous dumps as backups
+func writeDump(path string, d *dumpData, backups int) error {
+	data, err := encodeDump(d)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, backups)
+}
+
+func encodeDump(d *dumpData) ([]byte, error) {
+	d.Version = dumpVersion
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(d); err != nil {
+		return nil, fmt.Errorf("can't encode items: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic writes data into temp file next to path, syncs it and renames it over path,
+// so a crash never leaves path half-written. Previous versions are kept in path.1 … path.N, the newest first
+func writeFileAtomic(path string, data []byte, backups int) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := os.CreateTemp(dir, base+".tmp*")
+	if err != nil {
+		return fmt.Errorf("can't create temp dump: %w", err)
+	}
+	// it's already gone after successful rename
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("can't save dump: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("can't sync dump: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("can't close dump: %w", err)
+	}
+
+	if err := rotateBackups(path, backups); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("can't save dump: %w", err)
+	}
+	syncDir(dir)
+
+	return nil
+}
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// rotateBackups shifts path.1 … path.N-1 by one and makes the current path the first backup
+func rotateBackups(path string, backups int) error {
+	if backups <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	for i := backups - 1; i >= 1; i-- {
+		err := os.Rename(backupPath(path, i), backupPath(path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("can't rotate backups: %w", err)
+		}
+	}
+
+	first := backupPath(path, 1)
+	_ = os.Remove(first)
+	// hard link keeps the current dump in place until the new one is renamed over it
+	if err := os.Link(path, first); err != nil {
+		if err := os.Rename(path, first); err != nil {
+			return fmt.Errorf("can't backup dump: %w", err)
+		}
+	}
+	return nil
+}
+
+// syncDir makes renames in dir durable. It's the best effort, some systems can't sync directories
+func syncDir(dir string) {
+	d, err := os.Open(dir) //nolint:gosec
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+// readDumpOrBackup reads dump from path, or from the newest readable backup if the dump is broken or missing
+func readDumpOrBackup(path string, backups int) (*dumpData, error) {
+	d, err := readDump(path)
+	if err == nil {
+		return d, nil
+	}
+	for i := 1; i <= backups; i++ {
+		if d, backupErr := readDump(backupPath(path, i)); backupErr == nil {
+			defaultLogger().Warn("dump is restored from backup", "backup", backupPath(path, i), "err", err)
+			return d, nil
+		}
+	}
+	return nil, err
+}
+
+// readDump reads gob dump from disk. Dumps in legacy formats are migrated on the fly
+func readDump(path string) (*dumpData, error) {
+	f, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read dump: %w", err)
+	}
+
+	d := &dumpData{}
+	// gob skips unknown fields, so legacy structs could be decoded without error, but with zero version
+	if err := gob.NewDecoder(bytes.NewReader(f)).Decode(d); err != nil || d.Version == 0 {
+		if d, err = readLegacyDump(f); err != nil {
+			return nil, err
+		}
+	}
+	if d.Lists == nil {
+		d.Lists = make(map[int64]map[string][]Item)
+	}
+	if d.Settings == nil {
+		d.Settings = make(map[int64]ChatSettings)
+	}
+	if d.History == nil {
+		d.History = make(map[int64][]Purchase)
+	}
+	if d.Undo == nil {
+		d.Undo = make(map[int64][]UndoEntry)
+	}
+	if d.Polls == nil {
+		d.Polls = make(map[string]OpenPoll)
+	}
+
+	return d, nil
+}
+
+// readLegacyDump decodes dump in one of the legacy formats. All items of legacy dumps go to the default list
+func readLegacyDump(f []byte) (*dumpData, error) {
+	v3 := dumpDataV3{}
+	if err := gob.NewDecoder(bytes.NewReader(f)).Decode(&v3); err != nil {
+		v2 := dumpDataV2{}
+		if err := gob.NewDecoder(bytes.NewReader(f)).Decode(&v2); err != nil {
+			// the very first dumps contain only items map
+			var v1 dumpDataV1
+			if v1Err := gob.NewDecoder(bytes.NewReader(f)).Decode(&v1); v1Err != nil {
+				return nil, fmt.Errorf("can't decode items: %w", err)
+			}
+			v2.Items = v1
+		}
+
+		// plain string items become structured ones
+		v3 = dumpDataV3{Items: make(map[int64][]Item), Settings: v2.Settings}
+		for chatID, l := range v2.Items {
+			for _, item := range l {
+				v3.Items[chatID] = append(v3.Items[chatID], parseItem(item))
+			}
+		}
+	}
+
+	d := &dumpData{Lists: make(map[int64]map[string][]Item), Settings: v3.Settings}
+	for chatID, items := range v3.Items {
+		d.Lists[chatID] = map[string][]Item{defaultList: items}
+	}
+	return d, nil
+}
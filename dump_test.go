@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadDump_Legacy(t *testing.T) {
+	potatoes := []Item{{Name: "potatoes", Quantity: 2, Unit: "kg"}, {Name: "milk"}}
+	settings := map[int64]ChatSettings{0: {View: viewChecklist}}
+
+	tests := []struct {
+		name         string
+		dump         interface{}
+		want         map[int64]map[string][]Item
+		wantSettings map[int64]ChatSettings
+	}{
+		{
+			"v1, items only",
+			map[int64][]string{0: {"2 kg potatoes", "milk"}},
+			map[int64]map[string][]Item{0: {defaultList: potatoes}},
+			map[int64]ChatSettings{},
+		},
+		{
+			"v2, plain string items",
+			dumpDataV2{Items: map[int64][]string{0: {"2 kg potatoes", "milk"}}, Settings: settings},
+			map[int64]map[string][]Item{0: {defaultList: potatoes}},
+			settings,
+		},
+		{
+			"v3, structured items",
+			dumpDataV3{Items: map[int64][]Item{0: potatoes}, Settings: settings},
+			map[int64]map[string][]Item{0: {defaultList: potatoes}},
+			settings,
+		},
+		{
+			"current",
+			dumpData{Version: dumpVersion, Lists: map[int64]map[string][]Item{0: {"hardware": plainItems("screws")}}},
+			map[int64]map[string][]Item{0: {"hardware": plainItems("screws")}},
+			map[int64]ChatSettings{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "items.gob")
+
+			buf := new(bytes.Buffer)
+			if err := gob.NewEncoder(buf).Encode(tt.dump); err != nil {
+				t.Fatalf("can't encode dump: %v", err)
+			}
+			if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+				t.Fatalf("can't write dump: %v", err)
+			}
+
+			d, err := readDump(path)
+			if err != nil {
+				t.Fatalf("readDump() error = %v", err)
+			}
+			if !reflect.DeepEqual(d.Lists, tt.want) {
+				t.Errorf("readDump() lists = %v, want %v", d.Lists, tt.want)
+			}
+			if !reflect.DeepEqual(d.Settings, tt.wantSettings) {
+				t.Errorf("readDump() settings = %v, want %v", d.Settings, tt.wantSettings)
+			}
+		})
+	}
+}
+
+func TestWriteFileAtomic_Backups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "items.gob")
+
+	for _, data := range []string{"1", "2", "3", "4"} {
+		if err := writeFileAtomic(path, []byte(data), 2); err != nil {
+			t.Fatalf("writeFileAtomic() error = %v", err)
+		}
+	}
+
+	for p, want := range map[string]string{path: "4", backupPath(path, 1): "3", backupPath(path, 2): "2"} {
+		got, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatalf("can't read %s: %v", p, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", p, got, want)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("can't read dir: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("dir has %d files, want dump and 2 backups only", len(entries))
+	}
+}
+
+func TestReadDumpOrBackup(t *testing.T) {
+	older := &dumpData{Lists: map[int64]map[string][]Item{0: {defaultList: plainItems("milk")}}}
+	newer := &dumpData{Lists: map[int64]map[string][]Item{0: {defaultList: plainItems("milk", "bread")}}}
+
+	tests := []struct {
+		name    string
+		primary []byte
+		backups map[int][]byte
+		want    *dumpData
+		wantErr bool
+	}{
+		{"primary", encode(t, newer), map[int][]byte{1: encode(t, older)}, newer, false},
+		{"corrupt primary", []byte("garbage"), map[int][]byte{1: encode(t, newer), 2: encode(t, older)}, newer, false},
+		{"missing primary", nil, map[int][]byte{1: encode(t, newer)}, newer, false},
+		{"corrupt first backup", []byte("garbage"), map[int][]byte{1: []byte("garbage"), 2: encode(t, older)}, older, false},
+		{"nothing readable", []byte("garbage"), map[int][]byte{1: []byte("garbage")}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "items.gob")
+			if tt.primary != nil {
+				if err := os.WriteFile(path, tt.primary, 0o600); err != nil {
+					t.Fatalf("can't write dump: %v", err)
+				}
+			}
+			for n, data := range tt.backups {
+				if err := os.WriteFile(backupPath(path, n), data, 0o600); err != nil {
+					t.Fatalf("can't write backup: %v", err)
+				}
+			}
+
+			got, err := readDumpOrBackup(path, 2)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readDumpOrBackup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got.Lists, tt.want.Lists) {
+				t.Errorf("readDumpOrBackup() = %v, want %v", got.Lists, tt.want.Lists)
+			}
+		})
+	}
+}
+
+func encode(t *testing.T, d *dumpData) []byte {
+	t.Helper()
+	data, err := encodeDump(d)
+	if err != nil {
+		t.Fatalf("encodeDump() error = %v", err)
+	}
+	return data
+}
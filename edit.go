@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// endpoints of /clear confirmation buttons
+var (
+	clearListBtn = tele.Btn{Unique: "clearlist"}
+	keepListBtn  = tele.Btn{Unique: "keeplist"}
+)
+
+// pickRx matches the query with the number of the match picked out of several ones: "mil #2"
+var pickRx = regexp.MustCompile(`^(.*?)\s*#(\d+)$`)
+
+// removeItem removes a single item matching the query from the list
+func (s *Srv) removeItem(c tele.Context) error {
+	chatID := c.Chat().ID
+	list, query, err := s.targetList(c)
+	if err != nil {
+		return s.reply(c, err)
+	}
+	if query == "" {
+		return c.Send(fmt.Sprintf("Usage: %s <item>", removeCmd))
+	}
+
+	item, err := s.findItem(chatID, list, query)
+	if err != nil {
+		return s.reply(c, err)
+	}
+	removed, ok, err := s.db.Take(chatID, list, item.Name)
+	if err != nil {
+		return fmt.Errorf("can't remove item: %w", err)
+	}
+	if !ok {
+		return c.Send(fmt.Sprintf("There is no %q in %s", query, listRef(list)))
+	}
+	if err := s.journal(chatID, removeCmd, list, []Item{removed}, s.now()); err != nil {
+		return err
+	}
+
+	return c.Send(fmt.Sprintf("Removed %s, %s to bring it back", removed, undoCmd))
+}
+
+// editItem replaces an item matching the query by another one: "/edit mlk -> milk 2l".
+// Quantity and note of the old item are kept, unless the new one has its own
+func (s *Srv) editItem(c tele.Context) error {
+	chatID := c.Chat().ID
+	list, payload, err := s.targetList(c)
+	if err != nil {
+		return s.reply(c, err)
+	}
+	query, repl, ok := cutArrow(payload)
+	if !ok || query == "" || repl == "" {
+		return c.Send(fmt.Sprintf("Usage: %s <item> -> <new item>", editCmd))
+	}
+
+	item, err := s.findItem(chatID, list, query)
+	if err != nil {
+		return s.reply(c, err)
+	}
+	edited := parseItem(repl)
+	if edited.Quantity == 0 {
+		edited.Quantity, edited.Unit = item.Quantity, item.Unit
+	}
+	if edited.Note == "" {
+		edited.Note = item.Note
+	}
+
+	ok, err = s.db.Replace(chatID, list, item.Name, edited)
+	if err != nil {
+		return s.reply(c, fmt.Errorf("can't replace item: %w", err))
+	}
+	if !ok {
+		return c.Send(fmt.Sprintf("There is no %q in %s", query, listRef(list)))
+	}
+
+	return c.Send(fmt.Sprintf("Changed %s to %s", item, edited))
+}
+
+// clearList asks to confirm removal of all items of the list
+func (s *Srv) clearList(c tele.Context) error {
+	list, _, err := s.targetList(c)
+	if err != nil {
+		return s.reply(c, err)
+	}
+	items, err := s.db.List(c.Chat().ID, list)
+	if err != nil {
+		return fmt.Errorf("can't get items: %w", err)
+	}
+	if len(items) == 0 {
+		return c.Send("List is empty")
+	}
+
+	rm := &tele.ReplyMarkup{}
+	rm.Inline(rm.Row(
+		rm.Data("Yes, clear", clearListBtn.Unique, list),
+		rm.Data("Cancel", keepListBtn.Unique, list),
+	))
+	return c.Send(fmt.Sprintf("Remove all %d item(s) from %s?", len(items), listRef(list)), rm)
+}
+
+// confirmClear removes all items of the list, they could be restored by /undo
+func (s *Srv) confirmClear(c tele.Context) error {
+	chatID := c.Chat().ID
+	list := c.Data()
+	if err := s.checkList(chatID, list); err != nil {
+		return s.reply(c, err)
+	}
+
+	items, err := s.db.Clear(chatID, list)
+	if err != nil {
+		return fmt.Errorf("can't clear list: %w", err)
+	}
+	if err := s.journal(chatID, clearCmd, list, items, s.now()); err != nil {
+		return err
+	}
+
+	_ = c.Respond()
+	return c.Edit(fmt.Sprintf("Removed %d item(s) from %s, %s to bring them back", len(items), listRef(list), undoCmd))
+}
+
+// cancelClear keeps the list as is
+func (s *Srv) cancelClear(c tele.Context) error {
+	_ = c.Respond()
+	return c.Edit("Nothing has been removed")
+}
+
+// findItem returns the only item of the list matching the query, or the one picked by its number
+// out of several matches, "mil #2". It returns userError if there is no such item or there are several ones,
+// the error numbers them to pick one
+func (s *Srv) findItem(chatID int64, list, query string) (Item, error) {
+	items, err := s.db.List(chatID, list)
+	if err != nil {
+		return Item{}, fmt.Errorf("can't get items: %w", err)
+	}
+
+	pick := 0
+	if m := pickRx.FindStringSubmatch(query); m != nil {
+		query = m[1]
+		pick, _ = strconv.Atoi(m[2])
+	}
+	matches := matchItems(items, query)
+	switch {
+	case len(matches) == 0:
+		return Item{}, userError(fmt.Sprintf("There is no %q in %s", query, listRef(list)))
+	case pick > 0 && pick <= len(matches):
+		return matches[pick-1], nil
+	case pick > 0:
+		return Item{}, userError(fmt.Sprintf("There are only %d item(s) matching %q", len(matches), query))
+	case len(matches) == 1:
+		return matches[0], nil
+	}
+
+	lines := make([]string, 0, len(matches))
+	for i, m := range matches {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, m.Name))
+	}
+	return Item{}, userError(fmt.Sprintf("Several items match %q:\n%s\nWhich one? Add its number to the query: \"%s #1\"",
+		query, strings.Join(lines, "\n"), query))
+}
+
+// matchItems returns items matching the query regardless of case and whitespace.
+// The item with exactly the same name wins, otherwise all items containing the query,
+// or different from it by a typo or two, are matched
+func matchItems(items []Item, query string) []Item {
+	q := foldName(parseItem(strings.TrimSpace(query)).Name)
+	if q == "" {
+		return nil
+	}
+
+	var fuzzy []Item
+	for _, item := range items {
+		name := foldName(item.Name)
+		if name == q {
+			return []Item{item}
+		}
+		if strings.Contains(name, q) || levenshtein(name, q) <= typos(q) {
+			fuzzy = append(fuzzy, item)
+		}
+	}
+	return fuzzy
+}
+
+// typos is how many typos are tolerated in the query, short words should be typed right
+func typos(q string) int {
+	switch n := utf8.RuneCountInString(q); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// levenshtein returns edit distance between a and b in runes
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(n int, rest ...int) int {
+	for _, m := range rest {
+		if m < n {
+			n = m
+		}
+	}
+	return n
+}
+
+// cutArrow splits "old -> new" into trimmed parts
+func cutArrow(s string) (before, after string, ok bool) {
+	for _, arrow := range []string{"->", "→"} {
+		if before, after, ok = strings.Cut(s, arrow); ok {
+			return strings.TrimSpace(before), strings.TrimSpace(after), true
+		}
+	}
+	return "", "", false
+}
+
+// listRef refers to the list in replies
+func listRef(list string) string {
+	if list == defaultList {
+		return "the list"
+	}
+	return "@" + list
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestMatchItems(t *testing.T) {
+	items := []Item{{Name: "milk"}, {Name: "milk chocolate"}, {Name: "Bread"}, {Name: "tomatoes", Quantity: 2, Unit: "kg"}, {Name: "молоко"}}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"exact name wins", "milk", []string{"milk"}},
+		{"case-insensitive", "BREAD", []string{"Bread"}},
+		{"several matches", "mil", []string{"milk", "milk chocolate"}},
+		{"typo", "tomatos", []string{"tomatoes"}},
+		{"two typos in long word", "tomatoess", []string{"tomatoes"}},
+		{"quantity is ignored", "2 kg tomatoes", []string{"tomatoes"}},
+		{"cyrillic typo", "малоко", []string{"молоко"}},
+		{"short words need no typos", "brd", nil},
+		{"no match", "eggs", nil},
+		{"empty", " ", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, item := range matchItems(items, tt.query) {
+				got = append(got, item.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchItems(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSrv_RemoveEditClear(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	NewServer(db, bot)
+
+	lastReply := func() interface{} { return bot.sent[1][len(bot.sent[1])-1] }
+	items := func() []Item {
+		got, _ := db.List(1, defaultList)
+		return got
+	}
+
+	bot.cmd(t, 1, "/add", "2x milk, milk chocolate, bread, tomatoes 1 kg")
+
+	bot.cmd(t, 1, "/remove", "mil")
+	if got := lastReply(); got != "Several items match \"mil\":\n1. milk\n2. milk chocolate\nWhich one? Add its number to the query: \"mil #1\"" {
+		t.Errorf("/remove of ambiguous item = %q", got)
+	}
+	bot.cmd(t, 1, "/remove", "mil #3")
+	if got := lastReply(); got != `There are only 2 item(s) matching "mil"` {
+		t.Errorf("/remove of missing match = %q", got)
+	}
+	bot.cmd(t, 1, "/remove", "mil #2")
+	if want := []Item{{Name: "milk", Quantity: 2}, {Name: "bread"}, {Name: "tomatoes", Quantity: 1, Unit: "kg"}}; !reflect.DeepEqual(items(), want) {
+		t.Errorf("items after /remove = %v, want %v", items(), want)
+	}
+	bot.cmd(t, 1, "/remove", "eggs")
+	if got := lastReply(); got != `There is no "eggs" in the list` {
+		t.Errorf("/remove of missing item = %q", got)
+	}
+
+	bot.cmd(t, 1, "/undo", "")
+	if got := items(); len(got) != 4 || got[3].Name != "milk chocolate" {
+		t.Errorf("items after /undo of /remove = %v", got)
+	}
+
+	bot.cmd(t, 1, "/edit", "tomatos -> cherry tomatoes")
+	bot.cmd(t, 1, "/edit", "bread -> bread (rye)")
+	bot.cmd(t, 1, "/edit", "mil #2 -> milk")
+	want := []Item{{Name: "milk", Quantity: 3}, {Name: "bread", Note: "rye"}, {Name: "cherry tomatoes", Quantity: 1, Unit: "kg"}}
+	if !reflect.DeepEqual(items(), want) {
+		t.Errorf("items after /edit = %v, want %v", items(), want)
+	}
+	bot.cmd(t, 1, "/edit", "bread")
+	if got := lastReply(); got != "Usage: /edit <item> -> <new item>" {
+		t.Errorf("/edit without new item = %q", got)
+	}
+
+	bot.cmd(t, 1, "/clear", "")
+	msgID := bot.lastID
+	if got := bot.buttons(msgID); !reflect.DeepEqual(got, []string{"Yes, clear", "Cancel"}) {
+		t.Fatalf("/clear buttons = %v", got)
+	}
+	bot.tap(t, 1, msgID, keepListBtn, defaultList)
+	if len(items()) != 3 {
+		t.Errorf("items after cancelled /clear = %v", items())
+	}
+	bot.tap(t, 1, msgID, clearListBtn, defaultList)
+	if got := items(); len(got) != 0 {
+		t.Errorf("items after /clear = %v, want none", got)
+	}
+	if got := bot.edited[msgID]; got != "Removed 3 item(s) from the list, /undo to bring them back" {
+		t.Errorf("/clear confirmation = %q", got)
+	}
+
+	bot.cmd(t, 1, "/undo", "")
+	if !reflect.DeepEqual(items(), want) {
+		t.Errorf("items after /undo of /clear = %v, want %v", items(), want)
+	}
+}
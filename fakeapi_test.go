@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// fakeAPI is tg Bot API server, which records called methods and keeps messages and polls sent by the bot.
+// Updates injected by tests are served by getUpdates, votes change the polls, and methods could be made to fail
+type fakeAPI struct {
+	*httptest.Server
+
+	calls  []string
+	params map[string]map[string]interface{}
+	// sent are messages sent by the bot, by chat id
+	sent map[int64][]*tele.Message
+	// failures are errors returned by the next calls of methods, by method
+	failures     map[string][]apiError
+	updates      []tele.Update
+	lastUpdateID int
+	lastMsgID    int
+	// queued wakes up getUpdates waiting for new updates
+	queued chan struct{}
+	mu     sync.Mutex
+}
+
+// apiError is the error of Bot API method
+type apiError struct {
+	code        int
+	description string
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	api := &fakeAPI{
+		params:   make(map[string]map[string]interface{}),
+		sent:     make(map[int64][]*tele.Message),
+		failures: make(map[string][]apiError),
+		queued:   make(chan struct{}, 1),
+	}
+	api.Server = httptest.NewServer(http.HandlerFunc(api.serve))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func (api *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	params := make(map[string]interface{})
+	_ = json.NewDecoder(r.Body).Decode(&params)
+
+	api.mu.Lock()
+	api.calls = append(api.calls, method)
+	api.params[method] = params
+	var fail *apiError
+	if errs := api.failures[method]; len(errs) > 0 {
+		fail = &errs[0]
+		api.failures[method] = errs[1:]
+	}
+	api.mu.Unlock()
+
+	var result interface{}
+	switch {
+	case fail != nil:
+	case method == "getUpdates":
+		result = api.getUpdates(r.Context(), param(params, "offset"), param(params, "timeout"))
+	default:
+		result, fail = api.call(method, params)
+	}
+
+	if fail != nil {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error_code": fail.code, "description": fail.description})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// call runs the method, methods which aren't faked just succeed
+func (api *fakeAPI) call(method string, params map[string]interface{}) (interface{}, *apiError) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	chatID, _ := strconv.ParseInt(param(params, "chat_id"), 10, 64)
+	msgID, _ := strconv.Atoi(param(params, "message_id"))
+	switch method {
+	case "getMe":
+		return tele.User{ID: 1, IsBot: true, Username: "ShoppingCatBot"}, nil
+	case "sendMessage":
+		return messageJSON(api.store(chatID, &tele.Message{Text: param(params, "text")})), nil
+	case "sendPoll":
+		var options []string
+		_ = json.Unmarshal([]byte(param(params, "options")), &options)
+		p := &tele.Poll{
+			Type:            tele.PollType(param(params, "type")),
+			Question:        param(params, "question"),
+			MultipleAnswers: param(params, "allows_multiple_answers") == "true",
+		}
+		p.AddOptions(options...)
+		msg := api.store(chatID, &tele.Message{Poll: p})
+		p.ID = fmt.Sprintf("poll%d", msg.ID)
+		return messageJSON(msg), nil
+	case "stopPoll":
+		msg := api.message(chatID, msgID)
+		if msg == nil || msg.Poll == nil {
+			return nil, &apiError{400, "Bad Request: message with poll to stop not found"}
+		}
+		if msg.Poll.Closed {
+			return nil, &apiError{400, "Bad Request: poll has already been closed"}
+		}
+		msg.Poll.Closed = true
+		return pollJSON(msg.Poll), nil
+	case "editMessageText", "editMessageReplyMarkup":
+		msg := api.message(chatID, msgID)
+		if msg == nil {
+			return nil, &apiError{400, "Bad Request: message to edit not found"}
+		}
+		if text := param(params, "text"); text != "" {
+			msg.Text = text
+		}
+		return messageJSON(msg), nil
+	}
+	return true, nil
+}
+
+// store keeps the message sent by the bot into the chat. Should be called under lock
+func (api *fakeAPI) store(chatID int64, msg *tele.Message) *tele.Message {
+	api.lastMsgID++
+	msg.ID = api.lastMsgID
+	msg.Chat = chatOf(chatID)
+	msg.Unixtime = time.Now().Unix()
+	api.sent[chatID] = append(api.sent[chatID], msg)
+	return msg
+}
+
+// message finds the message sent by the bot. Should be called under lock
+func (api *fakeAPI) message(chatID int64, msgID int) *tele.Message {
+	for _, msg := range api.sent[chatID] {
+		if msg.ID == msgID {
+			return msg
+		}
+	}
+	return nil
+}
+
+// getUpdates returns updates since the offset, it waits up to timeout seconds for them like tg does
+func (api *fakeAPI) getUpdates(ctx context.Context, offset, timeout string) []tele.Update {
+	from, _ := strconv.Atoi(offset)
+	secs, _ := strconv.Atoi(timeout)
+	wait := time.NewTimer(time.Duration(secs) * time.Second)
+	defer wait.Stop()
+
+	for {
+		api.mu.Lock()
+		// updates before the offset are confirmed, they are never sent again
+		for len(api.updates) > 0 && api.updates[0].ID < from {
+			api.updates = api.updates[1:]
+		}
+		updates := append([]tele.Update{}, api.updates...)
+		api.mu.Unlock()
+		if len(updates) > 0 {
+			return updates
+		}
+
+		select {
+		case <-api.queued:
+		case <-wait.C:
+			return updates
+		case <-ctx.Done():
+			return updates
+		}
+	}
+}
+
+// push queues the update for getUpdates. Should be called under lock
+func (api *fakeAPI) push(u tele.Update) {
+	api.lastUpdateID++
+	u.ID = api.lastUpdateID
+	api.updates = append(api.updates, u)
+	select {
+	case api.queued <- struct{}{}:
+	default:
+	}
+}
+
+// send injects the message of the user into the chat, chats with negative ids are groups
+func (api *fakeAPI) send(chatID int64, u *tele.User, text string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.lastMsgID++
+	api.push(tele.Update{Message: &tele.Message{
+		ID:       api.lastMsgID,
+		Chat:     chatOf(chatID),
+		Sender:   u,
+		Text:     text,
+		Unixtime: time.Now().Unix(),
+	}})
+}
+
+// vote ticks options of the poll in the message on behalf of the user, as tg clients do.
+// The poll counts the votes, and the bot gets the poll answer
+func (api *fakeAPI) vote(t *testing.T, chatID int64, msgID int, u *tele.User, options ...int) {
+	t.Helper()
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	msg := api.message(chatID, msgID)
+	if msg == nil || msg.Poll == nil {
+		t.Fatalf("there is no poll %d in chat %d", msgID, chatID)
+	}
+	if msg.Poll.Closed {
+		t.Fatalf("poll %d in chat %d is closed", msgID, chatID)
+	}
+	for _, i := range options {
+		msg.Poll.Options[i].VoterCount++
+	}
+	msg.Poll.VoterCount++
+	api.push(tele.Update{PollAnswer: &tele.PollAnswer{PollID: msg.Poll.ID, Sender: u, Options: options}})
+}
+
+// fail makes the next call of the method fail with the error, like 403 "Forbidden: bot was kicked from the group chat"
+func (api *fakeAPI) fail(method string, code int, description string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.failures[method] = append(api.failures[method], apiError{code, description})
+}
+
+func (api *fakeAPI) called(method string) bool {
+	return api.count(method) > 0
+}
+
+// count returns the number of calls of the method
+func (api *fakeAPI) count(method string) int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	n := 0
+	for _, m := range api.calls {
+		if m == method {
+			n++
+		}
+	}
+	return n
+}
+
+// messages returns copies of messages sent into the chat, with copies of their polls
+func (api *fakeAPI) messages(chatID int64) []tele.Message {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	msgs := make([]tele.Message, 0, len(api.sent[chatID]))
+	for _, msg := range api.sent[chatID] {
+		m := *msg
+		if msg.Poll != nil {
+			p := *msg.Poll
+			p.Options = append([]tele.PollOption{}, msg.Poll.Options...)
+			m.Poll = &p
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// lastMessage returns the last message sent into the chat
+func (api *fakeAPI) lastMessage(chatID int64) tele.Message {
+	msgs := api.messages(chatID)
+	if len(msgs) == 0 {
+		return tele.Message{}
+	}
+	return msgs[len(msgs)-1]
+}
+
+// messageJSON encodes the message as Bot API does, tele.Poll can't be decoded after json.Marshal
+// because of the type of keyboard buttons. Should be called under lock
+func messageJSON(msg *tele.Message) map[string]interface{} {
+	m := *msg
+	m.Poll = nil
+	data, _ := json.Marshal(m)
+	res := make(map[string]interface{})
+	_ = json.Unmarshal(data, &res)
+	if msg.Poll != nil {
+		res["poll"] = pollJSON(msg.Poll)
+	}
+	return res
+}
+
+func pollJSON(p *tele.Poll) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                      p.ID,
+		"type":                    string(p.Type),
+		"question":                p.Question,
+		"options":                 append([]tele.PollOption{}, p.Options...),
+		"total_voter_count":       p.VoterCount,
+		"is_closed":               p.Closed,
+		"allows_multiple_answers": p.MultipleAnswers,
+	}
+}
+
+func chatOf(id int64) *tele.Chat {
+	if id < 0 {
+		return &tele.Chat{ID: id, Type: tele.ChatSuperGroup, Title: fmt.Sprintf("group %d", -id)}
+	}
+	return &tele.Chat{ID: id, Type: tele.ChatPrivate}
+}
+
+// param returns the string parameter of the method, telebot sends all of them as strings
+func param(params map[string]interface{}, name string) string {
+	s, _ := params[name].(string)
+	return s
+}
+
+// waitFor waits for the condition, updates are handled by the bot in background
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
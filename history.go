@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// historyLimit is how many purchases are kept for every chat, the oldest ones are dropped
+	historyLimit = 1000
+	// historyDays is the default period of /history
+	historyDays = 7
+
+	// tg doesn't allow longer text messages
+	maxMessageLen = 4096
+)
+
+// Purchase is an item removed from the list by /done or by "Clear checked"
+type Purchase struct {
+	Item Item
+	List string
+	// UserID and UserName of the one who checked or ticked the item
+	UserID   int64
+	UserName string
+	Time     time.Time
+}
+
+// userName returns @username if the user has one, or the full name otherwise
+func userName(u *tele.User) string {
+	if u == nil {
+		return ""
+	}
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+// buy removes item from the list and records it into purchase history on behalf of the user.
+// All items bought by a single command share the same time, so they could be undone together
+func (s *Srv) buy(chatID int64, list string, item Item, by *tele.User, at time.Time) error {
+	var userID int64
+	if by != nil {
+		userID = by.ID
+	}
+	return s.buyAs(chatID, list, item, userID, userName(by), at)
+}
+
+// buyAs is buy on behalf of the user known by id and name only, like voters of polls
+// The purchase is saved first, so the item isn't lost if it fails
+func (s *Srv) buyAs(chatID int64, list string, item Item, userID int64, name string, at time.Time) error {
+	p := Purchase{Item: item, List: list, UserID: userID, UserName: name, Time: at}
+	if err := s.db.AddPurchase(chatID, p); err != nil {
+		return fmt.Errorf("can't save purchase: %w", err)
+	}
+	if err := s.db.Remove(chatID, list, item.Name); err != nil {
+		return fmt.Errorf("can't remove item: %w", err)
+	}
+	return nil
+}
+
+// showHistory shows items bought in the last days, the newest first
+func (s *Srv) showHistory(c tele.Context) error {
+	days := historyDays
+	if arg := strings.TrimSpace(cmdPayload(c.Message().Text)); arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return c.Send(fmt.Sprintf("Usage: %s [days]", historyCmd))
+		}
+		days = n
+	}
+
+	history, err := s.db.History(c.Chat().ID, s.now().AddDate(0, 0, -days))
+	if err != nil {
+		return fmt.Errorf("can't get history: %w", err)
+	}
+	if len(history) == 0 {
+		return c.Send(fmt.Sprintf("Nothing has been bought in the last %d day(s)", days))
+	}
+	settings, err := s.db.Settings(c.Chat().ID)
+	if err != nil {
+		return fmt.Errorf("can't get settings: %w", err)
+	}
+
+	return c.Send(formatHistory(history, days, s.location(settings)))
+}
+
+// formatHistory renders purchases grouped by day in the time zone of the chat, the newest first.
+// The oldest purchases are cut if the text doesn't fit into a single message
+func formatHistory(history []Purchase, days int, loc *time.Location) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Bought in the last %d day(s):", days))
+
+	day := ""
+	for i := len(history) - 1; i >= 0; i-- {
+		p := history[i]
+		at := p.Time.In(loc)
+
+		var line strings.Builder
+		if d := at.Format("Mon, 02 Jan"); d != day {
+			day = d
+			line.WriteString("\n\n" + d)
+		}
+		line.WriteString("\n" + at.Format("15:04") + " " + p.Item.String())
+		if p.List != defaultList {
+			line.WriteString(" @" + p.List)
+		}
+		if p.UserName != "" {
+			line.WriteString(" – " + p.UserName)
+		}
+
+		if b.Len()+line.Len() > maxMessageLen-len("\n…") {
+			b.WriteString("\n…")
+			break
+		}
+		b.WriteString(line.String())
+	}
+
+	return b.String()
+}
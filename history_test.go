@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestUserName(t *testing.T) {
+	tests := []struct {
+		name string
+		user *tele.User
+		want string
+	}{
+		{"username", &tele.User{Username: "cat", FirstName: "Tom"}, "@cat"},
+		{"full name", &tele.User{FirstName: "Tom", LastName: "Cat"}, "Tom Cat"},
+		{"first name", &tele.User{FirstName: "Tom"}, "Tom"},
+		{"nil", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := userName(tt.user); got != tt.want {
+				t.Errorf("userName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatHistory(t *testing.T) {
+	day := time.Date(2022, 10, 17, 18, 30, 0, 0, time.UTC)
+	history := []Purchase{
+		{Item: Item{Name: "milk", Quantity: 2}, List: defaultList, UserName: "@cat", Time: day},
+		{Item: Item{Name: "screws"}, List: "hardware", Time: day.Add(time.Hour)},
+		{Item: Item{Name: "bread"}, List: defaultList, UserName: "Tom", Time: day.AddDate(0, 0, 1)},
+	}
+
+	want := "Bought in the last 7 day(s):\n\n" +
+		"Tue, 18 Oct\n18:30 bread – Tom\n\n" +
+		"Mon, 17 Oct\n19:30 screws @hardware\n18:30 milk ×2 – @cat"
+	if got := formatHistory(history, 7, time.UTC); got != want {
+		t.Errorf("formatHistory() = %q, want %q", got, want)
+	}
+
+	// times are shown in the time zone of the chat
+	want = "Bought in the last 7 day(s):\n\n" +
+		"Wed, 19 Oct\n05:30 bread – Tom\n\n" +
+		"Tue, 18 Oct\n06:30 screws @hardware\n05:30 milk ×2 – @cat"
+	if got := formatHistory(history, 7, time.FixedZone("UTC+11", 11*60*60)); got != want {
+		t.Errorf("formatHistory() in UTC+11 = %q, want %q", got, want)
+	}
+
+	var long []Purchase
+	for i := 0; i < 500; i++ {
+		long = append(long, Purchase{Item: Item{Name: strings.Repeat("x", 20)}, List: defaultList, Time: day})
+	}
+	got := formatHistory(long, 7, time.UTC)
+	if len(got) > maxMessageLen || !strings.HasSuffix(got, "\n…") {
+		t.Errorf("formatHistory() of long history has %d bytes, ends with %q", len(got), got[len(got)-10:])
+	}
+}
+
+func TestSrv_History(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	srv := NewServer(db, bot)
+	now := time.Date(2022, 10, 17, 18, 30, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+
+	bot.cmd(t, 1, "/history", "")
+	if got := bot.sent[1][len(bot.sent[1])-1]; got != "Nothing has been bought in the last 7 day(s)" {
+		t.Errorf("empty /history = %q", got)
+	}
+
+	bot.cmd(t, 1, "/add", "2x milk, bread, eggs")
+	bot.cmd(t, 1, "/list", "")
+	bot.vote(1, "milk ×2")
+	bot.vote(1, "bread")
+	// bread is ticked by another user, the purchase is theirs
+	bot.answer(t, bot.opened[1][0], &tele.User{ID: 7, Username: "dog"}, 0)
+	bot.cmd(t, 1, "/done", "")
+
+	now = now.AddDate(0, 0, -10)
+	bot.cmd(t, 1, "/view", "checklist")
+	bot.cmd(t, 1, "/list", "")
+	bot.tap(t, 1, bot.lastID, checkBtn, "default|0")
+	bot.tap(t, 1, bot.lastID, clearBtn, "default")
+	now = now.AddDate(0, 0, 10)
+
+	bot.cmd(t, 1, "/history", "")
+	if got, want := bot.sent[1][len(bot.sent[1])-1], "Bought in the last 7 day(s):\n\nMon, 17 Oct\n18:30 milk ×2 – @cat\n18:30 bread – @dog"; got != want {
+		t.Errorf("/history = %q, want %q", got, want)
+	}
+	bot.cmd(t, 1, "/history", "30")
+	if got, want := bot.sent[1][len(bot.sent[1])-1], "Bought in the last 30 day(s):\n\nMon, 17 Oct\n18:30 milk ×2 – @cat\n18:30 bread – @dog\n\nFri, 07 Oct\n18:30 eggs – @cat"; got != want {
+		t.Errorf("/history 30 = %q, want %q", got, want)
+	}
+	bot.cmd(t, 1, "/history", "week")
+	if got := bot.sent[1][len(bot.sent[1])-1]; got != "Usage: /history [days]" {
+		t.Errorf("/history week = %q", got)
+	}
+
+	// history belongs to the chat
+	if got, _ := db.History(2, time.Time{}); len(got) != 0 {
+		t.Errorf("history of the other chat = %v", got)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// inlineCacheTime is how long tg caches results of inline queries, in seconds
+	inlineCacheTime = 10
+	// inlineOfferTTL is how long a result of inline query could be picked, it's much longer than inlineCacheTime
+	inlineOfferTTL = 10 * time.Minute
+	// tg doesn't allow more results per inline query
+	maxInlineResults = 50
+	// maxInlineSuggestions is how many items bought before are suggested
+	maxInlineSuggestions = 5
+	// suggestDays is the period of purchase history, suggestions are taken from
+	suggestDays = 90
+	// membershipTTL is how long membership of the user in the chat is trusted by inline queries,
+	// tg sends a query on every keystroke and checking every chat each time runs into flood limits
+	membershipTTL = time.Minute
+)
+
+// UserChat is a chat where the user talked to the bot, inline queries of the user work with its lists
+type UserChat struct {
+	ID int64
+	// Title is empty for private chats
+	Title string
+}
+
+// name of the chat shown in inline results
+func (uc UserChat) name() string {
+	if uc.Title == "" {
+		return "private chat"
+	}
+	return uc.Title
+}
+
+type userChatKey struct {
+	userID, chatID int64
+}
+
+// rememberChat saves the chat of every update as a chat of its sender, so inline queries could offer its lists
+func (s *Srv) rememberChat(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if err := s.saveUserChat(c.Sender(), c.Chat()); err != nil {
+			s.log(c).Warn("can't remember chat", "err", err)
+		}
+		return next(c)
+	}
+}
+
+// saveUserChat saves the chat of the user, unless it's already saved with the same title
+func (s *Srv) saveUserChat(u *tele.User, chat *tele.Chat) error {
+	if u == nil || chat == nil || u.IsBot {
+		return nil
+	}
+	uc := UserChat{ID: chat.ID, Title: chat.Title}
+	key := userChatKey{u.ID, chat.ID}
+
+	s.seenMu.Lock()
+	defer s.seenMu.Unlock()
+	if title, ok := s.seen[key]; ok && title == uc.Title {
+		return nil
+	}
+	if err := s.db.SaveUserChat(u.ID, uc); err != nil {
+		return fmt.Errorf("can't save chat of user: %w", err)
+	}
+	s.seen[key] = uc.Title
+	return nil
+}
+
+// inlineOffer is what happens when the user picks a result of inline query: items are added to the list
+// of the chat. Offers without items, like shared lists, only post their text, nothing has to be done
+type inlineOffer struct {
+	userID int64
+	chatID int64
+	list   string
+	items  []Item
+	at     time.Time
+}
+
+// inlineOffers keeps results of recent inline queries, since tg tells only id of the picked one
+type inlineOffers struct {
+	offers map[string]inlineOffer
+	seq    uint64
+
+	mu sync.Mutex
+}
+
+func newInlineOffers() *inlineOffers {
+	return &inlineOffers{offers: make(map[string]inlineOffer)}
+}
+
+// add remembers the offer and returns id of its result. Offers older than inlineOfferTTL are dropped
+func (o *inlineOffers) add(offer inlineOffer) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for id, old := range o.offers {
+		if offer.at.Sub(old.at) > inlineOfferTTL {
+			delete(o.offers, id)
+		}
+	}
+	o.seq++
+	id := strconv.FormatUint(o.seq, 36)
+	o.offers[id] = offer
+	return id
+}
+
+// get returns the offer made to userID, ok is false if there is no such offer or it's expired
+func (o *inlineOffers) get(id string, userID int64) (offer inlineOffer, ok bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	offer, ok = o.offers[id]
+	return offer, ok && offer.userID == userID
+}
+
+// membership is the result of the check if the user is a member of the chat
+type membership struct {
+	member bool
+	at     time.Time
+}
+
+// memberships caches membership of users in their chats for inline queries
+type memberships struct {
+	checked map[userChatKey]membership
+
+	mu sync.Mutex
+}
+
+func newMemberships() *memberships {
+	return &memberships{checked: make(map[userChatKey]membership)}
+}
+
+// set remembers membership checked at the time. Checks older than membershipTTL are dropped
+func (m *memberships) set(key userChatKey, member bool, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, old := range m.checked {
+		if at.Sub(old.at) > membershipTTL {
+			delete(m.checked, k)
+		}
+	}
+	m.checked[key] = membership{member: member, at: at}
+}
+
+// get returns membership checked not earlier than membershipTTL before now, ok is false if there is none
+func (m *memberships) get(key userChatKey, now time.Time) (member, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ms, ok := m.checked[key]
+	if !ok || now.Sub(ms.at) > membershipTTL {
+		return false, false
+	}
+	return ms.member, true
+}
+
+// answerQuery offers to add items of the inline query to lists of chats of the user, suggests items
+// bought in these chats before and offers to share their active lists
+func (s *Srv) answerQuery(c tele.Context) error {
+	q := c.Query()
+	chats, err := s.db.UserChats(q.Sender.ID)
+	if err != nil {
+		return fmt.Errorf("can't get chats of user: %w", err)
+	}
+	chats = s.memberChats(c, q.Sender, chats)
+	resp := &tele.QueryResponse{CacheTime: inlineCacheTime, IsPersonal: true}
+	if len(chats) == 0 {
+		resp.SwitchPMText = "Start a shopping list"
+		resp.SwitchPMParameter = "inline"
+		return c.Answer(resp)
+	}
+
+	offer := func(o inlineOffer, r *tele.ArticleResult) {
+		if len(resp.Results) >= maxInlineResults {
+			return
+		}
+		o.userID, o.at = q.Sender.ID, s.now()
+		r.SetResultID(s.offers.add(o))
+		resp.Results = append(resp.Results, r)
+	}
+
+	items := parseItems(q.Text)
+	if len(items) > 0 {
+		names := itemNames(items)
+		for _, uc := range chats {
+			lists, err := s.inlineLists(uc.ID)
+			if err != nil {
+				return err
+			}
+			for _, list := range lists {
+				where := fmt.Sprintf("%s (%s)", listTitle(list), uc.name())
+				offer(inlineOffer{chatID: uc.ID, list: list, items: items}, &tele.ArticleResult{
+					Title:       "Add to " + where,
+					Description: names,
+					Text:        fmt.Sprintf("Added to %s: %s", where, names),
+				})
+			}
+		}
+	}
+
+	suggestions, err := s.suggestItems(chats, q.Text)
+	if err != nil {
+		return err
+	}
+	for _, sg := range suggestions {
+		where := fmt.Sprintf("%s (%s)", listTitle(sg.list), sg.chat.name())
+		offer(inlineOffer{chatID: sg.chat.ID, list: sg.list, items: []Item{{Name: sg.name}}}, &tele.ArticleResult{
+			Title:       sg.name,
+			Description: fmt.Sprintf("Bought %d time(s), add to %s", sg.bought, where),
+			Text:        fmt.Sprintf("Added to %s: %s", where, sg.name),
+		})
+	}
+
+	for _, uc := range chats {
+		list, err := s.activeList(uc.ID)
+		if err != nil {
+			return err
+		}
+		listItems, _, err := s.listItems(uc.ID, list)
+		if err != nil {
+			return err
+		}
+		if len(listItems) == 0 {
+			continue
+		}
+		offer(inlineOffer{chatID: uc.ID, list: list}, &tele.ArticleResult{
+			Title:       fmt.Sprintf("Share %s (%s)", listTitle(list), uc.name()),
+			Description: itemNames(listItems),
+			Text:        formatList(list, listItems),
+		})
+	}
+
+	return c.Answer(resp)
+}
+
+// pickResult adds items of the picked inline result to its list and tells the chat of the list about it.
+// Results are picked in any chat, so the reply goes to the chat of the list
+func (s *Srv) pickResult(c tele.Context) error {
+	r := c.InlineResult()
+	o, ok := s.offers.get(r.ResultID, r.Sender.ID)
+	if !ok {
+		s.log(c).Info("inline result is unknown or expired", "result", r.ResultID)
+		return nil
+	}
+	if len(o.items) == 0 {
+		return nil
+	}
+	// the user could have left the chat since the query
+	member, err := s.isMember(o.chatID, r.Sender)
+	if err != nil {
+		return err
+	}
+	if !member {
+		s.log(c).Info("inline result of the chat the user has left", "list_chat", o.chatID)
+		return nil
+	}
+	reply, err := s.addToList(o.chatID, o.list, o.items)
+	if err != nil {
+		return err
+	}
+	return s.send(&tele.Chat{ID: o.chatID}, fmt.Sprintf("%s, by %s:\n%s", listTitle(o.list), userName(r.Sender), reply))
+}
+
+// memberChats returns chats the user is still a member of, users who left groups or were removed from them
+// can't see and change their lists anymore. Membership checked recently is trusted, chats which membership
+// can't be checked are skipped
+func (s *Srv) memberChats(c tele.Context, u *tele.User, chats []UserChat) []UserChat {
+	res := make([]UserChat, 0, len(chats))
+	for _, uc := range chats {
+		member, ok := s.members.get(userChatKey{u.ID, uc.ID}, s.now())
+		if !ok {
+			var err error
+			if member, err = s.isMember(uc.ID, u); err != nil {
+				s.log(c).Warn("can't check membership, the chat is skipped", "list_chat", uc.ID, "err", err)
+				continue
+			}
+		}
+		if member {
+			res = append(res, uc)
+		}
+	}
+	return res
+}
+
+// isMember reports if the user is a member of the chat and caches it for inline queries.
+// The private chat with the bot has the id of its user
+func (s *Srv) isMember(chatID int64, u *tele.User) (bool, error) {
+	if chatID == u.ID {
+		return true, nil
+	}
+	member, err := s.bot.ChatMemberOf(&tele.Chat{ID: chatID}, u)
+	if err != nil {
+		return false, fmt.Errorf("can't get chat member: %w", err)
+	}
+	var ok bool
+	switch member.Role {
+	case tele.Creator, tele.Administrator, tele.Member, tele.Restricted:
+		ok = true
+	}
+	s.members.set(userChatKey{u.ID, chatID}, ok, s.now())
+	return ok, nil
+}
+
+// inlineLists returns lists of the chat, the active one first
+func (s *Srv) inlineLists(chatID int64) ([]string, error) {
+	active, err := s.activeList(chatID)
+	if err != nil {
+		return nil, err
+	}
+	lists, err := s.db.Lists(chatID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get lists: %w", err)
+	}
+	res := []string{active}
+	for _, list := range lists {
+		if list != active {
+			res = append(res, list)
+		}
+	}
+	return res, nil
+}
+
+// suggestion is an item bought in the chat before, it's added to the active list of the chat
+type suggestion struct {
+	chat   UserChat
+	list   string
+	name   string
+	bought int
+}
+
+// suggestItems returns items bought in the chats, which names start with the text, the most bought first.
+// Items already on the lists aren't suggested, neither is the text itself
+func (s *Srv) suggestItems(chats []UserChat, text string) ([]suggestion, error) {
+	prefix := foldName(strings.TrimSpace(text))
+	var res []suggestion
+	for _, uc := range chats {
+		history, err := s.db.History(uc.ID, s.now().AddDate(0, 0, -suggestDays))
+		if err != nil {
+			return nil, fmt.Errorf("can't get history: %w", err)
+		}
+		list, err := s.activeList(uc.ID)
+		if err != nil {
+			return nil, err
+		}
+		items, err := s.db.List(uc.ID, list)
+		if err != nil {
+			return nil, fmt.Errorf("can't get items: %w", err)
+		}
+
+		found := make(map[string]int)
+		// the newest purchases go first, so the suggestion takes the latest spelling of the name
+		for i := len(history) - 1; i >= 0; i-- {
+			name := history[i].Item.Name
+			key := s.itemKey(name)
+			if !strings.HasPrefix(foldName(name), prefix) || key == s.itemKey(text) || s.hasItem(items, name) {
+				continue
+			}
+			if j, ok := found[key]; ok {
+				res[j].bought++
+				continue
+			}
+			found[key] = len(res)
+			res = append(res, suggestion{chat: uc, list: list, name: name, bought: 1})
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].bought > res[j].bought })
+	if len(res) > maxInlineSuggestions {
+		res = res[:maxInlineSuggestions]
+	}
+	return res, nil
+}
+
+// formatList renders the list as a plain text message.
+// The last items are cut if the text doesn't fit into a single message
+func formatList(list string, items []Item) string {
+	var b strings.Builder
+	b.WriteString(listTitle(list) + ":")
+	for _, item := range items {
+		line := "\n• " + item.String()
+		if b.Len()+len(line) > maxMessageLen-len("\n…") {
+			b.WriteString("\n…")
+			break
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// itemNames joins items with their quantities
+func itemNames(items []Item) string {
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, item.String())
+	}
+	return strings.Join(names, ", ")
+}
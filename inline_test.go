@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestSrv_Inline(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	srv := NewServer(db, bot)
+	now := time.Date(2022, 10, 17, 15, 0, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+
+	family := &tele.Chat{ID: -100, Title: "Family"}
+	titles := func(resp *tele.QueryResponse) []string {
+		var got []string
+		for _, r := range resp.Results {
+			got = append(got, r.(*tele.ArticleResult).Title)
+		}
+		return got
+	}
+	lastReply := func(chatID int64) interface{} { return bot.sent[chatID][len(bot.sent[chatID])-1] }
+
+	// the user hasn't talked to the bot yet
+	if resp := bot.inline(t, testUser, "milk"); len(resp.Results) != 0 || resp.SwitchPMText == "" {
+		t.Errorf("inline answer of unknown user = %+v", resp)
+	}
+
+	bot.cmd(t, testUser.ID, "/add", "bread")
+	bot.cmdIn(t, family, testUser, "/add", "eggs")
+	bot.cmdIn(t, family, testUser, "/newlist", "hardware")
+	for _, name := range []string{"Milk", "mince", "Milk", "eggs"} {
+		if err := db.AddPurchase(family.ID, Purchase{Item: Item{Name: name}, Time: now.Add(-time.Hour)}); err != nil {
+			t.Fatalf("can't add purchase: %v", err)
+		}
+	}
+
+	resp := bot.inline(t, testUser, "mi")
+	want := []string{
+		"Add to Shopping list (private chat)",
+		"Add to Shopping list (Family)",
+		"Add to Shopping list @hardware (Family)",
+		"Milk",
+		"mince",
+		"Share Shopping list (private chat)",
+		"Share Shopping list (Family)",
+	}
+	if got := titles(resp); !reflect.DeepEqual(got, want) {
+		t.Fatalf("inline results = %q, want %q", got, want)
+	}
+	if !resp.IsPersonal {
+		t.Errorf("inline results are shared between users")
+	}
+	// membership is checked once for the following queries
+	checks := bot.memberChecks
+	bot.inline(t, testUser, "mil")
+	if bot.memberChecks != checks {
+		t.Errorf("membership has been checked %d more time(s) by the next query", bot.memberChecks-checks)
+	}
+	milk := resp.Results[3].(*tele.ArticleResult)
+	if milk.Description != "Bought 2 time(s), add to Shopping list (Family)" {
+		t.Errorf("suggestion description = %q", milk.Description)
+	}
+	if share := resp.Results[5].(*tele.ArticleResult); share.Text != "Shopping list:\n• bread" {
+		t.Errorf("shared list = %q", share.Text)
+	}
+
+	// somebody else can't pick results of the user
+	bot.pick(t, &tele.User{ID: 7}, milk.ResultID())
+	if items, _ := db.List(family.ID, defaultList); len(items) != 1 {
+		t.Errorf("result of another user has been picked, items: %v", items)
+	}
+
+	bot.pick(t, testUser, milk.ResultID())
+	if got := lastReply(family.ID); got != "Shopping list, by @cat:\nAdded 1 item(s): Milk" {
+		t.Errorf("reply to picked suggestion = %q", got)
+	}
+	sent := len(bot.sent[testUser.ID])
+	bot.pick(t, testUser, resp.Results[5].ResultID())
+	if len(bot.sent[testUser.ID]) != sent {
+		t.Errorf("shared list has been posted by the bot: %v", bot.sent[testUser.ID][sent:])
+	}
+
+	resp = bot.inline(t, testUser, "2 screws")
+	bot.pick(t, testUser, resp.Results[2].ResultID())
+	if items, _ := db.List(family.ID, "hardware"); !reflect.DeepEqual(items, []Item{{Name: "screws", Quantity: 2}}) {
+		t.Errorf("hardware list = %v", items)
+	}
+
+	// the user removed from the group can't see its lists or add items to them anymore
+	resp = bot.inline(t, testUser, "tea")
+	bot.kicked[family.ID] = []int64{testUser.ID}
+	bot.pick(t, testUser, resp.Results[1].ResultID())
+	if items, _ := db.List(family.ID, defaultList); len(items) != 2 {
+		t.Errorf("item has been added by removed member, items: %v", items)
+	}
+	want = []string{"Add to Shopping list (private chat)", "Share Shopping list (private chat)"}
+	if got := titles(bot.inline(t, testUser, "tea")); !reflect.DeepEqual(got, want) {
+		t.Errorf("inline results of removed member = %q, want %q", got, want)
+	}
+	bot.kicked[family.ID] = nil
+
+	// results are forgotten after a while
+	now = now.Add(inlineOfferTTL + time.Minute)
+	bot.inline(t, testUser, "")
+	bot.pick(t, testUser, resp.Results[0].ResultID())
+	if items, _ := db.List(testUser.ID, defaultList); len(items) != 1 {
+		t.Errorf("expired result has been picked, items: %v", items)
+	}
+
+	// the shared list is cut to fit into a message
+	var long []string
+	for i := 0; i < 500; i++ {
+		long = append(long, fmt.Sprintf("item%d", i))
+	}
+	bot.cmd(t, testUser.ID, "/add", strings.Join(long, ", "))
+	var share *tele.ArticleResult
+	for _, r := range bot.inline(t, testUser, "").Results {
+		if r := r.(*tele.ArticleResult); r.Title == "Share Shopping list (private chat)" {
+			share = r
+		}
+	}
+	if share == nil {
+		t.Fatalf("long list isn't shared")
+	}
+	if len(share.Text) > maxMessageLen || !strings.HasSuffix(share.Text, "\n…") {
+		t.Errorf("shared long list has %d bytes, ends with %q", len(share.Text), share.Text[len(share.Text)-10:])
+	}
+}
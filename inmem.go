@@ -0,0 +1,705 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Inmem is in-memory implementation of ItemStorager
+type Inmem struct {
+	// items of every named list of every chat
+	items     map[int64]map[string][]Item
+	settings  map[int64]ChatSettings
+	history   map[int64][]Purchase
+	undo      map[int64][]UndoEntry
+	polls     map[string]OpenPoll
+	staples   map[int64]Staple
+	reminders map[int64]Reminder
+	// recipes of every chat by their folded names
+	recipes map[int64]map[string]Recipe
+	// userChats are chats of every user in order they were saved first
+	userChats map[int64][]UserChat
+	// limits are quotas of every chat, they aren't checked on Restore
+	limits Limits
+	// path of gob dump, dumpFile if empty
+	path string
+	// backups is the number of previous dumps kept next to the dump
+	backups int
+	// metrics count dumps, nil ones don't
+	metrics *metrics
+
+	// version is increased on every change, saved is the version of the last dump
+	version, saved uint64
+
+	mu *sync.Mutex
+	// dumpMu doesn't let two dumps rotate backups at the same time
+	dumpMu sync.Mutex
+}
+
+var _ ItemStorager = (*Inmem)(nil)
+
+// Add adds item into the list of chatID, or merges it with the same item
+func (db *Inmem) Add(chatID int64, list string, item Item) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.limits.checkItem(item); err != nil {
+		return err
+	}
+	db.version++
+	return db.add(chatID, list, item, db.limits)
+}
+
+// PutBack adds items removed before into the list of chatID regardless of quotas
+func (db *Inmem) PutBack(chatID int64, list string, items []Item) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	for _, item := range items {
+		if err := db.add(chatID, list, item, Limits{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add merges item with the same one in the list of chatID or appends it if the list has room by limits
+func (db *Inmem) add(chatID int64, list string, item Item, limits Limits) error {
+	lists := db.chatLists(chatID)
+	for i, existing := range lists[list] {
+		if merged, ok := mergeItems(existing, item); ok {
+			lists[list][i] = merged
+			return nil
+		}
+	}
+	if err := limits.checkItems(len(lists[list])); err != nil {
+		return err
+	}
+	lists[list] = append(lists[list], item)
+	return nil
+}
+
+// Remove removes item from the list of chatID
+func (db *Inmem) Remove(chatID int64, list, name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	// todo: ya ya, it's full scan now, but who cares until you have 999k shopping list?
+	if l, ok := db.items[chatID][list]; ok {
+		for i := 0; i < len(l); i++ {
+			if sameItem(l[i].Name, name) {
+				db.items[chatID][list] = append(l[:i], l[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Take removes item from the list of chatID and returns it
+func (db *Inmem) Take(chatID int64, list, name string) (Item, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	l := db.items[chatID][list]
+	for i := range l {
+		if sameItem(l[i].Name, name) {
+			item := l[i]
+			db.items[chatID][list] = append(l[:i], l[i+1:]...)
+			return item, true, nil
+		}
+	}
+	return Item{}, false, nil
+}
+
+// Replace puts item in place of the one with name, or merges it into the same item
+func (db *Inmem) Replace(chatID int64, list, name string, item Item) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.limits.checkItem(item); err != nil {
+		return false, err
+	}
+	db.version++
+	l := db.items[chatID][list]
+	i := -1
+	for j := range l {
+		if sameItem(l[j].Name, name) {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		return false, nil
+	}
+
+	for j := range l {
+		if j == i {
+			continue
+		}
+		if merged, ok := mergeItems(l[j], item); ok {
+			l[j] = merged
+			db.items[chatID][list] = append(l[:i], l[i+1:]...)
+			return true, nil
+		}
+	}
+	l[i] = item
+	return true, nil
+}
+
+// Clear removes all items from the list of chatID, the list itself is kept
+func (db *Inmem) Clear(chatID int64, list string) ([]Item, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	items := db.items[chatID][list]
+	if len(items) > 0 {
+		db.items[chatID][list] = []Item{}
+	}
+	return items, nil
+}
+
+// GetAll return bunches of items from the list of chatID
+func (db *Inmem) GetAll(chatID int64, list string) ([][]Item, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return makeBunches(db.items[chatID][list]), nil
+}
+
+// List returns copy of items from the list of chatID
+func (db *Inmem) List(chatID int64, list string) ([]Item, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]Item(nil), db.items[chatID][list]...), nil
+}
+
+// CreateList creates new empty list in chatID
+func (db *Inmem) CreateList(chatID int64, list string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	lists := db.chatLists(chatID)
+	if _, ok := lists[list]; ok || list == defaultList {
+		return fmt.Errorf("%w: %s", errListExists, list)
+	}
+	n := 1
+	for name := range lists {
+		if name != defaultList {
+			n++
+		}
+	}
+	if err := db.limits.checkLists(n); err != nil {
+		return err
+	}
+	lists[list] = []Item{}
+	return nil
+}
+
+// Lists returns names of all lists of chatID, the default one goes first
+func (db *Inmem) Lists(chatID int64) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	names := []string{defaultList}
+	for name := range db.items[chatID] {
+		if name != defaultList {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names, nil
+}
+
+// ItemCounts returns the number of items in all lists of every chat which has any
+func (db *Inmem) ItemCounts() (map[int64]int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	counts := make(map[int64]int)
+	for chatID, lists := range db.items {
+		for _, items := range lists {
+			if len(items) > 0 {
+				counts[chatID] += len(items)
+			}
+		}
+	}
+	return counts, nil
+}
+
+// chatLists returns lists of chatID, creating the map if it's needed. Should be called under lock
+func (db *Inmem) chatLists(chatID int64) map[string][]Item {
+	if db.items[chatID] == nil {
+		db.items[chatID] = make(map[string][]Item)
+	}
+	return db.items[chatID]
+}
+
+// AddPurchase records item bought in chatID and drops the oldest purchases over historyLimit
+func (db *Inmem) AddPurchase(chatID int64, p Purchase) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	if db.history == nil {
+		db.history = make(map[int64][]Purchase)
+	}
+	h := append(db.history[chatID], p)
+	if len(h) > historyLimit {
+		h = append([]Purchase(nil), h[len(h)-historyLimit:]...)
+	}
+	db.history[chatID] = h
+	return nil
+}
+
+// History returns purchases made in chatID since the time, the oldest first
+func (db *Inmem) History(chatID int64, since time.Time) ([]Purchase, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var res []Purchase
+	for _, p := range db.history[chatID] {
+		if !p.Time.Before(since) {
+			res = append(res, p)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Time.Before(res[j].Time) })
+	return res, nil
+}
+
+// RemovePurchases removes purchases made in chatID at exactly that time
+func (db *Inmem) RemovePurchases(chatID int64, at time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	var rest []Purchase
+	for _, p := range db.history[chatID] {
+		if !p.Time.Equal(at) {
+			rest = append(rest, p)
+		}
+	}
+	if db.history != nil {
+		db.history[chatID] = rest
+	}
+	return nil
+}
+
+// RemovePurchase removes the latest purchase of the item of the list made in chatID at exactly that time
+func (db *Inmem) RemovePurchase(chatID int64, list, name string, at time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	h := db.history[chatID]
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].List == list && h[i].Item.Name == name && h[i].Time.Equal(at) {
+			db.version++
+			db.history[chatID] = append(h[:i:i], h[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// PushUndo adds entry into the undo journal of chatID and drops the oldest entries over undoLimit
+func (db *Inmem) PushUndo(chatID int64, e UndoEntry) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	if db.undo == nil {
+		db.undo = make(map[int64][]UndoEntry)
+	}
+	j := append(db.undo[chatID], e)
+	if len(j) > undoLimit {
+		j = append([]UndoEntry(nil), j[len(j)-undoLimit:]...)
+	}
+	db.undo[chatID] = j
+	return nil
+}
+
+// PopUndo takes the last entry from the undo journal of chatID
+func (db *Inmem) PopUndo(chatID int64) (UndoEntry, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	j := db.undo[chatID]
+	if len(j) == 0 {
+		return UndoEntry{}, false, nil
+	}
+	db.undo[chatID] = j[:len(j)-1]
+	return j[len(j)-1], true, nil
+}
+
+// SaveOpenPoll saves poll or replaces the one with the same id
+func (db *Inmem) SaveOpenPoll(p OpenPoll) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	if db.polls == nil {
+		db.polls = make(map[string]OpenPoll)
+	}
+	db.polls[p.ID] = p
+	return nil
+}
+
+// OpenPoll returns poll by its tg id
+func (db *Inmem) OpenPoll(pollID string) (OpenPoll, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	p, ok := db.polls[pollID]
+	if !ok {
+		return OpenPoll{}, false, nil
+	}
+	return copyOpenPoll(p), true, nil
+}
+
+// OpenPolls returns open polls of the list of chatID ordered by message
+func (db *Inmem) OpenPolls(chatID int64, list string) ([]OpenPoll, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var polls []OpenPoll
+	for _, p := range db.polls {
+		if p.ChatID == chatID && p.List == list {
+			polls = append(polls, copyOpenPoll(p))
+		}
+	}
+	sort.Slice(polls, func(i, j int) bool { return polls[i].MessageID < polls[j].MessageID })
+	return polls, nil
+}
+
+// AllOpenPolls returns open polls of all chats
+func (db *Inmem) AllOpenPolls() ([]OpenPoll, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	polls := make([]OpenPoll, 0, len(db.polls))
+	for _, p := range db.polls {
+		polls = append(polls, copyOpenPoll(p))
+	}
+	sort.Slice(polls, func(i, j int) bool {
+		if polls[i].ChatID != polls[j].ChatID {
+			return polls[i].ChatID < polls[j].ChatID
+		}
+		return polls[i].MessageID < polls[j].MessageID
+	})
+	return polls, nil
+}
+
+// copyOpenPoll copies items of the poll, so the caller could change them without lock
+func copyOpenPoll(p OpenPoll) OpenPoll {
+	items := make([]PollItem, len(p.Items))
+	for i, pi := range p.Items {
+		items[i] = pi
+		items[i].Voters = append([]int64(nil), pi.Voters...)
+		if pi.Names != nil {
+			items[i].Names = make(map[int64]string, len(pi.Names))
+			for id, name := range pi.Names {
+				items[i].Names[id] = name
+			}
+		}
+	}
+	p.Items = items
+	return p
+}
+
+// DeleteOpenPoll forgets the poll
+func (db *Inmem) DeleteOpenPoll(pollID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	delete(db.polls, pollID)
+	return nil
+}
+
+// SaveStaple adds new staple, if its ID is zero, or replaces the one with the same ID. It returns ID of the staple
+func (db *Inmem) SaveStaple(st Staple) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	if db.staples == nil {
+		db.staples = make(map[int64]Staple)
+	}
+	if st.ID == 0 {
+		for id := range db.staples {
+			if id > st.ID {
+				st.ID = id
+			}
+		}
+		st.ID++
+	}
+	db.staples[st.ID] = st
+	return st.ID, nil
+}
+
+// Staples returns staples of chatID ordered by ID
+func (db *Inmem) Staples(chatID int64) ([]Staple, error) {
+	return db.selectStaples(func(st Staple) bool { return st.ChatID == chatID }), nil
+}
+
+// DueStaples returns staples of all chats which should be added to their lists at or before the time
+func (db *Inmem) DueStaples(at time.Time) ([]Staple, error) {
+	return db.selectStaples(func(st Staple) bool { return !st.Next.After(at) }), nil
+}
+
+func (db *Inmem) selectStaples(ok func(st Staple) bool) []Staple {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var staples []Staple
+	for _, st := range db.staples {
+		if ok(st) {
+			staples = append(staples, st)
+		}
+	}
+	sort.Slice(staples, func(i, j int) bool { return staples[i].ID < staples[j].ID })
+	return staples
+}
+
+// DeleteStaple forgets the staple of chatID and reports if there was one
+func (db *Inmem) DeleteStaple(chatID, id int64) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	st, ok := db.staples[id]
+	if !ok || st.ChatID != chatID {
+		return false, nil
+	}
+	db.version++
+	delete(db.staples, id)
+	return true, nil
+}
+
+// SaveReminder adds new reminder, if its ID is zero, or replaces the one with the same ID. It returns ID of the reminder
+func (db *Inmem) SaveReminder(r Reminder) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	if db.reminders == nil {
+		db.reminders = make(map[int64]Reminder)
+	}
+	if r.ID == 0 {
+		for id := range db.reminders {
+			if id > r.ID {
+				r.ID = id
+			}
+		}
+		r.ID++
+	}
+	db.reminders[r.ID] = r
+	return r.ID, nil
+}
+
+// Reminders returns reminders of chatID ordered by ID
+func (db *Inmem) Reminders(chatID int64) ([]Reminder, error) {
+	return db.selectReminders(func(r Reminder) bool { return r.ChatID == chatID }), nil
+}
+
+// DueReminders returns reminders of all chats which should go off at or before the time
+func (db *Inmem) DueReminders(at time.Time) ([]Reminder, error) {
+	return db.selectReminders(func(r Reminder) bool { return !r.Next.After(at) }), nil
+}
+
+func (db *Inmem) selectReminders(ok func(r Reminder) bool) []Reminder {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var reminders []Reminder
+	for _, r := range db.reminders {
+		if ok(r) {
+			reminders = append(reminders, r)
+		}
+	}
+	sort.Slice(reminders, func(i, j int) bool { return reminders[i].ID < reminders[j].ID })
+	return reminders
+}
+
+// DeleteReminder forgets the reminder of chatID and reports if there was one
+func (db *Inmem) DeleteReminder(chatID, id int64) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	r, ok := db.reminders[id]
+	if !ok || r.ChatID != chatID {
+		return false, nil
+	}
+	db.version++
+	delete(db.reminders, id)
+	return true, nil
+}
+
+// SaveRecipe saves recipe of chatID or replaces the one with the same name regardless of case
+func (db *Inmem) SaveRecipe(chatID int64, r Recipe) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	if db.recipes == nil {
+		db.recipes = make(map[int64]map[string]Recipe)
+	}
+	if db.recipes[chatID] == nil {
+		db.recipes[chatID] = make(map[string]Recipe)
+	}
+	r.Items = append([]Item(nil), r.Items...)
+	db.recipes[chatID][foldName(r.Name)] = r
+	return nil
+}
+
+// Recipe returns recipe of chatID by its name regardless of case
+func (db *Inmem) Recipe(chatID int64, name string) (Recipe, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	r, ok := db.recipes[chatID][foldName(name)]
+	r.Items = append([]Item(nil), r.Items...)
+	return r, ok, nil
+}
+
+// Recipes returns recipes of chatID ordered by name
+func (db *Inmem) Recipes(chatID int64) ([]Recipe, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var recipes []Recipe
+	for _, r := range db.recipes[chatID] {
+		r.Items = append([]Item(nil), r.Items...)
+		recipes = append(recipes, r)
+	}
+	sort.Slice(recipes, func(i, j int) bool { return foldName(recipes[i].Name) < foldName(recipes[j].Name) })
+	return recipes, nil
+}
+
+// DeleteRecipe forgets the recipe of chatID and reports if there was one
+func (db *Inmem) DeleteRecipe(chatID int64, name string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	key := foldName(name)
+	if _, ok := db.recipes[chatID][key]; !ok {
+		return false, nil
+	}
+	db.version++
+	delete(db.recipes[chatID], key)
+	return true, nil
+}
+
+// SaveUserChat adds the chat to chats of userID, or updates its title
+func (db *Inmem) SaveUserChat(userID int64, chat UserChat) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	if db.userChats == nil {
+		db.userChats = make(map[int64][]UserChat)
+	}
+	for i, existing := range db.userChats[userID] {
+		if existing.ID == chat.ID {
+			db.userChats[userID][i] = chat
+			return nil
+		}
+	}
+	db.userChats[userID] = append(db.userChats[userID], chat)
+	return nil
+}
+
+// UserChats returns chats of userID in order they were saved first
+func (db *Inmem) UserChats(userID int64) ([]UserChat, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]UserChat(nil), db.userChats[userID]...), nil
+}
+
+// Settings returns settings of chatID
+func (db *Inmem) Settings(chatID int64) (ChatSettings, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return copySettings(db.settings[chatID]), nil
+}
+
+// SetSettings saves settings of chatID
+func (db *Inmem) SetSettings(chatID int64, s ChatSettings) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.version++
+	if db.settings == nil {
+		db.settings = make(map[int64]ChatSettings)
+	}
+	db.settings[chatID] = copySettings(s)
+	return nil
+}
+
+func copySettings(s ChatSettings) ChatSettings {
+	if s.Categories != nil {
+		categories := make(map[string]string, len(s.Categories))
+		for k, v := range s.Categories {
+			categories[k] = v
+		}
+		s.Categories = categories
+	}
+	s.Aisles = append([]string(nil), s.Aisles...)
+	return s
+}
+
+// Dump saves on disk current items in bin format. Items are encoded under lock,
+// and written into temp file which replaces the dump only when it's completely written
+func (db *Inmem) Dump() (err error) {
+	defer func(start time.Time) { db.metrics.observeSnapshot(time.Since(start), err) }(time.Now())
+	db.dumpMu.Lock()
+	defer db.dumpMu.Unlock()
+
+	db.mu.Lock()
+	version := db.version
+	data, err := encodeDump(&dumpData{Lists: db.items, Settings: db.settings, History: db.history, Undo: db.undo, Polls: db.polls, Staples: db.staples, Reminders: db.reminders, Recipes: db.recipes, UserChats: db.userChats})
+	db.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(db.dumpPath(), data, db.backups); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	db.saved = version
+	db.mu.Unlock()
+	return nil
+}
+
+// Restore reads dump from disk and populates items. If the dump is broken, the newest readable backup is used
+func (db *Inmem) Restore() error {
+	d, err := readDumpOrBackup(db.dumpPath(), db.backups)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.items = d.Lists
+	db.settings = d.Settings
+	db.history = d.History
+	db.undo = d.Undo
+	db.polls = d.Polls
+	db.staples = d.Staples
+	db.reminders = d.Reminders
+	db.recipes = d.Recipes
+	db.userChats = d.UserChats
+	db.saved = db.version
+	return nil
+}
+
+// dirty reports if there are changes which haven't been dumped yet
+func (db *Inmem) dirty() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.version != db.saved
+}
+
+// Snapshot dumps items every interval if they have been changed, until ctx is done
+func (db *Inmem) Snapshot(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if !db.dirty() {
+				continue
+			}
+			if err := db.Dump(); err != nil {
+				defaultLogger().Error("can't snapshot items", "err", err)
+			}
+		}
+	}
+}
+
+func (db *Inmem) dumpPath() string {
+	if db.path == "" {
+		return dumpFile
+	}
+	return db.path
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInmem_GetAll(t *testing.T) {
+	type fields struct {
+		items map[int64]map[string][]Item
+		mu    *sync.Mutex
+	}
+	type args struct {
+		chatID int64
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want   [][]Item
+	}{
+		{
+			"Missing key",
+			fields{
+				items: map[int64]map[string][]Item{0: {defaultList: plainItems("foo", "bar")}},
+				mu:    &sync.Mutex{},
+			},
+			args{chatID: 1},
+			[][]Item(nil),
+		},
+		{
+			"2 items",
+			fields{
+				items: map[int64]map[string][]Item{0: {defaultList: plainItems("foo", "bar")}},
+				mu:    &sync.Mutex{},
+			},
+			args{chatID: 0},
+			[][]Item{plainItems("foo", "bar")},
+		},
+		{
+			"11 items",
+			fields{
+				items: map[int64]map[string][]Item{0: {defaultList: plainItems("1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11")}},
+				mu:    &sync.Mutex{},
+			},
+			args{chatID: 0},
+			[][]Item{plainItems("1", "2", "3", "4", "5", "6", "7", "8", "9"), plainItems("11", "10")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &Inmem{
+				items: tt.fields.items,
+				mu:    tt.fields.mu,
+			}
+			got, err := db.GetAll(tt.args.chatID, defaultList)
+			if err != nil {
+				t.Fatalf("GetAll() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetAll() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInmem_Dump(t *testing.T) {
+	_ = os.Mkdir("dumps", os.ModePerm)
+
+	type fields struct {
+		items map[int64]map[string][]Item
+		mu    *sync.Mutex
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		wantErr bool
+	}{
+		{
+			"valid dump",
+			fields{
+				items: map[int64]map[string][]Item{0: {defaultList: plainItems("1", "2", "3")}, 1: {defaultList: plainItems("4", "5"), "hardware": plainItems("6")}},
+				mu:    &sync.Mutex{},
+			},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &Inmem{
+				items: tt.fields.items,
+				mu:    tt.fields.mu,
+			}
+			if err := db.Dump(); (err != nil) != tt.wantErr {
+				t.Errorf("Dump() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInmem_Restore(t *testing.T) {
+	_ = os.Mkdir("dumps", 0o600)
+	defer func() {
+		_ = os.Remove("dumps/items.gob")
+		_ = os.Remove("dumps")
+	}()
+
+	type fields struct {
+		items map[int64]map[string][]Item
+		mu    *sync.Mutex
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		wantErr bool
+	}{
+		{
+			"valid dump",
+			fields{
+				items: map[int64]map[string][]Item{0: {defaultList: plainItems("1", "2", "3")}, 1: {defaultList: plainItems("4", "5"), "hardware": plainItems("6")}},
+				mu:    &sync.Mutex{},
+			},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &Inmem{
+				items: tt.fields.items,
+				mu:    tt.fields.mu,
+			}
+			_ = db.Dump()
+			newDB := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+			if err := newDB.Restore(); (err != nil) != tt.wantErr {
+				t.Errorf("Restore() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("New DB items = %v, want = %v", newDB.items, db.items)
+			}
+			if !reflect.DeepEqual(newDB.items, db.items) {
+				t.Errorf("Restore() items = %v, want %v", newDB.items, db.items)
+			}
+		})
+	}
+}
+
+func TestInmem_Lists(t *testing.T) {
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+
+	if err := db.CreateList(1, "hardware"); err != nil {
+		t.Fatalf("CreateList() error = %v", err)
+	}
+	if err := db.CreateList(1, "hardware"); !errors.Is(err, errListExists) {
+		t.Errorf("CreateList() of existing list error = %v, want %v", err, errListExists)
+	}
+	if err := db.CreateList(1, defaultList); !errors.Is(err, errListExists) {
+		t.Errorf("CreateList() of default list error = %v, want %v", err, errListExists)
+	}
+	_ = db.Add(1, "bakery", Item{Name: "bread"})
+	_ = db.Add(1, "hardware", Item{Name: "screws"})
+
+	got, err := db.Lists(1)
+	if err != nil {
+		t.Fatalf("Lists() error = %v", err)
+	}
+	if want := []string{defaultList, "bakery", "hardware"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Lists() = %v, want %v", got, want)
+	}
+	if got, _ := db.Lists(2); !reflect.DeepEqual(got, []string{defaultList}) {
+		t.Errorf("Lists() of new chat = %v, want only default", got)
+	}
+	if got, _ := db.List(1, defaultList); len(got) != 0 {
+		t.Errorf("List() of default list = %v, want empty", got)
+	}
+}
+
+func TestInmem_History(t *testing.T) {
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	start := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < historyLimit+5; i++ {
+		p := Purchase{Item: Item{Name: fmt.Sprint(i)}, List: defaultList, Time: start.Add(time.Duration(i) * time.Minute)}
+		if err := db.AddPurchase(1, p); err != nil {
+			t.Fatalf("AddPurchase() error = %v", err)
+		}
+	}
+	_ = db.AddPurchase(2, Purchase{Item: Item{Name: "other"}, Time: start})
+
+	got, err := db.History(1, time.Time{})
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(got) != historyLimit || got[0].Item.Name != "5" {
+		t.Errorf("History() has %d purchases from %v, want %d from 5", len(got), got[0].Item.Name, historyLimit)
+	}
+
+	got, _ = db.History(1, start.Add(time.Duration(historyLimit+3)*time.Minute))
+	if len(got) != 2 || got[0].Item.Name != fmt.Sprint(historyLimit+3) {
+		t.Errorf("History() since = %v", got)
+	}
+
+	// purchases made at the same time are removed one by one
+	at := start.Add(24 * time.Hour)
+	_ = db.AddPurchase(1, Purchase{Item: Item{Name: "milk"}, List: defaultList, Time: at})
+	_ = db.AddPurchase(1, Purchase{Item: Item{Name: "eggs"}, List: defaultList, Time: at})
+	if err := db.RemovePurchase(1, defaultList, "milk", at); err != nil {
+		t.Fatalf("RemovePurchase() error = %v", err)
+	}
+	if got, _ = db.History(1, at); len(got) != 1 || got[0].Item.Name != "eggs" {
+		t.Errorf("History() after RemovePurchase() = %v", got)
+	}
+}
+
+func TestInmem_Undo(t *testing.T) {
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+
+	for i := 0; i < undoLimit+2; i++ {
+		if err := db.PushUndo(1, UndoEntry{Cmd: doneCmd, List: defaultList, Items: plainItems(fmt.Sprint(i))}); err != nil {
+			t.Fatalf("PushUndo() error = %v", err)
+		}
+	}
+
+	for i := undoLimit + 1; i >= 2; i-- {
+		e, ok, err := db.PopUndo(1)
+		if err != nil || !ok {
+			t.Fatalf("PopUndo() = %v, %v", ok, err)
+		}
+		if want := plainItems(fmt.Sprint(i)); !reflect.DeepEqual(e.Items, want) {
+			t.Errorf("PopUndo() items = %v, want %v", e.Items, want)
+		}
+	}
+	if _, ok, _ := db.PopUndo(1); ok {
+		t.Errorf("PopUndo() of empty journal is ok")
+	}
+}
+
+func TestInmem_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.gob")
+	db := &Inmem{items: make(map[int64]map[string][]Item), path: path, backups: 1, mu: &sync.Mutex{}}
+
+	if db.dirty() {
+		t.Errorf("dirty() of empty db = true")
+	}
+	_ = db.Add(1, defaultList, Item{Name: "milk"})
+	if !db.dirty() {
+		t.Errorf("dirty() after Add() = false")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		db.Snapshot(ctx, time.Millisecond)
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); db.dirty(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("snapshot hasn't been done")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if _, err := os.Stat(backupPath(path, 1)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("backup of the first dump exists, err = %v", err)
+	}
+
+	// the broken dump is replaced by its backup on restore
+	_ = db.Add(1, defaultList, Item{Name: "bread"})
+	if err := db.Dump(); err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("can't corrupt dump: %v", err)
+	}
+	restored := &Inmem{path: path, backups: 1, mu: &sync.Mutex{}}
+	if err := restored.Restore(); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if got, _ := restored.List(1, defaultList); !reflect.DeepEqual(got, plainItems("milk")) {
+		t.Errorf("Restore() items = %v, want milk from backup", got)
+	}
+	if restored.dirty() {
+		t.Errorf("dirty() after Restore() = true")
+	}
+}
+
+func TestInmem_TakeReplaceClear(t *testing.T) {
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	for _, item := range []Item{{Name: "milk", Quantity: 2}, {Name: "mlk"}, {Name: "bread"}} {
+		_ = db.Add(1, defaultList, item)
+	}
+
+	if got, ok, err := db.Take(1, defaultList, "Bread"); !ok || err != nil || got.Name != "bread" {
+		t.Errorf("Take() = %v, %v, %v", got, ok, err)
+	}
+	if _, ok, _ := db.Take(1, defaultList, "bread"); ok {
+		t.Errorf("Take() of taken item ok = true")
+	}
+
+	if ok, err := db.Replace(1, defaultList, "mlk", Item{Name: "milk"}); !ok || err != nil {
+		t.Errorf("Replace() = %v, %v", ok, err)
+	}
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, []Item{{Name: "milk", Quantity: 3}}) {
+		t.Errorf("items after merging Replace() = %v", got)
+	}
+	if ok, _ := db.Replace(1, defaultList, "milk", Item{Name: "oat milk", Quantity: 1, Unit: "l"}); !ok {
+		t.Errorf("Replace() ok = false")
+	}
+	if ok, _ := db.Replace(1, defaultList, "nope", Item{Name: "eggs"}); ok {
+		t.Errorf("Replace() of missing item ok = true")
+	}
+
+	got, err := db.Clear(1, defaultList)
+	if err != nil || !reflect.DeepEqual(got, []Item{{Name: "oat milk", Quantity: 1, Unit: "l"}}) {
+		t.Errorf("Clear() = %v, %v", got, err)
+	}
+	if got, _ := db.List(1, defaultList); len(got) != 0 {
+		t.Errorf("items after Clear() = %v", got)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Item is a single position of the shopping list
+type Item struct {
+	Name string
+	// Quantity is zero if it wasn't specified
+	Quantity float64
+	// Unit is empty for countable items, like "2x milk"
+	Unit string
+	Note string
+}
+
+// String formats item as it shown in the list, e.g. "potatoes 2 kg (small ones)"
+func (i Item) String() string {
+	var b strings.Builder
+	b.WriteString(i.Name)
+	if i.Quantity != 0 {
+		q := strconv.FormatFloat(math.Round(i.Quantity*1000)/1000, 'f', -1, 64)
+		if i.Unit == "" {
+			b.WriteString(" ×" + q)
+		} else {
+			b.WriteString(" " + q + " " + i.Unit)
+		}
+	}
+	if i.Note != "" {
+		b.WriteString(" (" + i.Note + ")")
+	}
+	return b.String()
+}
+
+// units maps known unit spellings to the canonical ones
+var units = map[string]string{
+	"g": "g", "gr": "g", "gram": "g", "grams": "g", "г": "g", "гр": "g",
+	"kg": "kg", "kgs": "kg", "кг": "kg",
+	"mg": "mg", "мг": "mg",
+	"l": "l", "lt": "l", "liter": "l", "liters": "l", "litre": "l", "litres": "l", "л": "l",
+	"ml": "ml", "мл": "ml",
+	"pc": "pcs", "pcs": "pcs", "piece": "pcs", "pieces": "pcs", "шт": "pcs",
+	"pack": "pack", "packs": "pack", "уп": "pack",
+	"bottle": "bottle", "bottles": "bottle",
+	"can": "can", "cans": "can",
+}
+
+// convertible units: unit -> base unit and factor
+var baseUnits = map[string]struct {
+	base   string
+	factor float64
+}{
+	"mg": {"g", 0.001},
+	"g":  {"g", 1},
+	"kg": {"g", 1000},
+	"ml": {"l", 0.001},
+	"l":  {"l", 1},
+}
+
+var (
+	noteRx = regexp.MustCompile(`\(([^)]*)\)`)
+	// "2", "2.5", "2x", "x2", "2kg"
+	qtyRx = regexp.MustCompile(`^(?:[x×*](\d+(?:[.,]\d+)?)|(\d+(?:[.,]\d+)?)([x×*]|\pL+)?)$`)
+)
+
+// parseItems parses comma or newline separated items. Commas between digits, "0,5 l milk",
+// and inside notes, "milk (2%, organic)", don't separate items
+func parseItems(text string) []Item {
+	var items []Item
+	for _, row := range strings.Split(text, "\n") {
+		for _, w := range splitItems(row) {
+			if s := strings.TrimSpace(w); s != "" {
+				items = append(items, parseItem(s))
+			}
+		}
+	}
+	return items
+}
+
+// splitItems splits the row by commas, which are neither decimal ones nor inside notes
+func splitItems(row string) []string {
+	notes := noteRx.FindAllStringIndex(row, -1)
+	inNote := func(i int) bool {
+		for _, n := range notes {
+			if i > n[0] && i < n[1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var parts []string
+	start := 0
+	for i := 0; i < len(row); i++ {
+		if row[i] != ',' || inNote(i) || i > 0 && i+1 < len(row) && isDigit(row[i-1]) && isDigit(row[i+1]) {
+			continue
+		}
+		parts = append(parts, row[start:i])
+		start = i + 1
+	}
+	return append(parts, row[start:])
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// parseItem parses item quantity, unit and note from strings like
+// "2x milk", "milk 2l", "2 kg potatoes", "eggs (free range)".
+// If there is nothing but a quantity, the whole string is used as a name
+func parseItem(s string) Item {
+	var item Item
+
+	if m := noteRx.FindAllStringSubmatch(s, -1); m != nil {
+		var notes []string
+		for _, n := range m {
+			if note := strings.TrimSpace(n[1]); note != "" {
+				notes = append(notes, note)
+			}
+		}
+		item.Note = strings.Join(strings.Fields(strings.Join(notes, "; ")), " ")
+		s = noteRx.ReplaceAllString(s, " ")
+	}
+
+	words := strings.Fields(s)
+	if len(words) > 1 {
+		// leading quantity: "2x milk", "2 kg potatoes", "2 x milk"
+		if q, u, n := parseQty(words[0], words[1]); n > 0 && len(words) > n {
+			item.Quantity, item.Unit, words = q, u, words[n:]
+		} else if q, u, n := parseTrailingQty(words); n > 0 && len(words) > n {
+			// trailing quantity: "milk 2l", "milk 2 l", "milk x2"
+			item.Quantity, item.Unit, words = q, u, words[:len(words)-n]
+		}
+	}
+
+	item.Name = strings.Join(words, " ")
+	if item.Name == "" {
+		item.Name = strings.Join(strings.Fields(s), " ")
+	}
+	return item
+}
+
+// parseQty parses quantity from word, and unit either from word itself, or from the next one.
+// It returns number of consumed words, or 0 if word is not a quantity
+func parseQty(word, next string) (qty float64, unit string, n int) {
+	m := qtyRx.FindStringSubmatch(strings.ToLower(word))
+	if m == nil {
+		return 0, "", 0
+	}
+
+	num, suffix := m[2], m[3]
+	if m[1] != "" {
+		num = m[1]
+	}
+	qty, err := strconv.ParseFloat(strings.ReplaceAll(num, ",", "."), 64)
+	if err != nil || qty <= 0 {
+		return 0, "", 0
+	}
+
+	switch {
+	case isMultiplier(suffix):
+		return qty, "", 1
+	case suffix != "":
+		u, ok := units[suffix]
+		if !ok {
+			// something like "7up"
+			return 0, "", 0
+		}
+		return qty, u, 1
+	}
+
+	next = strings.ToLower(next)
+	if u, ok := units[strings.TrimSuffix(next, ".")]; ok {
+		return qty, u, 2
+	}
+	if isMultiplier(next) {
+		return qty, "", 2
+	}
+	return qty, "", 1
+}
+
+// parseTrailingQty looks for quantity at the end of words
+func parseTrailingQty(words []string) (qty float64, unit string, n int) {
+	last := len(words) - 1
+	if len(words) > 2 {
+		// "milk 2 l", "milk 2 x"
+		if q, u, n := parseQty(words[last-1], words[last]); n == 2 {
+			return q, u, 2
+		}
+	}
+	return parseQty(words[last], "")
+}
+
+func isMultiplier(s string) bool {
+	return s == "x" || s == "×" || s == "*"
+}
+
+// sameItem reports if names belong to the same item, regardless of case and whitespace
+func sameItem(a, b string) bool {
+	return foldName(a) == foldName(b)
+}
+
+// mergeItems adds quantity of item b into item a if they are the same item with compatible units.
+// Unspecified quantity of countable item counts as one
+func mergeItems(a, b Item) (Item, bool) {
+	if !sameItem(a.Name, b.Name) {
+		return a, false
+	}
+
+	switch {
+	case a.Quantity == 0 && b.Quantity == 0:
+	case a.Unit == b.Unit && a.Unit == "":
+		a.Quantity = countable(a.Quantity) + countable(b.Quantity)
+	case b.Quantity == 0:
+	case a.Quantity == 0:
+		a.Quantity, a.Unit = b.Quantity, b.Unit
+	case a.Unit == b.Unit:
+		a.Quantity += b.Quantity
+	default:
+		ua, okA := baseUnits[a.Unit]
+		ub, okB := baseUnits[b.Unit]
+		if !okA || !okB || ua.base != ub.base {
+			return a, false
+		}
+		a.Quantity += b.Quantity * ub.factor / ua.factor
+	}
+
+	a.Note = mergeNotes(a.Note, b.Note)
+	return a, true
+}
+
+func countable(q float64) float64 {
+	if q == 0 {
+		return 1
+	}
+	return q
+}
+
+func mergeNotes(a, b string) string {
+	switch {
+	case b == "" || strings.Contains(a, b):
+		return a
+	case a == "":
+		return b
+	default:
+		return a + "; " + b
+	}
+}
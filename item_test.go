@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseItem(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Item
+	}{
+		{"milk", Item{Name: "milk"}},
+		{"2x milk", Item{Name: "milk", Quantity: 2}},
+		{"2 x milk", Item{Name: "milk", Quantity: 2}},
+		{"x3 eggs", Item{Name: "eggs", Quantity: 3}},
+		{"3 eggs", Item{Name: "eggs", Quantity: 3}},
+		{"milk 2l", Item{Name: "milk", Quantity: 2, Unit: "l"}},
+		{"milk 1,5 l", Item{Name: "milk", Quantity: 1.5, Unit: "l"}},
+		{"milk x2", Item{Name: "milk", Quantity: 2}},
+		{"2 kg potatoes", Item{Name: "potatoes", Quantity: 2, Unit: "kg"}},
+		{"2kg new potatoes", Item{Name: "new potatoes", Quantity: 2, Unit: "kg"}},
+		{"сахар 1 кг", Item{Name: "сахар", Quantity: 1, Unit: "kg"}},
+		{"eggs (free range)", Item{Name: "eggs", Note: "free range"}},
+		{"10 eggs (free range)", Item{Name: "eggs", Quantity: 10, Note: "free range"}},
+		{"7up", Item{Name: "7up"}},
+		{"7up 2 bottles", Item{Name: "7up", Quantity: 2, Unit: "bottle"}},
+		{"42", Item{Name: "42"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := parseItem(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseItem() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseItems(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []Item
+	}{
+		{"milk, bread\neggs", []Item{{Name: "milk"}, {Name: "bread"}, {Name: "eggs"}}},
+		{"0,5 l milk, bread", []Item{{Name: "milk", Quantity: 0.5, Unit: "l"}, {Name: "bread"}}},
+		{"milk (2%, organic), bread", []Item{{Name: "milk", Note: "2%, organic"}, {Name: "bread"}}},
+		{"1, 2,3", []Item{{Name: "1"}, {Name: "2,3"}}},
+		{"milk (2%, bread", []Item{{Name: "milk (2%"}, {Name: "bread"}}},
+		{" , ", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := parseItems(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseItems() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeItems(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   Item
+		want   Item
+		wantOk bool
+	}{
+		{"different items", Item{Name: "milk"}, Item{Name: "eggs"}, Item{Name: "milk"}, false},
+		{"no quantities", Item{Name: "milk"}, Item{Name: "Milk"}, Item{Name: "milk"}, true},
+		{"countable", Item{Name: "milk"}, Item{Name: "milk", Quantity: 2}, Item{Name: "milk", Quantity: 3}, true},
+		{"same unit", Item{Name: "rice", Quantity: 1, Unit: "kg"}, Item{Name: "rice", Quantity: 2, Unit: "kg"},
+			Item{Name: "rice", Quantity: 3, Unit: "kg"}, true},
+		{"convertible units", Item{Name: "rice", Quantity: 1, Unit: "kg"}, Item{Name: "rice", Quantity: 500, Unit: "g"},
+			Item{Name: "rice", Quantity: 1.5, Unit: "kg"}, true},
+		{"quantity from the new one", Item{Name: "rice"}, Item{Name: "rice", Quantity: 1, Unit: "kg"},
+			Item{Name: "rice", Quantity: 1, Unit: "kg"}, true},
+		{"incompatible units", Item{Name: "milk", Quantity: 2}, Item{Name: "milk", Quantity: 1, Unit: "l"},
+			Item{Name: "milk", Quantity: 2}, false},
+		{"notes", Item{Name: "eggs", Note: "free range"}, Item{Name: "eggs", Note: "large"},
+			Item{Name: "eggs", Note: "free range; large"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := mergeItems(tt.a, tt.b)
+			if ok != tt.wantOk || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeItems() = %#v, %v, want %#v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestItem_String(t *testing.T) {
+	tests := []struct {
+		item Item
+		want string
+	}{
+		{Item{Name: "milk"}, "milk"},
+		{Item{Name: "milk", Quantity: 2}, "milk ×2"},
+		{Item{Name: "rice", Quantity: 1.5, Unit: "kg"}, "rice 1.5 kg"},
+		{Item{Name: "eggs", Quantity: 10, Note: "free range"}, "eggs ×10 (free range)"},
+	}
+	for _, tt := range tests {
+		if got := tt.item.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	rateLimitText = "Too many requests, please slow down a bit"
+
+	// maxBuckets is how many rate limit buckets are kept before the full ones are dropped
+	maxBuckets = 10000
+)
+
+// reasons of rejected updates and requests
+const (
+	rejectNotAllowed = "not_allowed"
+	rejectRateLimit  = "rate_limit"
+	rejectQuota      = "quota"
+)
+
+// Limits are storage quotas of every chat, zero means no limit
+type Limits struct {
+	// Items is the number of items in a list
+	Items int
+	// Lists is the number of lists in a chat, including the default one
+	Lists int
+	// ItemLen is the length of an item in runes, with its quantity, unit and note
+	ItemLen int
+}
+
+// quotaError is returned by storage when the chat runs out of its quota, the text is sent to the chat as is
+type quotaError string
+
+func (e quotaError) Error() string { return string(e) }
+
+// checkItem returns quotaError if the item is too long
+func (l Limits) checkItem(item Item) error {
+	if l.ItemLen > 0 && utf8.RuneCountInString(item.String()) > l.ItemLen {
+		return quotaError(fmt.Sprintf("Items could be up to %d letters long", l.ItemLen))
+	}
+	return nil
+}
+
+// checkItems returns quotaError if there is no room for one more item in the list of n items
+func (l Limits) checkItems(n int) error {
+	if l.Items > 0 && n >= l.Items {
+		return quotaError(fmt.Sprintf("The list is full, it could have up to %d items", l.Items))
+	}
+	return nil
+}
+
+// checkLists returns quotaError if there is no room for one more list in the chat with n lists
+func (l Limits) checkLists(n int) error {
+	if l.Lists > 0 && n >= l.Lists {
+		return quotaError(fmt.Sprintf("There are too many lists, a chat could have up to %d", l.Lists))
+	}
+	return nil
+}
+
+// rejections counts rejected updates and requests by reason
+type rejections struct {
+	n  map[string]int64
+	mu sync.Mutex
+}
+
+func newRejections() *rejections {
+	return &rejections{n: make(map[string]int64)}
+}
+
+func (r *rejections) inc(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.n[reason]++
+}
+
+func (r *rejections) get(reason string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n[reason]
+}
+
+// limiter is a token bucket per key: every key has up to burst tokens, which are refilled at rate per second
+type limiter struct {
+	rate, burst float64
+	buckets     map[int64]*bucket
+
+	mu sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+	// warned is set when the key has been told it's limited, it's told once till it has tokens again
+	warned bool
+}
+
+// newLimiter makes limiter of perMinute tokens, or nil which allows everything if perMinute is zero
+func newLimiter(perMinute, burst int) *limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &limiter{rate: float64(perMinute) / 60, burst: float64(burst), buckets: make(map[int64]*bucket)}
+}
+
+// allow takes a token of the key. warn is true for the first rejection since the key had tokens
+func (l *limiter) allow(key int64, now time.Time) (ok, warn bool) {
+	if l == nil {
+		return true, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, exists := l.buckets[key]
+	if !exists {
+		if len(l.buckets) >= maxBuckets {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: l.burst, at: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.at).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.at = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.warned = false
+		return true, false
+	}
+	warn = !b.warned
+	b.warned = true
+	return false, warn
+}
+
+// sweep drops buckets which are full by now, they are the same as new ones. Should be called under lock
+func (l *limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.at).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// throttle rejects updates of chats and users which are over the rate limit. Messages get a reply
+// once till the limit is over, taps always get a notification. Poll answers are never limited,
+// they are votes, and a lost vote would be a wrong list
+func (s *Srv) throttle(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if c.PollAnswer() != nil {
+			return next(c)
+		}
+		now := s.now()
+		ok, warn := true, false
+		if chat := c.Chat(); chat != nil {
+			ok, warn = s.chatLimiter.allow(chat.ID, now)
+		}
+		if u := c.Sender(); ok && u != nil {
+			ok, warn = s.userLimiter.allow(u.ID, now)
+		}
+		if ok {
+			return next(c)
+		}
+
+		s.rejected.inc(rejectRateLimit)
+		switch {
+		case c.Callback() != nil:
+			return c.Respond(&tele.CallbackResponse{Text: rateLimitText})
+		case warn && c.Message() != nil:
+			s.log(c).Info("chat is over the rate limit")
+			return c.Send(rateLimitText)
+		}
+		return nil
+	}
+}
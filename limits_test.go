@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	l := newLimiter(60, 2)
+	start := time.Date(2022, 10, 17, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		key      int64
+		after    time.Duration
+		ok, warn bool
+	}{
+		{"burst", 1, 0, true, false},
+		{"burst again", 1, 0, true, false},
+		{"over the burst", 1, 0, false, true},
+		{"still over", 1, 500 * time.Millisecond, false, false},
+		{"another key", 2, 500 * time.Millisecond, true, false},
+		{"refilled", 1, time.Second, true, false},
+		{"over again", 1, time.Second, false, true},
+		{"no more than burst after a pause", 1, time.Hour, true, false},
+	}
+	for _, tt := range tests {
+		ok, warn := l.allow(tt.key, start.Add(tt.after))
+		if ok != tt.ok || warn != tt.warn {
+			t.Errorf("%s: allow() = %v, %v, want %v, %v", tt.name, ok, warn, tt.ok, tt.warn)
+		}
+	}
+	if ok, _ := l.allow(1, start.Add(time.Hour)); !ok {
+		t.Errorf("the second token after a pause isn't allowed")
+	}
+	if ok, _ := l.allow(1, start.Add(time.Hour)); ok {
+		t.Errorf("the third token after a pause is allowed")
+	}
+
+	var unlimited *limiter
+	if ok, _ := unlimited.allow(1, start); !ok {
+		t.Errorf("nil limiter doesn't allow")
+	}
+}
+
+func TestSrv_RateLimit(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	srv := NewServer(db, bot)
+	now := time.Date(2022, 10, 17, 15, 0, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+	srv.chatLimiter = newLimiter(6, 2)
+	srv.userLimiter = newLimiter(60, 10)
+
+	for _, item := range []string{"milk", "bread", "eggs", "tea"} {
+		bot.cmd(t, 1, "/add", item)
+	}
+	if items, _ := db.List(1, defaultList); len(items) != 2 {
+		t.Errorf("items added over the rate limit: %v", items)
+	}
+	if got := bot.sent[1]; len(got) != 3 || got[2] != rateLimitText {
+		t.Errorf("replies over the rate limit = %v, want the only warning", got)
+	}
+
+	// other chats have their own limits, but the user is the same
+	bot.cmd(t, 2, "/add", "tea")
+	if items, _ := db.List(2, defaultList); len(items) != 1 {
+		t.Errorf("items of another chat = %v", items)
+	}
+
+	now = now.Add(10 * time.Second)
+	bot.cmd(t, 1, "/add", "eggs")
+	if items, _ := db.List(1, defaultList); len(items) != 3 {
+		t.Errorf("items after the pause = %v", items)
+	}
+	if got := srv.rejected.get(rejectRateLimit); got != 2 {
+		t.Errorf("rejected updates = %d, want 2", got)
+	}
+
+	// votes are never limited
+	now = now.Add(time.Minute)
+	bot.cmd(t, 1, "/list", "")
+	pollMsg := bot.lastID - 1
+	for i := 0; i < 3; i++ {
+		bot.answer(t, pollMsg, testUser, i)
+	}
+	if got := srv.rejected.get(rejectRateLimit); got != 2 {
+		t.Errorf("rejected updates after votes = %d, want 2", got)
+	}
+}
+
+func TestSrv_Quotas(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}, limits: Limits{Items: 3, Lists: 2, ItemLen: 10}}
+	srv := NewServer(db, bot)
+	lastReply := func() interface{} { return bot.sent[1][len(bot.sent[1])-1] }
+
+	bot.cmd(t, 1, "/add", "milk, bread, a very long name of cheese, eggs, tea, milk")
+	want := "Added 3 item(s): milk, bread, eggs\nItems could be up to 10 letters long\nThe list is full, it could have up to 3 items"
+	if got := lastReply(); got != want {
+		t.Errorf("/add over quota = %q, want %q", got, want)
+	}
+	// items already on the list are merged into them, so they fit
+	bot.cmd(t, 1, "/add", "2 milk")
+	if got := lastReply(); got != "Already on the list: milk" {
+		t.Errorf("/add of existing item into full list = %q", got)
+	}
+
+	bot.cmd(t, 1, "/edit", "bread -> bread of the day")
+	if got := lastReply(); got != "Items could be up to 10 letters long" {
+		t.Errorf("/edit to long item = %q", got)
+	}
+
+	bot.cmd(t, 1, "/newlist", "hardware")
+	bot.cmd(t, 1, "/newlist", "garden")
+	if got := lastReply(); !strings.HasPrefix(got.(string), "There are too many lists") {
+		t.Errorf("/newlist over quota = %q", got)
+	}
+	if got := srv.rejected.get(rejectQuota); got != 4 {
+		t.Errorf("rejected requests = %d, want 4", got)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// defaultList exists in every chat, items of old dumps go there as well
+const defaultList = "default"
+
+var (
+	errListExists = errors.New("list already exists")
+
+	listNameRx = regexp.MustCompile(`^\w{1,32}$`)
+	// "/add@hardware screws" – command addressed to a list, or to a bot
+	listMentionRx = regexp.MustCompile(`^(/\w+)@(\w+)`)
+)
+
+// splitListMention turns "/add@hardware screws" into "/add @hardware screws",
+// otherwise telebot takes "hardware" for a bot name and drops the update.
+// Commands addressed to the bot itself, or to any other bot, are left as is
+func splitListMention(text, botName string) string {
+	m := listMentionRx.FindStringSubmatch(text)
+	if m == nil || strings.EqualFold(m[2], botName) || isBotName(m[2]) {
+		return text
+	}
+	return m[1] + " @" + strings.ToLower(m[2]) + text[len(m[0]):]
+}
+
+// isBotName tells if the name is taken for a bot mention, usernames of tg bots end with "bot"
+func isBotName(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), "bot")
+}
+
+// cmdPayload returns text of the message without the command itself
+func cmdPayload(text string) string {
+	if !strings.HasPrefix(text, "/") {
+		return text
+	}
+	i := strings.IndexAny(text, " \n\t")
+	if i < 0 {
+		return ""
+	}
+	return text[i+1:]
+}
+
+// targetList returns the list the command is addressed to and the rest of the command payload.
+// The list is either mentioned explicitly as "@name", or it's the active list of the chat
+func (s *Srv) targetList(c tele.Context) (list, payload string, err error) {
+	payload = strings.TrimSpace(cmdPayload(c.Message().Text))
+
+	if strings.HasPrefix(payload, "@") {
+		name := payload[1:]
+		if i := strings.IndexAny(name, " \n\t"); i >= 0 {
+			name, payload = name[:i], name[i+1:]
+		} else {
+			payload = ""
+		}
+		list = strings.ToLower(name)
+		if err := s.checkList(c.Chat().ID, list); err != nil {
+			return "", "", err
+		}
+		return list, payload, nil
+	}
+
+	list, err = s.activeList(c.Chat().ID)
+	return list, payload, err
+}
+
+// activeList returns the list chosen by /use in the chat
+func (s *Srv) activeList(chatID int64) (string, error) {
+	settings, err := s.db.Settings(chatID)
+	if err != nil {
+		return "", fmt.Errorf("can't get settings: %w", err)
+	}
+	if settings.ActiveList == "" {
+		return defaultList, nil
+	}
+	return settings.ActiveList, nil
+}
+
+// checkList returns userError if there is no such list in the chat
+func (s *Srv) checkList(chatID int64, list string) error {
+	lists, err := s.db.Lists(chatID)
+	if err != nil {
+		return fmt.Errorf("can't get lists: %w", err)
+	}
+	for _, l := range lists {
+		if l == list {
+			return nil
+		}
+	}
+	return userError(fmt.Sprintf("There is no list %q, use %s %s to create it", list, newListCmd, list))
+}
+
+// listTitle is shown on top of the list
+func listTitle(list string) string {
+	if list == defaultList {
+		return "Shopping list"
+	}
+	return "Shopping list @" + list
+}
+
+// newList creates a new named list in the chat
+func (s *Srv) newList(c tele.Context) error {
+	name := strings.ToLower(strings.TrimSpace(cmdPayload(c.Message().Text)))
+	if !listNameRx.MatchString(name) {
+		return c.Send(fmt.Sprintf("Usage: %s <name>, name could contain only letters, digits and _", newListCmd))
+	}
+	if isBotName(name) {
+		return c.Send(fmt.Sprintf("List name can't end with \"bot\", %s@%s would be taken for a command to another bot", addCmd, name))
+	}
+
+	err := s.db.CreateList(c.Chat().ID, name)
+	if errors.Is(err, errListExists) {
+		return c.Send(fmt.Sprintf("List %q already exists", name))
+	}
+	if err != nil {
+		return s.reply(c, fmt.Errorf("can't create list: %w", err))
+	}
+
+	return c.Send(fmt.Sprintf("List %q created. Use %s %s to make it active, or %s@%s to add items there",
+		name, useCmd, name, addCmd, name))
+}
+
+// showLists shows all lists of the chat with the number of items in every list
+func (s *Srv) showLists(c tele.Context) error {
+	chatID := c.Chat().ID
+	lists, err := s.db.Lists(chatID)
+	if err != nil {
+		return fmt.Errorf("can't get lists: %w", err)
+	}
+	active, err := s.activeList(chatID)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("Lists:")
+	for _, l := range lists {
+		items, err := s.db.List(chatID, l)
+		if err != nil {
+			return fmt.Errorf("can't get items: %w", err)
+		}
+		mark := "  "
+		if l == active {
+			mark = "• "
+		}
+		b.WriteString(fmt.Sprintf("\n%s%s (%d)", mark, l, len(items)))
+	}
+
+	return c.Send(b.String())
+}
+
+// useList makes the list active in the chat
+func (s *Srv) useList(c tele.Context) error {
+	name := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(cmdPayload(c.Message().Text)), "@"))
+	if name == "" {
+		return c.Send(fmt.Sprintf("Usage: %s <name>", useCmd))
+	}
+	if err := s.checkList(c.Chat().ID, name); err != nil {
+		return s.reply(c, err)
+	}
+
+	settings, err := s.db.Settings(c.Chat().ID)
+	if err != nil {
+		return fmt.Errorf("can't get settings: %w", err)
+	}
+	settings.ActiveList = name
+	if err := s.db.SetSettings(c.Chat().ID, settings); err != nil {
+		return fmt.Errorf("can't save settings: %w", err)
+	}
+
+	return c.Send(fmt.Sprintf("Using list %q", name))
+}
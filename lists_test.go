@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestSplitListMention(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"/add@hardware screws", "/add @hardware screws"},
+		{"/add@Hardware screws, nails", "/add @hardware screws, nails"},
+		{"/list@hardware", "/list @hardware"},
+		{"/add@ShoppingCatBot milk", "/add@ShoppingCatBot milk"},
+		{"/add@shoppingcatbot milk", "/add@shoppingcatbot milk"},
+		{"/add@OtherBot milk", "/add@OtherBot milk"},
+		{"/start@other_bot", "/start@other_bot"},
+		{"/add milk", "/add milk"},
+		{"milk@hardware", "milk@hardware"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := splitListMention(tt.text, "ShoppingCatBot"); got != tt.want {
+				t.Errorf("splitListMention() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCmdPayload(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"/add milk, eggs", "milk, eggs"},
+		{"/add\nmilk\neggs", "milk\neggs"},
+		{"/list", ""},
+		{"milk", "milk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := cmdPayload(tt.text); got != tt.want {
+				t.Errorf("cmdPayload() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSrv_NamedLists(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	NewServer(db, bot)
+
+	bot.cmd(t, 1, "/add", "@hardware screws")
+	if got := bot.sent[1][len(bot.sent[1])-1]; got != `There is no list "hardware", use /newlist hardware to create it` {
+		t.Errorf("reply for missing list = %v", got)
+	}
+
+	bot.cmd(t, 1, "/newlist", "Robot")
+	if got := bot.sent[1][len(bot.sent[1])-1]; got != `List name can't end with "bot", /add@robot would be taken for a command to another bot` {
+		t.Errorf("reply for bot-like list name = %v", got)
+	}
+	bot.cmd(t, 1, "/newlist", "Hardware")
+	bot.cmd(t, 1, "/add", "milk")
+	bot.cmd(t, 1, "/add", "@hardware screws, nails")
+
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, plainItems("milk")) {
+		t.Errorf("default list = %v", got)
+	}
+	if got, _ := db.List(1, "hardware"); !reflect.DeepEqual(got, plainItems("screws", "nails")) {
+		t.Errorf("hardware list = %v", got)
+	}
+
+	bot.cmd(t, 1, "/use", "hardware")
+	bot.cmd(t, 1, "/add", "glue")
+	bot.cmd(t, 1, "/lists", "")
+	if got, want := bot.sent[1][len(bot.sent[1])-1], "Lists:\n  default (1)\n• hardware (3)"; got != want {
+		t.Errorf("/lists = %q, want %q", got, want)
+	}
+
+	bot.cmd(t, 1, "/list", "")
+	p, ok := bot.sent[1][len(bot.sent[1])-2].(*tele.Poll)
+	if !ok {
+		t.Fatalf("poll hasn't been sent")
+	}
+	if p.Question != "Shopping list @hardware, page: 1/1" {
+		t.Errorf("poll question = %q", p.Question)
+	}
+	if got := bot.sent[1][len(bot.sent[1])-1]; got != "/done@hardware" {
+		t.Errorf("done command = %v", got)
+	}
+
+	// /done of the other list doesn't touch hardware polls
+	bot.vote(1, "screws")
+	bot.cmd(t, 1, "/done", "@default")
+	if got, _ := db.List(1, "hardware"); len(got) != 3 {
+		t.Errorf("hardware list after /done @default = %v", got)
+	}
+	bot.cmd(t, 1, "/done", "@hardware")
+	if got, _ := db.List(1, "hardware"); !reflect.DeepEqual(got, plainItems("nails", "glue")) {
+		t.Errorf("hardware list after /done = %v", got)
+	}
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, plainItems("milk")) {
+		t.Errorf("default list after /done = %v", got)
+	}
+
+	// lists belong to the chat
+	if got, _ := db.Lists(2); !reflect.DeepEqual(got, []string{defaultList}) {
+		t.Errorf("lists of the other chat = %v", got)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// setLive turns live mode of polls on and off in the chat
+func (s *Srv) setLive(c tele.Context) error {
+	var live bool
+	switch strings.TrimSpace(cmdPayload(c.Message().Text)) {
+	case "on":
+		live = true
+	case "off":
+	default:
+		return c.Send(fmt.Sprintf("Usage: %s on|off", liveCmd))
+	}
+
+	settings, err := s.db.Settings(c.Chat().ID)
+	if err != nil {
+		return fmt.Errorf("can't get settings: %w", err)
+	}
+	settings.Live = live
+	if err := s.db.SetSettings(c.Chat().ID, settings); err != nil {
+		return fmt.Errorf("can't save settings: %w", err)
+	}
+
+	if live {
+		return c.Send("Items will be bought as soon as they are ticked in the list polls")
+	}
+	return c.Send(fmt.Sprintf("Items will be bought on %s", doneCmd))
+}
+
+// answerPoll keeps voters of poll options, so /done buys items on behalf of the ones who ticked them.
+// In live mode items are bought as soon as they are ticked. The item is brought back when everyone
+// who ticked it has retracted the vote
+func (s *Srv) answerPoll(c tele.Context) error {
+	a := c.PollAnswer()
+	if a == nil || a.Sender == nil {
+		return nil
+	}
+
+	s.pollsMu.Lock()
+	defer s.pollsMu.Unlock()
+
+	p, ok, err := s.db.OpenPoll(a.PollID)
+	if err != nil {
+		return fmt.Errorf("can't get poll: %w", err)
+	}
+	if !ok {
+		// the poll is not ours or it's already stopped
+		return nil
+	}
+	settings, err := s.db.Settings(p.ChatID)
+	if err != nil {
+		return fmt.Errorf("can't get settings: %w", err)
+	}
+
+	ticked := make(map[int]bool, len(a.Options))
+	for _, i := range a.Options {
+		ticked[i] = true
+	}
+
+	at := s.now()
+	for i := range p.Items {
+		pi := &p.Items[i]
+		j := indexOf(pi.Voters, a.Sender.ID)
+
+		switch {
+		case ticked[i] && j < 0:
+			pi.Voters = append(pi.Voters, a.Sender.ID)
+			if pi.Names == nil {
+				pi.Names = make(map[int64]string)
+			}
+			pi.Names[a.Sender.ID] = userName(a.Sender)
+			if !settings.Live || !pi.BoughtAt.IsZero() {
+				continue
+			}
+			pi.BoughtAt = at
+			if err := s.buy(p.ChatID, p.List, pi.Item, a.Sender, pi.BoughtAt); err != nil {
+				return err
+			}
+		case !ticked[i] && j >= 0:
+			pi.Voters = append(pi.Voters[:j], pi.Voters[j+1:]...)
+			delete(pi.Names, a.Sender.ID)
+			if len(pi.Voters) > 0 || pi.BoughtAt.IsZero() {
+				continue
+			}
+			if err := s.db.PutBack(p.ChatID, p.List, []Item{pi.Item}); err != nil {
+				return fmt.Errorf("can't restore item: %w", err)
+			}
+			// items ticked at once have the same time, the purchase is told by its item
+			if err := s.db.RemovePurchase(p.ChatID, p.List, pi.Item.Name, pi.BoughtAt); err != nil {
+				return fmt.Errorf("can't remove purchase: %w", err)
+			}
+			pi.BoughtAt = time.Time{}
+		}
+	}
+
+	if err := s.db.SaveOpenPoll(p); err != nil {
+		return fmt.Errorf("can't save poll: %w", err)
+	}
+	return nil
+}
+
+func indexOf(ids []int64, id int64) int {
+	for i := range ids {
+		if ids[i] == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestSrv_LivePolls(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	srv := NewServer(db, bot)
+	now := time.Date(2022, 10, 17, 18, 30, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+
+	alice := &tele.User{ID: 1, Username: "alice"}
+	bob := &tele.User{ID: 2, Username: "bob"}
+	buyers := func() []string {
+		var names []string
+		history, _ := db.History(1, time.Time{})
+		for _, p := range history {
+			names = append(names, p.Item.Name+" "+p.UserName)
+		}
+		return names
+	}
+
+	// answers to polls sent without live mode are ignored
+	bot.cmd(t, 2, "/add", "milk, bread")
+	bot.cmd(t, 2, "/list", "")
+	bot.answer(t, bot.opened[2][0], alice, 0)
+	if got, _ := db.List(2, defaultList); len(got) != 2 {
+		t.Errorf("items after answer without live mode = %v", got)
+	}
+
+	bot.cmd(t, 1, "/add", "milk, bread, eggs")
+	bot.cmd(t, 1, "/live", "on")
+	bot.cmd(t, 1, "/list", "")
+	// options are ordered by aisles: bread, milk, eggs
+	msgID := bot.opened[1][0]
+
+	bot.answer(t, msgID, alice, 1, 2)
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, plainItems("bread")) {
+		t.Errorf("items after alice's answer = %v, want [bread]", got)
+	}
+	if got, want := buyers(), []string{"milk @alice", "eggs @alice"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("history = %v, want %v", got, want)
+	}
+	// items ticked at once are bought at the same time
+	history, _ := db.History(1, time.Time{})
+	for _, p := range history {
+		if !p.Time.Equal(now) {
+			t.Errorf("%s is bought at %v, want %v", p.Item.Name, p.Time, now)
+		}
+	}
+
+	// the item stays bought while anyone has it ticked
+	bot.answer(t, msgID, bob, 1)
+	bot.answer(t, msgID, alice)
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, plainItems("bread", "eggs")) {
+		t.Errorf("items after alice's retract = %v, want [bread eggs]", got)
+	}
+	if got, want := buyers(), []string{"milk @alice"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("history after alice's retract = %v, want %v", got, want)
+	}
+	bot.answer(t, msgID, bob)
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, plainItems("bread", "eggs", "milk")) {
+		t.Errorf("items after bob's retract = %v, want [bread eggs milk]", got)
+	}
+	if got := buyers(); len(got) != 0 {
+		t.Errorf("history after all retracts = %v, want empty", got)
+	}
+
+	// /done doesn't buy items twice
+	bot.answer(t, msgID, bob, 0)
+	bot.vote(1, "bread")
+	bot.cmd(t, 1, "/done", "")
+	if got, want := buyers(), []string{"bread @bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("history after /done = %v, want %v", got, want)
+	}
+	if _, ok, _ := db.OpenPoll(bot.polls[msgID].ID); ok {
+		t.Errorf("poll is still open after /done")
+	}
+
+	// the stopped poll is forgotten
+	bot.answer(t, msgID, alice, 1)
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, plainItems("eggs", "milk")) {
+		t.Errorf("items after answer to stopped poll = %v, want [eggs milk]", got)
+	}
+}
+
+func TestSrv_LivePollsFullList(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}, limits: Limits{Items: 2}}
+	NewServer(db, bot)
+	alice := &tele.User{ID: 1, Username: "alice"}
+
+	bot.cmd(t, 1, "/add", "milk, eggs")
+	bot.cmd(t, 1, "/live", "on")
+	bot.cmd(t, 1, "/list", "")
+	bot.answer(t, bot.opened[1][0], alice, 0)
+	bot.cmd(t, 1, "/add", "bread")
+
+	// the un-ticked item was in the list, so it's brought back over the quota
+	bot.answer(t, bot.opened[1][0], alice)
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, plainItems("eggs", "bread", "milk")) {
+		t.Errorf("items after retract into full list = %v, want [eggs bread milk]", got)
+	}
+	if got, _ := db.History(1, time.Time{}); len(got) != 0 {
+		t.Errorf("history after retract = %v, want empty", got)
+	}
+}
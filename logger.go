@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	failureText = "Oops, something went wrong, please try again a bit later"
+
+	// loggerKey is the key of the logger of the update in tele.Context
+	loggerKey = "logger"
+	// badKey is the key of the value without a key, as log/slog does
+	badKey = "!BADKEY"
+)
+
+// logLevel is the severity of log records, the values are the same as in log/slog
+type logLevel int
+
+const (
+	levelDebug logLevel = -4
+	levelInfo  logLevel = 0
+	levelWarn  logLevel = 4
+	levelError logLevel = 8
+)
+
+func (l logLevel) String() string {
+	switch l {
+	case levelDebug:
+		return "DEBUG"
+	case levelInfo:
+		return "INFO"
+	case levelWarn:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+func parseLevel(s string) (logLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return levelDebug, nil
+	case "info":
+		return levelInfo, nil
+	case "warn":
+		return levelWarn, nil
+	case "error":
+		return levelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Logger writes structured records as key=value text or json lines. It follows log/slog,
+// which isn't available in go 1.18: records are a message with key-value pairs, With adds pairs to every record
+type Logger struct {
+	level logLevel
+	json  bool
+	// attrs are key-value pairs of every record
+	attrs []interface{}
+	out   *lockedWriter
+	now   func() time.Time
+}
+
+// lockedWriter is shared by the logger and all loggers made by its With, so records aren't mixed
+type lockedWriter struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// NewLogger makes logger of records of the level and above, format is text or json
+func NewLogger(w io.Writer, level, format string) (*Logger, error) {
+	lvl, err := parseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if format != "text" && format != "json" {
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return &Logger{level: lvl, json: format == "json", out: &lockedWriter{w: w}, now: time.Now}, nil
+}
+
+var (
+	defaultMu sync.Mutex
+	defLogger = &Logger{level: levelInfo, out: &lockedWriter{w: os.Stderr}, now: time.Now}
+)
+
+// defaultLogger returns the logger of code which has no logger of its own, like storage
+func defaultLogger() *Logger {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	return defLogger
+}
+
+func setDefaultLogger(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defLogger = l
+}
+
+// With returns logger which adds key-value pairs to every record
+func (l *Logger) With(args ...interface{}) *Logger {
+	w := *l
+	w.attrs = append(append([]interface{}(nil), l.attrs...), args...)
+	return &w
+}
+
+func (l *Logger) Debug(msg string, args ...interface{}) { l.log(levelDebug, msg, args) }
+func (l *Logger) Info(msg string, args ...interface{})  { l.log(levelInfo, msg, args) }
+func (l *Logger) Warn(msg string, args ...interface{})  { l.log(levelWarn, msg, args) }
+func (l *Logger) Error(msg string, args ...interface{}) { l.log(levelError, msg, args) }
+
+func (l *Logger) log(level logLevel, msg string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+	var buf bytes.Buffer
+	l.add(&buf, "time", l.now().Format("2006-01-02T15:04:05.000Z07:00"))
+	l.add(&buf, "level", level.String())
+	l.add(&buf, "msg", msg)
+	for _, attrs := range [][]interface{}{l.attrs, args} {
+		for i := 0; i < len(attrs); i++ {
+			key, ok := attrs[i].(string)
+			if !ok || i == len(attrs)-1 {
+				l.add(&buf, badKey, attrs[i])
+				continue
+			}
+			l.add(&buf, key, attrs[i+1])
+			i++
+		}
+	}
+	if l.json {
+		buf.WriteByte('}')
+	}
+	buf.WriteByte('\n')
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	_, _ = l.out.w.Write(buf.Bytes())
+}
+
+// add writes the key-value pair of the record
+func (l *Logger) add(buf *bytes.Buffer, key string, v interface{}) {
+	switch x := v.(type) {
+	case error:
+		v = x.Error()
+	case time.Duration:
+		v = x.String()
+	case time.Time:
+		v = x.Format(time.RFC3339)
+	case fmt.Stringer:
+		v = x.String()
+	}
+
+	if l.json {
+		if buf.Len() == 0 {
+			buf.WriteByte('{')
+		} else {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		data, err := json.Marshal(v)
+		if err != nil {
+			data, _ = json.Marshal(fmt.Sprint(v))
+		}
+		buf.Write(data)
+		return
+	}
+
+	if buf.Len() > 0 {
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	s, ok := v.(string)
+	if !ok {
+		s = fmt.Sprint(v)
+	}
+	if needsQuote(s) {
+		s = strconv.Quote(s)
+	}
+	buf.WriteString(s)
+}
+
+// needsQuote reports if the text value should be quoted to be parsed back
+func needsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// withLogger gives every update its own logger with the update id, the chat, the user and the command
+func (s *Srv) withLogger(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		args := []interface{}{"update", c.Update().ID, "command", s.updateCommand(c)}
+		if chat := c.Chat(); chat != nil {
+			args = append(args, "chat", chat.ID)
+		}
+		if u := c.Sender(); u != nil {
+			args = append(args, "user", u.ID)
+		}
+		c.Set(loggerKey, s.logger.With(args...))
+		return next(c)
+	}
+}
+
+// log returns the logger of the update, or the logger of the server if there is no update
+func (s *Srv) log(c tele.Context) *Logger {
+	if c != nil {
+		if l, ok := c.Get(loggerKey).(*Logger); ok {
+			return l
+		}
+	}
+	return s.logger
+}
+
+// onError logs errors of handlers with the context of the update and apologizes to the chat
+func (s *Srv) onError(err error, c tele.Context) {
+	l := s.log(c)
+	l.Error("can't handle update", "err", err)
+	if c == nil || c.Chat() == nil {
+		return
+	}
+	if c.Callback() != nil {
+		err = c.Respond(&tele.CallbackResponse{Text: failureText})
+	} else {
+		err = c.Send(failureText)
+	}
+	if err != nil {
+		l.Warn("can't tell the chat about the failure", "err", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestLogger(t *testing.T) {
+	tests := []struct {
+		name   string
+		level  string
+		format string
+		log    func(l *Logger)
+		want   string
+	}{
+		{
+			"text", "info", "text",
+			func(l *Logger) { l.Info("poll is stopped", "chat", int64(-100), "list", "default") },
+			`time=2022-10-17T15:00:00.000Z level=INFO msg="poll is stopped" chat=-100 list=default` + "\n",
+		},
+		{
+			"quoted values", "info", "text",
+			func(l *Logger) { l.Warn("can't send", "err", errors.New(`chat "1" not found`), "empty", "", "took", time.Second) },
+			`time=2022-10-17T15:00:00.000Z level=WARN msg="can't send" err="chat \"1\" not found" empty="" took=1s` + "\n",
+		},
+		{
+			"json", "info", "json",
+			func(l *Logger) { l.With("update", 7).Error("can't handle update", "err", errors.New("boom"), "ok", false) },
+			`{"time":"2022-10-17T15:00:00.000Z","level":"ERROR","msg":"can't handle update","update":7,"err":"boom","ok":false}` + "\n",
+		},
+		{
+			"below the level", "warn", "text",
+			func(l *Logger) { l.Info("nobody sees it") },
+			"",
+		},
+		{
+			"debug", "debug", "text",
+			func(l *Logger) { l.Debug("details") },
+			"time=2022-10-17T15:00:00.000Z level=DEBUG msg=details\n",
+		},
+		{
+			"value without key", "info", "text",
+			func(l *Logger) { l.Info("odd", "chat", 1, 2) },
+			"time=2022-10-17T15:00:00.000Z level=INFO msg=odd chat=1 !BADKEY=2\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l, err := NewLogger(&buf, tt.level, tt.format)
+			if err != nil {
+				t.Fatalf("NewLogger() error = %v", err)
+			}
+			l.now = func() time.Time { return time.Date(2022, 10, 17, 15, 0, 0, 0, time.UTC) }
+			tt.log(l)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("log = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewLogger(&bytes.Buffer{}, "loud", "text"); err == nil {
+		t.Errorf("NewLogger() of unknown level error = nil")
+	}
+	if _, err := NewLogger(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Errorf("NewLogger() of unknown format error = nil")
+	}
+}
+
+func TestSrv_OnError(t *testing.T) {
+	bot := newFakeBot()
+	srv := NewServer(&Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}, bot)
+	var buf bytes.Buffer
+	srv.logger, _ = NewLogger(&buf, "info", "text")
+
+	h := srv.withLogger(func(c tele.Context) error { return errors.New("can't get items: disk is full") })
+	run := func(c *fakeContext) {
+		if err := h(c); err != nil {
+			srv.onError(err, c)
+		}
+	}
+
+	run(&fakeContext{bot: bot, msg: &tele.Message{Text: "/list@hardware", Chat: &tele.Chat{ID: 1}, Sender: testUser}})
+	if got := bot.sent[1]; len(got) != 1 || got[0] != failureText {
+		t.Errorf("messages = %v, want %q", got, failureText)
+	}
+	want := `msg="can't handle update" update=0 command=/list chat=1 user=42 err="can't get items: disk is full"`
+	if got := buf.String(); !strings.Contains(got, want) {
+		t.Errorf("log = %q, want %q in it", got, want)
+	}
+
+	// taps get a notification instead of a message
+	run(&fakeContext{bot: bot, msg: &tele.Message{ID: 5, Chat: &tele.Chat{ID: 1}, Sender: testUser}, tapped: true})
+	if got := len(bot.sent[1]); got != 1 {
+		t.Errorf("failed tap has sent %d messages", got-1)
+	}
+
+	// errors out of updates are only logged
+	buf.Reset()
+	srv.onError(errors.New("poller failed"), nil)
+	if got := buf.String(); !strings.Contains(got, `err="poller failed"`) || strings.Contains(got, "chat=") {
+		t.Errorf("log without update = %q", got)
+	}
+}
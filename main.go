@@ -1,198 +1,558 @@
 package main
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io/fs"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+	// time zones of chats are known even without zoneinfo in the system
+	_ "time/tzdata"
 
 	tele "gopkg.in/telebot.v3"
 )
 
 const (
 	bunchSize = 10
+
+	addCmd       = "/add"
+	listCmd      = "/list"
+	doneCmd      = "/done"
+	viewCmd      = "/view"
+	newListCmd   = "/newlist"
+	listsCmd     = "/lists"
+	useCmd       = "/use"
+	historyCmd   = "/history"
+	undoCmd      = "/undo"
+	liveCmd      = "/live"
+	removeCmd    = "/remove"
+	editCmd      = "/edit"
+	clearCmd     = "/clear"
+	categoryCmd  = "/category"
+	aislesCmd    = "/aisles"
+	stapleCmd    = "/staple"
+	staplesCmd   = "/staples"
+	remindCmd    = "/remind"
+	remindersCmd = "/reminders"
+	timeZoneCmd  = "/timezone"
+	recipeCmd    = "/recipe"
+	recipesCmd   = "/recipes"
+
+	viewPoll      = "poll"
+	viewChecklist = "checklist"
+
+	dumpFile   = "dumps/items.gob"
+	sqliteFile = "dumps/items.db"
 )
 
-// ItemStorager represents storage for items
+// exit codes of the bot, fatal errors exit with 1
+const (
+	// exitNotDrained means in-flight updates or scheduled jobs were cut off by the shutdown timeout
+	exitNotDrained = 2
+	// exitNotFlushed means storage wasn't flushed on shutdown
+	exitNotFlushed = 3
+)
+
+// ItemStorager represents storage for items. Every chat has the default list and could have other named lists
 type ItemStorager interface {
-	// Add adds item into particular chatID bucket
-	Add(chatID int64, item string)
-	// Remove deletes item from particular chatID bucket if it exist
-	Remove(chatID int64, item string)
+	// Add adds item into particular list of chatID, or merges it into the same item if it's already there
+	Add(chatID int64, list string, item Item) error
+	// PutBack adds items removed before into particular list of chatID like Add, but regardless of quotas.
+	// It's used to undo removals, so the items fit into the list they were in
+	PutBack(chatID int64, list string, items []Item) error
+	// Remove deletes item with name from particular list of chatID if it exist
+	Remove(chatID int64, list, name string) error
+	// Take deletes item with name from particular list of chatID and returns it, ok is false if there is no such item
+	Take(chatID int64, list, name string) (item Item, ok bool, err error)
+	// Replace puts another item in place of item with name, or merges it into the same item if it's already
+	// in the list. ok is false if there is no item with name
+	Replace(chatID int64, list, name string, item Item) (ok bool, err error)
+	// Clear deletes all items from particular list of chatID and returns them
+	Clear(chatID int64, list string) ([]Item, error)
 	// GetAll return collection of bunches (size <= 10, because tg Poll could contain only <= 10 option)
 	// with items. As long, as I use tg Polls to show lists it should be so. ¯\_(ツ)_/¯
-	GetAll(chatID int64) [][]string
-
+	GetAll(chatID int64, list string) ([][]Item, error)
+	// List returns all items from particular list of chatID in insertion order
+	List(chatID int64, list string) ([]Item, error)
+
+	// CreateList creates new empty list in chatID, or returns errListExists
+	CreateList(chatID int64, list string) error
+	// Lists returns names of all lists of chatID, including the default one
+	Lists(chatID int64) ([]string, error)
+	// ItemCounts returns the number of items in all lists of every chat which has any
+	ItemCounts() (map[int64]int, error)
+
+	// AddPurchase records item bought in chatID, only the last historyLimit purchases are kept
+	AddPurchase(chatID int64, p Purchase) error
+	// History returns purchases made in chatID since the time, the oldest first
+	History(chatID int64, since time.Time) ([]Purchase, error)
+
+	// RemovePurchases removes purchases made in chatID at exactly that time, it's used to undo /done
+	RemovePurchases(chatID int64, at time.Time) error
+	// RemovePurchase removes the purchase of the item of the list made in chatID at exactly that time,
+	// it's used to bring back the item bought in live mode
+	RemovePurchase(chatID int64, list, name string, at time.Time) error
+
+	// PushUndo adds entry into the undo journal of chatID, only the last undoLimit entries are kept
+	PushUndo(chatID int64, e UndoEntry) error
+	// PopUndo takes the last entry from the undo journal of chatID, ok is false if the journal is empty
+	PopUndo(chatID int64) (e UndoEntry, ok bool, err error)
+
+	// SaveOpenPoll saves poll sent by /list, or replaces the saved one with the same id
+	SaveOpenPoll(p OpenPoll) error
+	// OpenPoll returns poll by its tg id, ok is false if there is no such poll
+	OpenPoll(pollID string) (p OpenPoll, ok bool, err error)
+	// OpenPolls returns open polls of particular list of chatID in order they were sent
+	OpenPolls(chatID int64, list string) ([]OpenPoll, error)
+	// AllOpenPolls returns open polls of all chats
+	AllOpenPolls() ([]OpenPoll, error)
+	// DeleteOpenPoll forgets the poll, it's done when the poll is stopped
+	DeleteOpenPoll(pollID string) error
+	// SaveStaple adds new staple, if its ID is zero, or replaces the one with the same ID. It returns ID of the staple
+	SaveStaple(st Staple) (int64, error)
+	// Staples returns staples of chatID ordered by ID
+	Staples(chatID int64) ([]Staple, error)
+	// DueStaples returns staples of all chats which should be added to their lists at or before the time
+	DueStaples(at time.Time) ([]Staple, error)
+	// DeleteStaple forgets the staple of chatID and reports if there was one
+	DeleteStaple(chatID, id int64) (bool, error)
+	// SaveReminder adds new reminder, if its ID is zero, or replaces the one with the same ID. It returns ID of the reminder
+	SaveReminder(r Reminder) (int64, error)
+	// Reminders returns reminders of chatID ordered by ID
+	Reminders(chatID int64) ([]Reminder, error)
+	// DueReminders returns reminders of all chats which should go off at or before the time
+	DueReminders(at time.Time) ([]Reminder, error)
+	// DeleteReminder forgets the reminder of chatID and reports if there was one
+	DeleteReminder(chatID, id int64) (bool, error)
+	// SaveRecipe saves recipe of chatID or replaces the one with the same name regardless of case
+	SaveRecipe(chatID int64, r Recipe) error
+	// Recipe returns recipe of chatID by its name regardless of case
+	Recipe(chatID int64, name string) (Recipe, bool, error)
+	// Recipes returns recipes of chatID ordered by name
+	Recipes(chatID int64) ([]Recipe, error)
+	// DeleteRecipe forgets the recipe of chatID and reports if there was one
+	DeleteRecipe(chatID int64, name string) (bool, error)
+	// SaveUserChat adds the chat to chats of userID, or updates its title
+	SaveUserChat(userID int64, chat UserChat) error
+	// UserChats returns chats of userID in order they were saved first
+	UserChats(userID int64) ([]UserChat, error)
+
+	// Settings returns settings of the chat, or zero settings if the chat has no own
+	Settings(chatID int64) (ChatSettings, error)
+	// SetSettings saves settings of the chat
+	SetSettings(chatID int64, s ChatSettings) error
+
+	// Dump exports all items into the gob dump
 	Dump() error
+	// Restore imports all items from the gob dump
 	Restore() error
 }
 
-// Srv is runnable instance if shopping list
-type Srv struct {
-	db        ItemStorager
-	bot       *tele.Bot
-	currLists []*tele.Message
+// ChatSettings keeps per chat preferences
+type ChatSettings struct {
+	// View is the way the list is shown: viewPoll or viewChecklist, the default view of the bot if empty
+	View string
+	// ActiveList is the list used by commands without explicit list, defaultList if empty
+	ActiveList string
+	// Live makes items bought as soon as they are ticked in polls, instead of on /done
+	Live bool
+	// Categories are store sections of items set by /category, by item key
+	Categories map[string]string
+	// Aisles is the order of sections set by /aisles, sections not in it follow in the default order
+	Aisles []string
+	// TimeZone is IANA name of the time zone of reminders, UTC if empty
+	TimeZone string
 }
 
-// NewServer takes ItemStorager and tele.Bot and initializes all handlers
-func NewServer(db ItemStorager, b *tele.Bot) *Srv {
-	const (
-		addCmd  = "/add"
-		listCmd = "/list"
-		doneCmd = "/done"
+// userError is an error which should be sent back to the chat as is
+type userError string
+
+func (e userError) Error() string { return string(e) }
+
+// reply sends userError and quotaError to the chat, any other error is returned back to telebot
+func (s *Srv) reply(c tele.Context, err error) error {
+	var (
+		ue userError
+		qe quotaError
 	)
+	switch {
+	case errors.As(err, &ue):
+		return c.Send(ue.Error())
+	case errors.As(err, &qe):
+		s.rejected.inc(rejectQuota)
+		return c.Send(qe.Error())
+	}
+	return err
+}
 
-	showList := func(srv *Srv, c tele.Context) error {
-		items := db.GetAll(c.Message().Chat.ID)
+// TgBot is a part of tele.Bot which is used by Srv
+type TgBot interface {
+	Handle(endpoint interface{}, h tele.HandlerFunc, m ...tele.MiddlewareFunc)
+	Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error)
+	StopPoll(msg tele.Editable, opts ...interface{}) (*tele.Poll, error)
+	EditReplyMarkup(msg tele.Editable, markup *tele.ReplyMarkup) (*tele.Message, error)
+	Use(middleware ...tele.MiddlewareFunc)
+	ChatMemberOf(chat, user tele.Recipient) (*tele.ChatMember, error)
+	Start()
+	Stop()
+}
 
-		if len(items) == 0 {
-			return c.Send("List is empty")
-		}
+// Srv is runnable instance if shopping list
+type Srv struct {
+	db         ItemStorager
+	bot        TgBot
+	checklists *checklists
+	// pollsMu serializes changes of open polls by poll answers and /done
+	pollsMu sync.Mutex
+
+	// defaultView is used in chats which haven't chosen the view
+	defaultView string
+	// foldPlurals makes singular and plural forms of item names the same item
+	foldPlurals bool
+	// categories finds store sections of items
+	categories *categorizer
+	// allowedChats and allowedUsers are the only ones the bot works for, everyone is allowed if both are empty
+	allowedChats, allowedUsers map[int64]bool
+	// adminCommands lets only admins of groups /clear lists and change settings
+	adminCommands bool
+	// chatLimiter and userLimiter limit the rate of updates of every chat and user, nil ones don't limit
+	chatLimiter, userLimiter *limiter
+	// rejected counts updates and requests rejected by access control, rate limits and quotas
+	rejected *rejections
+	// metrics are exposed by the monitoring listener
+	metrics *metrics
+	// logger is the logger out of updates, every update has its own one with its context
+	logger *Logger
+	// now is replaced in tests
+	now func() time.Time
+	// commands are commands handled by the server
+	commands map[string]bool
+
+	// offers are results of recent inline queries
+	offers *inlineOffers
+	// members caches membership of users in chats for inline queries
+	members *memberships
+	// seenMu guards seen, which has titles of chats already saved as chats of their users
+	seenMu sync.Mutex
+	seen   map[userChatKey]string
+
+	// async runs handlers in their own goroutines, it's set for real bots, see track
+	async bool
+	// handlers are in-flight handlers and jobs are scheduled jobs, Shutdown waits for both
+	handlers, jobs sync.WaitGroup
+	// snapshotInterval is how often Run dumps inmem storage, never if it's zero
+	snapshotInterval time.Duration
+	// shutdownTimeout is the deadline of the shutdown once the context of Run is done
+	shutdownTimeout time.Duration
+	// lifeMu guards started, closed and stopJobs, stopJobs stops jobs started by Run
+	lifeMu          sync.Mutex
+	started, closed bool
+	stopJobs        context.CancelFunc
+	// done is closed when the server is shut down with shutdownErr
+	done         chan struct{}
+	shutdownOnce sync.Once
+	shutdownErr  error
+}
+
+// NewServer takes ItemStorager and TgBot and initializes all handlers
+func NewServer(db ItemStorager, b TgBot) *Srv {
+	srv := &Srv{
+		db:         db,
+		bot:        b,
+		checklists: newChecklists(),
+		categories: newCategorizer(nil),
+		now:        time.Now,
+		offers:     newInlineOffers(),
+		members:    newMemberships(),
+		seen:       make(map[userChatKey]string),
+		rejected:   newRejections(),
+		metrics:    newMetrics(),
+		logger:     defaultLogger(),
+
+		shutdownTimeout: defaultShutdownTimeout,
+		done:            make(chan struct{}),
+		commands:        make(map[string]bool),
+	}
+	_, srv.async = b.(*tele.Bot)
 
-		// we should do it because Tg Polls can't have less than two options
-		if len(items) == 1 && len(items[0]) == 1 {
-			return c.Send(fmt.Sprintf("The only one item in the list: %s", items[0][0]))
+	b.Use(srv.track, srv.withLogger, srv.authorize, srv.instrument, srv.throttle, srv.rememberChat)
+	// commands are remembered to tell them from unknown ones in metrics and logs
+	handle := func(endpoint interface{}, h tele.HandlerFunc, m ...tele.MiddlewareFunc) {
+		if cmd, ok := endpoint.(string); ok && strings.HasPrefix(cmd, "/") {
+			srv.commands[cmd] = true
 		}
+		b.Handle(endpoint, h, m...)
+	}
 
-		var polls []*tele.Poll
+	handle(listCmd, srv.showList)
+	handle(doneCmd, srv.setDone)
+	handle(addCmd, srv.addItems)
+	handle(viewCmd, srv.setView, srv.adminOnly)
+	handle(newListCmd, srv.newList)
+	handle(listsCmd, srv.showLists)
+	handle(useCmd, srv.useList, srv.adminOnly)
+	handle(historyCmd, srv.showHistory)
+	handle(undoCmd, srv.undo)
+	handle(liveCmd, srv.setLive, srv.adminOnly)
+	handle(removeCmd, srv.removeItem)
+	handle(editCmd, srv.editItem)
+	handle(clearCmd, srv.clearList, srv.adminOnly)
+	handle(categoryCmd, srv.setCategory, srv.adminOnly)
+	handle(aislesCmd, srv.setAisles, srv.adminOnly)
+	handle(stapleCmd, srv.addStaple)
+	handle(staplesCmd, srv.showStaples)
+	handle(remindCmd, srv.addReminder)
+	handle(remindersCmd, srv.showReminders)
+	handle(timeZoneCmd, srv.setTimeZone, srv.adminOnly)
+	handle(recipeCmd, srv.recipe)
+	handle(recipesCmd, srv.showRecipes)
+	handle(tele.OnPollAnswer, srv.answerPoll)
+	handle(tele.OnQuery, srv.answerQuery)
+	handle(tele.OnInlineResult, srv.pickResult)
+
+	handle(&checkBtn, srv.toggleItem)
+	handle(&pageBtn, srv.turnPage)
+	handle(&clearBtn, srv.clearChecked)
+	handle(&clearListBtn, srv.confirmClear, srv.adminOnly)
+	handle(&keepListBtn, srv.cancelClear)
+	handle(&unstapleBtn, srv.removeStaple)
+	handle(&unremindBtn, srv.removeReminder)
+	handle(&addRecipeBtn, srv.addRecipe)
 
-		for page, group := range items {
-			p := &tele.Poll{
-				Type:            tele.PollRegular,
-				MultipleAnswers: true,
-				Question:        fmt.Sprintf("Shopping list, page: %d/%d", page+1, len(items)),
-			}
-			for _, item := range group {
-				p.AddOptions(item)
-			}
-			polls = append(polls, p)
-		}
+	return srv
+}
 
-		// sand all polls one by one back to tg
-		for _, p := range polls {
+// showList sends the list to the chat in the view chosen for this chat
+func (s *Srv) showList(c tele.Context) error {
+	list, _, err := s.targetList(c)
+	if err != nil {
+		return s.reply(c, err)
+	}
+	return s.sendList(c.Chat(), list)
+}
 
-			msg, err := c.Bot().Send(c.Recipient(), p)
-			srv.currLists = append(srv.currLists, msg)
+// sendList sends particular list to the chat in the view chosen for this chat
+func (s *Srv) sendList(chat *tele.Chat, list string) error {
+	settings, err := s.db.Settings(chat.ID)
+	if err != nil {
+		return fmt.Errorf("can't get settings: %w", err)
+	}
+	view := settings.View
+	if view == "" {
+		view = s.defaultView
+	}
+	if view == viewChecklist {
+		return s.showChecklist(chat, list)
+	}
+	return s.showPolls(chat, list)
+}
 
-			if err != nil {
-				return fmt.Errorf("can't send poll: %w", err)
-			}
-		}
-		return c.Send(doneCmd)
+// send sends message into the chat out of any update, like the reply
+func (s *Srv) send(chat *tele.Chat, what interface{}, opts ...interface{}) error {
+	_, err := s.bot.Send(chat, what, opts...)
+	return err
+}
+
+// showPolls sends the list as a bunch of tg Polls
+func (s *Srv) showPolls(chat *tele.Chat, list string) error {
+	sorted, settings, err := s.listItems(chat.ID, list)
+	if err != nil {
+		return err
 	}
+	items := makeBunches(sorted)
 
-	setDone := func(srv *Srv, c tele.Context) error {
-		for _, l := range srv.currLists {
-			p, err := c.Bot().StopPoll(l)
-			for _, o := range p.Options {
-				if o.VoterCount != 0 {
-					srv.db.Remove(c.Chat().ID, o.Text)
-				}
-			}
-			if err != nil {
-				return fmt.Errorf("can't stop poll: %w", err)
-			}
+	if len(items) == 0 {
+		return s.send(chat, "List is empty")
+	}
+
+	// we should do it because Tg Polls can't have less than two options
+	if len(items) == 1 && len(items[0]) == 1 {
+		return s.send(chat, fmt.Sprintf("The only one item in the list: %s", items[0][0]))
+	}
+
+	var (
+		polls     []*tele.Poll
+		pollItems [][]Item
+	)
+
+	for page, group := range items {
+		p := &tele.Poll{
+			Type:            tele.PollRegular,
+			MultipleAnswers: true,
+			Question:        fmt.Sprintf("%s, page: %d/%d", listTitle(list), page+1, len(items)),
 		}
-		srv.currLists = nil
-
-		return showList(srv, c)
-	}
-
-	addItems := func(srv *Srv, c tele.Context) error {
-		text := strings.TrimPrefix(c.Message().Text, addCmd)
-		rows := strings.Split(text, "\n")
-		itemCount := 0
-		for _, r := range rows {
-			ws := strings.Split(r, ",")
-			for _, w := range ws {
-				item := strings.TrimSpace(w)
-				if item != "" {
-					db.Add(c.Message().Chat.ID, item)
-					itemCount++
-				}
-			}
+		if sections := s.sectionsLine(settings, group); sections != "" {
+			p.Question += "\n" + sections
+		}
+		for _, item := range group {
+			p.AddOptions(item.String())
 		}
-		return c.Send(fmt.Sprintf("Added %d item(s)", itemCount))
+		polls = append(polls, p)
+		pollItems = append(pollItems, group)
 	}
 
-	srv := &Srv{
-		db:  db,
-		bot: b,
+	// sand all polls one by one back to tg
+	for i, p := range polls {
+		msg, err := s.bot.Send(chat, p)
+		if err != nil {
+			return fmt.Errorf("can't send poll: %w", err)
+		}
+		if err := s.openPoll(chat.ID, list, msg, pollItems[i]); err != nil {
+			return err
+		}
 	}
+	if list != defaultList {
+		return s.send(chat, doneCmd+"@"+list)
+	}
+	return s.send(chat, doneCmd)
+}
 
-	b.Handle(listCmd, func(c tele.Context) error {
-		return showList(srv, c)
-	})
-
-	b.Handle(doneCmd, func(c tele.Context) error {
-		return setDone(srv, c)
-	})
+// setDone removes voted (or checked) items and shows the rest of the list.
+// Removed items go to purchase history on behalf of the ones who ticked them first, or of the one
+// who called /done if the votes haven't been seen. Items already bought in live mode are skipped
+func (s *Srv) setDone(c tele.Context) error {
+	list, _, err := s.targetList(c)
+	if err != nil {
+		return s.reply(c, err)
+	}
 
-	b.Handle(addCmd, func(c tele.Context) error {
-		return addItems(srv, c)
-	})
+	at := s.now()
+	removed, err := s.stopPolls(c.Chat().ID, list, c.Sender(), at)
+	if err != nil {
+		return err
+	}
 
-	return srv
-}
+	checked, err := s.removeChecked(c.Chat().ID, list, at)
+	if err != nil {
+		return err
+	}
+	if err := s.journal(c.Chat().ID, doneCmd, list, append(removed, checked...), at); err != nil {
+		return err
+	}
 
-// Run stars a Srv
-func (s *Srv) Run() error {
-	s.bot.Start()
-	return nil
+	return s.sendList(c.Chat(), list)
 }
 
-// Inmem is in-memory implementation of ItemStorager
-type Inmem struct {
-	items map[int64][]string
+// addItems adds comma or newline separated items into the list.
+// Every item could have quantity, unit and note, like "2 kg potatoes (small ones)".
+// Items which are already in the list, in any case or number, are merged into the existing ones
+func (s *Srv) addItems(c tele.Context) error {
+	chatID := c.Chat().ID
+	list, text, err := s.targetList(c)
+	if err != nil {
+		return s.reply(c, err)
+	}
+	items := parseItems(text)
+	if len(items) == 0 {
+		return c.Send(fmt.Sprintf("Usage: %s <items>", addCmd))
+	}
 
-	mu *sync.Mutex
+	reply, err := s.addToList(chatID, list, items)
+	if err != nil {
+		return err
+	}
+	return c.Send(reply)
 }
 
-var _ ItemStorager = (*Inmem)(nil)
+// addToList adds items into the list and returns the reply telling which items are new and which are already there
+func (s *Srv) addToList(chatID int64, list string, items []Item) (string, error) {
+	existing, err := s.db.List(chatID, list)
+	if err != nil {
+		return "", fmt.Errorf("can't get items: %w", err)
+	}
+	// new items take names of the existing ones, so the storage merges them
+	names := make(map[string]string, len(existing))
+	for _, item := range existing {
+		names[s.itemKey(item.Name)] = item.Name
+	}
 
-// Add adds item into the map in chatID key
-func (db *Inmem) Add(chatID int64, item string) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	db.items[chatID] = append(db.items[chatID], item)
-}
+	var (
+		added, present, rejected []string
+		seen                     = make(map[string]bool)
+	)
+	for _, item := range items {
+		key := s.itemKey(item.Name)
+		name, exists := names[key]
+		if exists {
+			item.Name = name
+		}
 
-// Remove removes item from chat key
-func (db *Inmem) Remove(chatID int64, item string) {
-	// todo: ya ya, it's full scan now, but who cares until you have 999k shopping list?
-	if l, ok := db.items[chatID]; ok {
-		for i := 0; i < len(l); i++ {
-			if l[i] == item {
-				db.items[chatID] = append(l[:i], l[i+1:]...)
-				return
+		// items over quota are skipped, the chat is told why
+		var qe quotaError
+		if err := s.db.Add(chatID, list, item); errors.As(err, &qe) {
+			s.rejected.inc(rejectQuota)
+			if !containsString(rejected, qe.Error()) {
+				rejected = append(rejected, qe.Error())
 			}
+			continue
+		} else if err != nil {
+			return "", fmt.Errorf("can't add item: %w", err)
 		}
+
+		if !exists {
+			names[key] = item.Name
+			added = append(added, item.Name)
+		} else if !seen[key] {
+			present = append(present, name)
+		}
+		seen[key] = true
+	}
+
+	var lines []string
+	if len(added) > 0 {
+		lines = append(lines, fmt.Sprintf("Added %d item(s): %s", len(added), strings.Join(added, ", ")))
+	}
+	if len(present) > 0 {
+		lines = append(lines, "Already on the list: "+strings.Join(present, ", "))
 	}
+	lines = append(lines, rejected...)
+	return strings.Join(lines, "\n"), nil
 }
 
-// GetAll return bunches of items from key chatID
-func (db *Inmem) GetAll(chatID int64) [][]string {
-	if items, ok := db.items[chatID]; !ok || len(items) == 0 {
+// setView switches the way the list is shown in the chat
+func (s *Srv) setView(c tele.Context) error {
+	view := strings.TrimSpace(cmdPayload(c.Message().Text))
+	if view != viewPoll && view != viewChecklist {
+		return c.Send(fmt.Sprintf("Usage: %s %s|%s", viewCmd, viewPoll, viewChecklist))
+	}
+
+	settings, err := s.db.Settings(c.Chat().ID)
+	if err != nil {
+		return fmt.Errorf("can't get settings: %w", err)
+	}
+	settings.View = view
+	if err := s.db.SetSettings(c.Chat().ID, settings); err != nil {
+		return fmt.Errorf("can't save settings: %w", err)
+	}
+
+	return c.Send(fmt.Sprintf("The list will be shown as %s", view))
+}
+
+// makeBunches splits items into bunches which fit into a single tg Poll
+func makeBunches(list []Item) [][]Item {
+	if len(list) == 0 {
 		return nil
 	}
 
 	var (
-		items [][]string
-		loc   []string
+		items [][]Item
+		loc   []Item
 	)
 
-	for i := 0; i < len(db.items[chatID]); i++ {
+	for i := 0; i < len(list); i++ {
 		if len(loc) == bunchSize {
 			items = append(items, loc)
-			loc = []string{}
+			loc = []Item{}
 		}
-		loc = append(loc, db.items[chatID][i])
+		loc = append(loc, list[i])
 	}
 	items = append(items, loc)
 
@@ -211,90 +571,191 @@ func (db *Inmem) GetAll(chatID int64) [][]string {
 	return items
 }
 
-// Dump saves on disk current items in bin format
-func (db *Inmem) Dump() error {
-	buf := new(bytes.Buffer)
-	encoder := gob.NewEncoder(buf)
+// makeBot creates the bot, which gets updates by long polling or by webhook.
+// Webhook is registered in tg right away and removed when the bot stops
+func makeBot(pref tele.Settings, cfg Config) (*tele.Bot, error) {
+	var hook *webhookPoller
+	if cfg.Poller == "webhook" {
+		var err error
+		if hook, err = newWebhookPoller(cfg.Webhook); err != nil {
+			return nil, err
+		}
+		pref.Poller = hook
+	}
+	// handlers are run concurrently by Srv, which tracks them to drain them on shutdown
+	pref.Synchronous = true
 
-	err := encoder.Encode(db.items)
-	if err != nil {
-		return fmt.Errorf("can't encode items: %w", err)
+	b, err := tele.NewBot(pref)
+	if err == nil && hook != nil {
+		err = hook.register(b)
 	}
-	err = os.WriteFile("dumps/items.gob", buf.Bytes(), 0o600)
 	if err != nil {
-		return fmt.Errorf("can't save dump: %w", err)
+		if hook != nil {
+			_ = hook.listener.Close()
+		}
+		return nil, fmt.Errorf("can't create a bot: %w", err)
+	}
+	if hook == nil {
+		client := pref.Client
+		if client == nil {
+			client = http.DefaultClient
+		}
+		b.Poller = newLongPoller(client, b.URL, b.Token, cfg.PollTimeout)
 	}
 
-	return nil
+	b.Poller = tele.NewMiddlewarePoller(b.Poller, func(u *tele.Update) bool {
+		if u.Message != nil {
+			u.Message.Text = splitListMention(u.Message.Text, b.Me.Username)
+		}
+		return true
+	})
+	return b, nil
 }
 
-// Restore reads dump from disk and populates items
-func (db *Inmem) Restore() error {
-	f, err := os.ReadFile("dumps/items.gob")
-	if err != nil {
-		return fmt.Errorf("can't read dump: %w", err)
+// makeInmemStore makes inmem storage restored from the dump. restored is false if the dump is there, but it can't be read
+func makeInmemStore(dumpPath string, backups int, limits Limits) (db *Inmem, restored bool) {
+	db = &Inmem{
+		items:    make(map[int64]map[string][]Item),
+		settings: make(map[int64]ChatSettings),
+		path:     dumpPath,
+		backups:  backups,
+		limits:   limits,
+		mu:       &sync.Mutex{},
 	}
+	// trying restore from dump
+	if err := db.Restore(); err != nil {
+		return db, restoreFailed(err)
+	}
+	return db, true
+}
 
-	buf := bytes.NewBuffer(f)
-	decoder := gob.NewDecoder(buf)
-
-	err = decoder.Decode(&db.items)
+// makeSQLiteStore opens sqlite storage, the fresh one imports the dump. restored is false if the dump can't be imported
+func makeSQLiteStore(dbPath, dumpPath string, limits Limits) (db *SQLite, restored bool, err error) {
+	db, err = NewSQLite(dbPath, dumpPath)
 	if err != nil {
-		return fmt.Errorf("can't decode items: %w", err)
+		return nil, false, err
 	}
-
-	return nil
+	db.limits = limits
+	// first start on the fresh db, trying to import old inmem dump. Restore replaces everything,
+	// so it never runs over the db in use, even if all its lists are empty
+	if db.fresh {
+		if err := db.Restore(); err != nil {
+			return db, restoreFailed(err), nil
+		}
+	}
+	return db, true, nil
 }
 
-func makeBot(timeOut time.Duration) (*tele.Bot, error) {
-	pref := tele.Settings{
-		Token:  os.Getenv("SCBOT_TG_TOKEN"),
-		Poller: &tele.LongPoller{Timeout: timeOut},
+// restoreFailed logs the error of Restore and reports if the storage is fine anyway: there is no dump on the first start
+func restoreFailed(err error) bool {
+	if errors.Is(err, fs.ErrNotExist) {
+		defaultLogger().Info("there is no dump to restore, starting from scratch", "err", err)
+		return true
 	}
+	defaultLogger().Error("can't restore dump", "err", err)
+	return false
+}
 
-	b, err := tele.NewBot(pref)
-	if err != nil {
-		return nil, fmt.Errorf("can't create a bot: %w", err)
-	}
-	return b, nil
+// fatal logs the error and exits, like log.Fatal
+func fatal(l *Logger, msg string, err error) {
+	l.Error(msg, "err", err)
+	os.Exit(1)
 }
 
-func makeInmemStore() *Inmem {
-	db := &Inmem{
-		items: make(map[int64][]string),
-		mu:    &sync.Mutex{},
+func makeStore(cfg Config) (db ItemStorager, restored bool, err error) {
+	switch cfg.Storage {
+	case "inmem":
+		db, restored = makeInmemStore(cfg.DumpPath, cfg.DumpBackups, cfg.limits())
+		return db, restored, nil
+	case "sqlite":
+		return makeSQLiteStore(cfg.SQLitePath, cfg.DumpPath, cfg.limits())
+	default:
+		return nil, false, fmt.Errorf("unknown storage backend: %q", cfg.Storage)
 	}
-	// trying restore from dump
-	if err := db.Restore(); err != nil {
-		fmt.Printf("can't restore: %s\n", err.Error())
-	}
-	return db
 }
 
 func main() {
+	cfg, printConfig, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fatal(defaultLogger(), "can't load config", err)
+	}
+	if printConfig {
+		if err := cfg.print(os.Stdout); err != nil {
+			fatal(defaultLogger(), "can't print config", err)
+		}
+		os.Exit(0)
+	}
+	if err := cfg.validate(); err != nil {
+		fatal(defaultLogger(), "can't start", err)
+	}
+	lg, err := NewLogger(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		fatal(defaultLogger(), "can't make logger", err)
+	}
+	setDefaultLogger(lg)
+
 	fmt.Println("Shopping Cat ~=~=~=~=~=~=~=~=~=~=~=~=~=[,,_,,]:3")
 
-	b, err := makeBot(10 * time.Second)
+	m := newMetrics()
+	if cfg.Poller == "long" {
+		m.pollEvery = cfg.PollTimeout
+	}
+	b, err := makeBot(tele.Settings{Token: cfg.Token, Verbose: cfg.LogLevel == "debug", Client: m.client(cfg.PollTimeout)}, cfg)
+	if err != nil {
+		fatal(lg, "can't start", err)
+	}
+	db, restored, err := makeStore(cfg)
 	if err != nil {
-		log.Fatal(err)
+		fatal(lg, "can't open storage", err)
 	}
-	db := makeInmemStore()
+	m.setRestored(restored)
 	srv := NewServer(db, b)
+	srv.metrics = m
+	b.OnError = srv.onError
+	srv.defaultView = cfg.DefaultView
+	srv.foldPlurals = cfg.FoldPlurals
+	srv.categories = newCategorizer(cfg.Categories)
+	srv.allowedChats = idSet(cfg.AllowedChats)
+	srv.allowedUsers = idSet(cfg.AllowedUsers)
+	srv.adminCommands = cfg.AdminCommands
+	srv.chatLimiter = newLimiter(cfg.RateLimit, cfg.RateBurst)
+	srv.userLimiter = newLimiter(cfg.RateLimit, cfg.RateBurst)
+	srv.snapshotInterval = cfg.SnapshotInterval
+	srv.shutdownTimeout = cfg.ShutdownTimeout
+	if err := srv.reconcilePolls(); err != nil {
+		lg.Error("can't reconcile open polls", "err", err)
+	}
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-stop
-		fmt.Printf("Dumping...")
-		if err := srv.db.Dump(); err != nil {
-			fmt.Printf("FAIL\n %s", err.Error())
-			os.Exit(1)
+	if inmem, ok := db.(*Inmem); ok {
+		inmem.metrics = m
+	}
+	if cfg.MetricsListen != "" {
+		l, err := net.Listen("tcp", cfg.MetricsListen)
+		if err != nil {
+			fatal(lg, "can't listen for metrics", err)
 		}
-		fmt.Printf("done\n")
-		os.Exit(0)
-	}()
+		go func() {
+			if err := srv.ServeMonitoring(l); err != nil {
+				lg.Error("metrics listener is stopped", "err", err)
+			}
+		}()
+	}
 
-	if err := srv.Run(); err != nil {
-		log.Fatal(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = srv.Run(ctx)
+	stop()
+	switch {
+	case errors.Is(err, errNotFlushed):
+		lg.Error("shutdown failed, recent changes could be lost", "err", err)
+		os.Exit(exitNotFlushed)
+	case errors.Is(err, errNotDrained):
+		lg.Error("shutdown didn't wait for everything", "err", err)
+		os.Exit(exitNotDrained)
+	case err != nil:
+		fatal(lg, "bot failed", err)
 	}
+	lg.Info("bot is stopped")
 }
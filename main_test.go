@@ -1,136 +1,562 @@
 package main
 
 import (
-	"os"
+	"fmt"
 	"reflect"
 	"sync"
 	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
 )
 
-func TestInmem_GetAll(t *testing.T) {
-	type fields struct {
-		items map[int64][]string
-		mu    *sync.Mutex
-	}
-	type args struct {
-		chatID int64
-	}
-	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   [][]string
-	}{
-		{
-			"Missing key",
-			fields{
-				items: map[int64][]string{0: {"foo", "bar"}},
-				mu:    &sync.Mutex{},
-			},
-			args{chatID: 1},
-			[][]string(nil),
-		},
-		{
-			"2 items",
-			fields{
-				items: map[int64][]string{0: {"foo", "bar"}},
-				mu:    &sync.Mutex{},
-			},
-			args{chatID: 0},
-			[][]string{{"foo", "bar"}},
-		},
-		{
-			"11 items",
-			fields{
-				items: map[int64][]string{0: {"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"}},
-				mu:    &sync.Mutex{},
-			},
-			args{chatID: 0},
-			[][]string{{"1", "2", "3", "4", "5", "6", "7", "8", "9"}, {"11", "10"}},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			db := &Inmem{
-				items: tt.fields.items,
-				mu:    tt.fields.mu,
-			}
-			if got := db.GetAll(tt.args.chatID); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GetAll() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
-func TestInmem_Dump(t *testing.T) {
-	_ = os.Mkdir("dumps", os.ModePerm)
-
-	type fields struct {
-		items map[int64][]string
-		mu    *sync.Mutex
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		wantErr bool
-	}{
-		{
-			"valid dump",
-			fields{
-				items: map[int64][]string{0: {"1", "2", "3"}, 1: {"4", "5"}},
-			},
-			false,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			db := &Inmem{
-				items: tt.fields.items,
-				mu:    tt.fields.mu,
-			}
-			if err := db.Dump(); (err != nil) != tt.wantErr {
-				t.Errorf("Dump() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
-
-func TestInmem_Restore(t *testing.T) {
-	_ = os.Mkdir("dumps", 0o600)
-	defer func() {
-		_ = os.Remove("dumps/items.gob")
-		_ = os.Remove("dumps")
-	}()
-
-	type fields struct {
-		items map[int64][]string
-		mu    *sync.Mutex
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		wantErr bool
-	}{
-		{
-			"valid dump",
-			fields{
-				items: map[int64][]string{0: {"1", "2", "3"}, 1: {"4", "5"}},
-			},
-			false,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			db := &Inmem{
-				items: tt.fields.items,
-				mu:    tt.fields.mu,
-			}
-			_ = db.Dump()
-			newDB := &Inmem{items: make(map[int64][]string)}
-			if err := newDB.Restore(); (err != nil) != tt.wantErr {
-				t.Errorf("Restore() error = %v, wantErr %v", err, tt.wantErr)
-				t.Errorf("New DB items = %v, want = %v", newDB.items, db.items)
+// plainItems makes items without quantity
+func plainItems(names ...string) []Item {
+	items := make([]Item, 0, len(names))
+	for _, name := range names {
+		items = append(items, Item{Name: name})
+	}
+	return items
+}
+
+// fakeBot is in-memory TgBot, which records everything has been sent
+type fakeBot struct {
+	handlers map[string]tele.HandlerFunc
+	sent     map[int64][]interface{}
+	polls    map[int]*tele.Poll
+	opened   map[int64][]int
+	stopped  map[int64][]int
+	markups  map[int]*tele.ReplyMarkup
+	edited   map[int]interface{}
+	answers  []*tele.QueryResponse
+	// admins of group chats
+	admins map[int64][]int64
+	// kicked are users removed from group chats
+	kicked map[int64][]int64
+	// stopErr fails StopPoll if it's set
+	stopErr error
+	// memberChecks counts calls of ChatMemberOf
+	memberChecks int
+	lastID       int
+	// middleware of all handlers, like tele.Bot it's applied to handlers added after Use
+	middleware []tele.MiddlewareFunc
+
+	mu sync.Mutex
+}
+
+func newFakeBot() *fakeBot {
+	return &fakeBot{
+		handlers: make(map[string]tele.HandlerFunc),
+		sent:     make(map[int64][]interface{}),
+		polls:    make(map[int]*tele.Poll),
+		opened:   make(map[int64][]int),
+		stopped:  make(map[int64][]int),
+		markups:  make(map[int]*tele.ReplyMarkup),
+		edited:   make(map[int]interface{}),
+		admins:   make(map[int64][]int64),
+		kicked:   make(map[int64][]int64),
+	}
+}
+
+func (b *fakeBot) Handle(endpoint interface{}, h tele.HandlerFunc, m ...tele.MiddlewareFunc) {
+	m = append(append([]tele.MiddlewareFunc(nil), b.middleware...), m...)
+	for i := len(m) - 1; i >= 0; i-- {
+		h = m[i](h)
+	}
+	switch end := endpoint.(type) {
+	case string:
+		b.handlers[end] = h
+	case tele.CallbackEndpoint:
+		b.handlers[end.CallbackUnique()] = h
+	}
+}
+
+func (b *fakeBot) Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	chat := to.(*tele.Chat)
+	b.lastID++
+	msg := &tele.Message{ID: b.lastID, Chat: chat}
+	if p, ok := what.(*tele.Poll); ok {
+		p.ID = fmt.Sprintf("poll%d", msg.ID)
+		b.polls[msg.ID] = p
+		b.opened[chat.ID] = append(b.opened[chat.ID], msg.ID)
+		msg.Poll = p
+	}
+	for _, opt := range opts {
+		if rm, ok := opt.(*tele.ReplyMarkup); ok {
+			b.markups[msg.ID] = rm
+		}
+	}
+	b.sent[chat.ID] = append(b.sent[chat.ID], what)
+	return msg, nil
+}
+
+func (b *fakeBot) EditReplyMarkup(msg tele.Editable, markup *tele.ReplyMarkup) (*tele.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msgID, chatID := msg.MessageSig()
+	id := 0
+	_, _ = fmt.Sscan(msgID, &id)
+	b.markups[id] = markup
+	return &tele.Message{ID: id, Chat: &tele.Chat{ID: chatID}}, nil
+}
+
+func (b *fakeBot) StopPoll(msg tele.Editable, _ ...interface{}) (*tele.Poll, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stopErr != nil {
+		return nil, b.stopErr
+	}
+	msgID, chatID := msg.MessageSig()
+	id := 0
+	_, _ = fmt.Sscan(msgID, &id)
+	p, ok := b.polls[id]
+	if !ok {
+		return nil, tele.NewError(400, "Bad Request: message to stop not found")
+	}
+	if p.Closed {
+		return nil, tele.NewError(400, "Bad Request: poll has already been closed")
+	}
+	p.Closed = true
+	b.stopped[chatID] = append(b.stopped[chatID], id)
+	return p, nil
+}
+
+func (b *fakeBot) ChatMemberOf(chat, user tele.Recipient) (*tele.ChatMember, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.memberChecks++
+	u := user.(*tele.User)
+	for _, id := range b.kicked[chat.(*tele.Chat).ID] {
+		if id == u.ID {
+			return &tele.ChatMember{User: u, Role: tele.Kicked}, nil
+		}
+	}
+	for _, id := range b.admins[chat.(*tele.Chat).ID] {
+		if id == u.ID {
+			return &tele.ChatMember{User: u, Role: tele.Administrator}, nil
+		}
+	}
+	return &tele.ChatMember{User: u, Role: tele.Member}, nil
+}
+
+func (b *fakeBot) Use(middleware ...tele.MiddlewareFunc) {
+	b.middleware = append(b.middleware, middleware...)
+}
+
+func (b *fakeBot) Start() {}
+func (b *fakeBot) Stop()  {}
+
+// vote marks option of every poll sent into chatID as voted
+func (b *fakeBot) vote(chatID int64, option string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, what := range b.sent[chatID] {
+		p, ok := what.(*tele.Poll)
+		if !ok {
+			continue
+		}
+		for i := range p.Options {
+			if p.Options[i].Text == option {
+				p.Options[i].VoterCount++
 			}
-		})
+		}
+	}
+}
+
+// fakeContext is tele.Context for a message sent into particular chat
+type fakeContext struct {
+	tele.Context
+
+	bot    *fakeBot
+	msg    *tele.Message
+	data   string
+	answer *tele.PollAnswer
+	query  *tele.Query
+	result *tele.InlineResult
+	// tapped is set for inline button taps
+	tapped bool
+	// store is kept by Set, like in tele.Context
+	store map[string]interface{}
+}
+
+func (c *fakeContext) Message() *tele.Message    { return c.msg }
+func (c *fakeContext) Recipient() tele.Recipient { return c.msg.Chat }
+
+func (c *fakeContext) Chat() *tele.Chat {
+	if c.msg == nil {
+		return nil
+	}
+	return c.msg.Chat
+}
+
+func (c *fakeContext) Sender() *tele.User {
+	switch {
+	case c.msg != nil:
+		return c.msg.Sender
+	case c.answer != nil:
+		return c.answer.Sender
+	case c.query != nil:
+		return c.query.Sender
+	case c.result != nil:
+		return c.result.Sender
+	}
+	return nil
+}
+
+func (c *fakeContext) Data() string                 { return c.data }
+func (c *fakeContext) PollAnswer() *tele.PollAnswer { return c.answer }
+func (c *fakeContext) Callback() *tele.Callback {
+	if !c.tapped {
+		return nil
+	}
+	return &tele.Callback{Sender: c.msg.Sender, Message: c.msg, Data: c.data}
+}
+
+func (c *fakeContext) Update() tele.Update {
+	return tele.Update{Message: c.msg, PollAnswer: c.answer, Query: c.query, InlineResult: c.result}
+}
+
+func (c *fakeContext) Set(key string, v interface{}) {
+	if c.store == nil {
+		c.store = make(map[string]interface{})
+	}
+	c.store[key] = v
+}
+
+func (c *fakeContext) Get(key string) interface{} { return c.store[key] }
+
+func (c *fakeContext) Query() *tele.Query                        { return c.query }
+func (c *fakeContext) InlineResult() *tele.InlineResult          { return c.result }
+func (c *fakeContext) Respond(_ ...*tele.CallbackResponse) error { return nil }
+
+func (c *fakeContext) Send(what interface{}, opts ...interface{}) error {
+	_, err := c.bot.Send(c.msg.Chat, what, opts...)
+	return err
+}
+
+func (c *fakeContext) Answer(resp *tele.QueryResponse) error {
+	c.bot.mu.Lock()
+	defer c.bot.mu.Unlock()
+	c.bot.answers = append(c.bot.answers, resp)
+	return nil
+}
+
+func (c *fakeContext) Edit(what interface{}, _ ...interface{}) error {
+	c.bot.mu.Lock()
+	defer c.bot.mu.Unlock()
+	c.bot.edited[c.msg.ID] = what
+	return nil
+}
+
+// testUser sends all commands and taps all buttons in tests
+var testUser = &tele.User{ID: 42, Username: "cat"}
+
+// cmd runs handler of command as it was sent into chatID by testUser
+func (b *fakeBot) cmd(t *testing.T, chatID int64, cmd, payload string) {
+	t.Helper()
+	b.cmdIn(t, &tele.Chat{ID: chatID}, testUser, cmd, payload)
+}
+
+// cmdIn runs handler of command as it was sent into the chat by the user
+func (b *fakeBot) cmdIn(t *testing.T, chat *tele.Chat, user *tele.User, cmd, payload string) {
+	t.Helper()
+	h, ok := b.handlers[cmd]
+	if !ok {
+		t.Fatalf("no handler for %s", cmd)
+	}
+	c := &fakeContext{bot: b, msg: &tele.Message{Text: cmd + " " + payload, Chat: chat, Sender: user}}
+	if err := h(c); err != nil {
+		t.Fatalf("%s in chat %d: %v", cmd, chat.ID, err)
+	}
+}
+
+// tap runs handler of inline button as it was tapped on message msgID in chatID
+func (b *fakeBot) tap(t *testing.T, chatID int64, msgID int, btn tele.Btn, data string) {
+	t.Helper()
+	h, ok := b.handlers[btn.CallbackUnique()]
+	if !ok {
+		t.Fatalf("no handler for %s button", btn.Unique)
+	}
+	c := &fakeContext{bot: b, msg: &tele.Message{ID: msgID, Chat: &tele.Chat{ID: chatID}, Sender: testUser}, data: data, tapped: true}
+	if err := h(c); err != nil {
+		t.Fatalf("%s button in chat %d: %v", btn.Unique, chatID, err)
+	}
+}
+
+// answer runs poll answer handler as user voted for options of poll sent as message msgID
+func (b *fakeBot) answer(t *testing.T, msgID int, user *tele.User, options ...int) {
+	t.Helper()
+	h, ok := b.handlers[tele.OnPollAnswer]
+	if !ok {
+		t.Fatalf("no handler for poll answers")
+	}
+	c := &fakeContext{bot: b, answer: &tele.PollAnswer{PollID: b.polls[msgID].ID, Sender: user, Options: options}}
+	if err := h(c); err != nil {
+		t.Fatalf("answer to poll %d: %v", msgID, err)
+	}
+}
+
+// inline runs inline query handler as user typed text after the bot name, and returns the answer
+func (b *fakeBot) inline(t *testing.T, user *tele.User, text string) *tele.QueryResponse {
+	t.Helper()
+	c := &fakeContext{bot: b, query: &tele.Query{Sender: user, Text: text}}
+	if err := b.handlers[tele.OnQuery](c); err != nil {
+		t.Fatalf("inline query %q: %v", text, err)
+	}
+	return b.answers[len(b.answers)-1]
+}
+
+// pick runs chosen inline result handler as user picked result with id
+func (b *fakeBot) pick(t *testing.T, user *tele.User, id string) {
+	t.Helper()
+	c := &fakeContext{bot: b, result: &tele.InlineResult{Sender: user, ResultID: id}}
+	if err := b.handlers[tele.OnInlineResult](c); err != nil {
+		t.Fatalf("inline result %s: %v", id, err)
+	}
+}
+
+// buttons returns texts of all inline buttons of message msgID
+func (b *fakeBot) buttons(msgID int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var texts []string
+	for _, row := range b.markups[msgID].InlineKeyboard {
+		for _, btn := range row {
+			texts = append(texts, btn.Text)
+		}
+	}
+	return texts
+}
+
+func TestSrv_PollSessionsPerChat(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	NewServer(db, bot)
+
+	bot.cmd(t, 1, "/add", "milk, bread")
+	bot.cmd(t, 2, "/add", "milk, eggs")
+	bot.cmd(t, 1, "/list", "")
+	bot.cmd(t, 2, "/list", "")
+
+	bot.vote(1, "milk")
+	bot.cmd(t, 2, "/done", "")
+
+	if len(bot.stopped[1]) != 0 {
+		t.Errorf("/done in chat 2 stopped polls of chat 1: %v", bot.stopped[1])
+	}
+	if len(bot.stopped[2]) != 1 {
+		t.Errorf("/done in chat 2 stopped %d polls, want 1", len(bot.stopped[2]))
+	}
+	if got, _ := db.GetAll(2, defaultList); !reflect.DeepEqual(got, [][]Item{plainItems("milk", "eggs")}) {
+		t.Errorf("chat 2 items = %v, want [[milk eggs]]", got)
+	}
+
+	bot.cmd(t, 1, "/done", "")
+	if len(bot.stopped[1]) != 1 {
+		t.Errorf("/done in chat 1 stopped %d polls, want 1", len(bot.stopped[1]))
+	}
+	if got, _ := db.GetAll(1, defaultList); !reflect.DeepEqual(got, [][]Item{plainItems("bread")}) {
+		t.Errorf("chat 1 items = %v, want [[bread]]", got)
+	}
+}
+
+func TestSrv_PollSessionsConcurrentChats(t *testing.T) {
+	const chats = 20
+
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	NewServer(db, bot)
+
+	for id := int64(0); id < chats; id++ {
+		bot.cmd(t, id, "/add", fmt.Sprintf("a%d, b%d, c%d", id, id, id))
+	}
+
+	var wg sync.WaitGroup
+	for id := int64(0); id < chats; id++ {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			bot.cmd(t, id, "/list", "")
+		}(id)
+	}
+	wg.Wait()
+
+	for id := int64(0); id < chats; id++ {
+		if polls, _ := db.OpenPolls(id, defaultList); len(polls) != 1 {
+			t.Errorf("chat %d has %d open polls, want 1", id, len(polls))
+		}
+	}
+
+	for id := int64(0); id < chats; id++ {
+		bot.vote(id, fmt.Sprintf("b%d", id))
+	}
+	for id := int64(0); id < chats; id++ {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			bot.cmd(t, id, "/done", "")
+		}(id)
+	}
+	wg.Wait()
+
+	for id := int64(0); id < chats; id++ {
+		if want := bot.opened[id][:1]; !reflect.DeepEqual(bot.stopped[id], want) {
+			t.Errorf("chat %d stopped polls %v, want only its own %v", id, bot.stopped[id], want)
+		}
+	}
+}
+
+func TestSrv_Checklist(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	NewServer(db, bot)
+
+	bot.cmd(t, 1, "/view", "checklist")
+	bot.cmd(t, 1, "/add", "1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12")
+	bot.cmd(t, 1, "/list", "")
+
+	if len(bot.opened[1]) != 0 {
+		t.Fatalf("checklist view sent %d polls", len(bot.opened[1]))
+	}
+	msgID := bot.lastID
+	want := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "«", "1/2", "»", "Clear checked"}
+	if got := bot.buttons(msgID); !reflect.DeepEqual(got, want) {
+		t.Errorf("buttons = %v, want %v", got, want)
+	}
+
+	bot.tap(t, 1, msgID, checkBtn, "default|1")
+	bot.tap(t, 1, msgID, checkBtn, "default|0")
+	bot.tap(t, 1, msgID, checkBtn, "default|0")
+	if got := bot.buttons(msgID)[:2]; !reflect.DeepEqual(got, []string{"1", strikethrough("2")}) {
+		t.Errorf("buttons after toggle = %v", got)
+	}
+
+	bot.tap(t, 1, msgID, pageBtn, "default|1")
+	want = []string{"11", "12", "«", "2/2", "»", "Clear checked"}
+	if got := bot.buttons(msgID); !reflect.DeepEqual(got, want) {
+		t.Errorf("buttons on the second page = %v, want %v", got, want)
+	}
+	bot.tap(t, 1, msgID, checkBtn, "default|11")
+
+	bot.tap(t, 1, msgID, clearBtn, "default")
+	wantItems := plainItems("1", "3", "4", "5", "6", "7", "8", "9", "10", "11")
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, wantItems) {
+		t.Errorf("items after clear = %v, want %v", got, wantItems)
+	}
+	want = []string{"1", "3", "4", "5", "6", "7", "8", "9", "10", "11", "Clear checked"}
+	if got := bot.buttons(msgID); !reflect.DeepEqual(got, want) {
+		t.Errorf("buttons after clear = %v, want %v", got, want)
+	}
+
+	// the outdated checklist doesn't clear items checked in the current one
+	bot.cmd(t, 1, "/list", "")
+	current := bot.lastID
+	bot.tap(t, 1, current, checkBtn, "default|0")
+	bot.tap(t, 1, msgID, clearBtn, "default")
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, wantItems) {
+		t.Errorf("items after clear of outdated checklist = %v, want %v", got, wantItems)
+	}
+	if got, _ := db.History(1, time.Time{}); len(got) != 2 {
+		t.Errorf("history after clear of outdated checklist = %v, want 2 purchases", got)
+	}
+	bot.tap(t, 1, current, clearBtn, "default")
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, wantItems[1:]) {
+		t.Errorf("items after clear of current checklist = %v, want %v", got, wantItems[1:])
+	}
+
+	// the other chat still uses polls
+	bot.cmd(t, 2, "/add", "foo, bar")
+	bot.cmd(t, 2, "/list", "")
+	if len(bot.opened[2]) != 1 {
+		t.Errorf("poll view sent %d polls, want 1", len(bot.opened[2]))
+	}
+}
+
+func TestSrv_AddStructuredItems(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	NewServer(db, bot)
+
+	bot.cmd(t, 1, "/add", "2x milk, 1 kg potatoes\neggs (free range)")
+	bot.cmd(t, 1, "/add", "milk, potatoes 500g")
+	bot.cmd(t, 1, "/list", "")
+
+	p, ok := bot.sent[1][len(bot.sent[1])-2].(*tele.Poll)
+	if !ok {
+		t.Fatalf("poll hasn't been sent")
+	}
+	var got []string
+	for _, o := range p.Options {
+		got = append(got, o.Text)
+	}
+	// produce goes before dairy
+	want := []string{"potatoes 1.5 kg", "milk ×3", "eggs (free range)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("poll options = %v, want %v", got, want)
+	}
+
+	bot.vote(1, "potatoes 1.5 kg")
+	bot.cmd(t, 1, "/done", "")
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, []Item{{Name: "milk", Quantity: 3}, {Name: "eggs", Note: "free range"}}) {
+		t.Errorf("items after /done = %v", got)
+	}
+}
+
+func TestSrv_AddDuplicates(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	srv := NewServer(db, bot)
+	srv.foldPlurals = true
+
+	lastReply := func() interface{} { return bot.sent[1][len(bot.sent[1])-1] }
+
+	bot.cmd(t, 1, "/add", "Milk, 2 tomatoes")
+	if got := lastReply(); got != "Added 2 item(s): Milk, tomatoes" {
+		t.Errorf("/add reply = %q", got)
+	}
+	bot.cmd(t, 1, "/add", "milk , Tomato,  oat   milk, eggs, Eggs\nOat Milk")
+	if got, want := lastReply(), "Added 2 item(s): oat milk, eggs\nAlready on the list: Milk, tomatoes"; got != want {
+		t.Errorf("/add reply = %q, want %q", got, want)
+	}
+	want := []Item{{Name: "Milk"}, {Name: "tomatoes", Quantity: 3}, {Name: "oat milk"}, {Name: "eggs"}}
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, want) {
+		t.Errorf("items = %v, want %v", got, want)
+	}
+
+	bot.cmd(t, 1, "/add", "cookies, shoes")
+	bot.cmd(t, 1, "/add", "cookie, shoe")
+	if got, want := lastReply(), "Already on the list: cookies, shoes"; got != want {
+		t.Errorf("/add reply = %q, want %q", got, want)
+	}
+
+	srv.foldPlurals = false
+	bot.cmd(t, 1, "/add", "egg")
+	if got := lastReply(); got != "Added 1 item(s): egg" {
+		t.Errorf("/add reply without plural folding = %q", got)
+	}
+}
+
+func TestSrv_DefaultView(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	srv := NewServer(db, bot)
+	srv.defaultView = viewChecklist
+
+	bot.cmd(t, 1, "/add", "milk, eggs")
+	bot.cmd(t, 1, "/list", "")
+	if len(bot.opened[1]) != 0 || bot.buttons(bot.lastID) == nil {
+		t.Errorf("default checklist view sent %d polls", len(bot.opened[1]))
+	}
+
+	bot.cmd(t, 1, "/view", "poll")
+	bot.cmd(t, 1, "/list", "")
+	if len(bot.opened[1]) != 1 {
+		t.Errorf("poll view of the chat sent %d polls, want 1", len(bot.opened[1]))
 	}
 }
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// pollGrace is how long getUpdates could fail over its timeout before the bot isn't ready
+const pollGrace = time.Minute
+
+// latencyBuckets are upper bounds of handler and snapshot duration histograms, in seconds
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metrics collects stats of the bot exposed in Prometheus text format, and tracks its readiness.
+// Nil metrics collect nothing
+type metrics struct {
+	updates   map[updateKey]int64
+	latency   map[string]*histogram
+	apiErrors map[apiErrorKey]int64
+
+	snapshots        *histogram
+	snapshotFailures int64
+
+	// restored is set when the storage has been restored on start
+	restored bool
+	// lastPoll is the time of the last successful getUpdates
+	lastPoll time.Time
+	// pollEvery is the timeout of long polling, zero if updates come by webhook
+	pollEvery time.Duration
+
+	mu sync.Mutex
+}
+
+type updateKey struct {
+	command string
+	failed  bool
+}
+
+type apiErrorKey struct {
+	method string
+	code   int
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		updates:   make(map[updateKey]int64),
+		latency:   make(map[string]*histogram),
+		apiErrors: make(map[apiErrorKey]int64),
+		snapshots: newHistogram(),
+	}
+}
+
+// observeUpdate counts the handled update and its handling time
+func (m *metrics) observeUpdate(command string, took time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updates[updateKey{command, err != nil}]++
+	h, ok := m.latency[command]
+	if !ok {
+		h = newHistogram()
+		m.latency[command] = h
+	}
+	h.observe(took.Seconds())
+}
+
+// observeSnapshot counts the dump of the storage and its time
+func (m *metrics) observeSnapshot(took time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots.observe(took.Seconds())
+	if err != nil {
+		m.snapshotFailures++
+	}
+}
+
+// observeCall counts the failed call of tg api, code is zero if tg hasn't been reached.
+// Successful getUpdates keep the bot ready
+func (m *metrics) observeCall(method string, code int, ok bool, at time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !ok {
+		m.apiErrors[apiErrorKey{method, code}]++
+		return
+	}
+	if method == "getUpdates" {
+		m.lastPoll = at
+	}
+}
+
+// setRestored marks the storage restored on start
+func (m *metrics) setRestored(ok bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.restored = ok
+}
+
+// notReady returns the reasons why the bot isn't ready, none if it is
+func (m *metrics) notReady(now time.Time) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var reasons []string
+	if !m.restored {
+		reasons = append(reasons, "storage hasn't been restored")
+	}
+	if m.pollEvery > 0 && now.Sub(m.lastPoll) > m.pollEvery+pollGrace {
+		if m.lastPoll.IsZero() {
+			reasons = append(reasons, "updates haven't been polled yet")
+		} else {
+			reasons = append(reasons, fmt.Sprintf("updates haven't been polled since %s", m.lastPoll.Format(time.RFC3339)))
+		}
+	}
+	return reasons
+}
+
+// client returns http client of tg api, which counts failed calls. Its timeout is longer than the long poll
+func (m *metrics) client(pollTimeout time.Duration) *http.Client {
+	return &http.Client{Timeout: pollTimeout + time.Minute, Transport: &apiTransport{m: m, next: http.DefaultTransport}}
+}
+
+// apiTransport reads results of tg api calls, tg answers with ok field and error code even on failures
+type apiTransport struct {
+	m    *metrics
+	next http.RoundTripper
+}
+
+func (t *apiTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		// the long poll cancelled on stop isn't an error of tg
+		if r.Context().Err() == nil {
+			t.m.observeCall(method, 0, false, time.Now())
+		}
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.m.observeCall(method, 0, false, time.Now())
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var result struct {
+		OK   bool `json:"ok"`
+		Code int  `json:"error_code"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		result.Code = resp.StatusCode
+	}
+	t.m.observeCall(method, result.Code, result.OK, time.Now())
+	return resp, nil
+}
+
+// instrument counts updates and their handling time by command
+func (s *Srv) instrument(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		start := time.Now()
+		err := next(c)
+		s.metrics.observeUpdate(s.updateCommand(c), time.Since(start), err)
+		return err
+	}
+}
+
+// updateCommand names the update for metrics: the command of the message, the button of the tap or the kind of update.
+// Unknown commands are "other", so anyone can't add labels by sending made up commands
+func (s *Srv) updateCommand(c tele.Context) string {
+	switch {
+	case c.Callback() != nil:
+		return "btn:" + c.Callback().Unique
+	case c.PollAnswer() != nil:
+		return "poll_answer"
+	case c.Query() != nil:
+		return "inline_query"
+	case c.InlineResult() != nil:
+		return "inline_result"
+	case c.Message() != nil && strings.HasPrefix(c.Message().Text, "/"):
+		cmd := strings.Fields(c.Message().Text)[0]
+		if i := strings.Index(cmd, "@"); i > 0 {
+			cmd = cmd[:i]
+		}
+		if s.commands[cmd] {
+			return cmd
+		}
+	}
+	return "other"
+}
+
+// ServeMonitoring serves /metrics, /healthz and /readyz on the listener until it's closed
+func (s *Srv) ServeMonitoring(l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/readyz", s.serveReady)
+	return (&http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}).Serve(l)
+}
+
+func (s *Srv) serveReady(w http.ResponseWriter, r *http.Request) {
+	if reasons := s.metrics.notReady(time.Now()); len(reasons) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = io.WriteString(w, strings.Join(reasons, "\n")+"\n")
+		return
+	}
+	_, _ = io.WriteString(w, "ready\n")
+}
+
+func (s *Srv) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := s.writeMetrics(&buf); err != nil {
+		s.logger.Error("can't collect metrics", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// writeMetrics writes all metrics in Prometheus text format. Gauges of the storage are read on the fly
+func (s *Srv) writeMetrics(w io.Writer) error {
+	counts, err := s.db.ItemCounts()
+	if err != nil {
+		return fmt.Errorf("can't count items: %w", err)
+	}
+	polls, err := s.db.AllOpenPolls()
+	if err != nil {
+		return fmt.Errorf("can't get polls: %w", err)
+	}
+
+	m := s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header(w, "scbot_updates_total", "counter", "Updates handled by command")
+	updates := make([]updateKey, 0, len(m.updates))
+	for k := range m.updates {
+		updates = append(updates, k)
+	}
+	sort.Slice(updates, func(i, j int) bool {
+		if updates[i].command != updates[j].command {
+			return updates[i].command < updates[j].command
+		}
+		return !updates[i].failed && updates[j].failed
+	})
+	for _, k := range updates {
+		result := "ok"
+		if k.failed {
+			result = "error"
+		}
+		fmt.Fprintf(w, "scbot_updates_total{command=%s,result=%s} %d\n", quoteLabel(k.command), quoteLabel(result), m.updates[k])
+	}
+
+	header(w, "scbot_handler_duration_seconds", "histogram", "Time of handling updates by command")
+	commands := make([]string, 0, len(m.latency))
+	for cmd := range m.latency {
+		commands = append(commands, cmd)
+	}
+	sort.Strings(commands)
+	for _, cmd := range commands {
+		m.latency[cmd].write(w, "scbot_handler_duration_seconds", "command="+quoteLabel(cmd))
+	}
+
+	header(w, "scbot_telegram_api_errors_total", "counter", "Failed calls of Telegram Bot API by method and error code, 0 if it hasn't been reached")
+	apiErrors := make([]apiErrorKey, 0, len(m.apiErrors))
+	for k := range m.apiErrors {
+		apiErrors = append(apiErrors, k)
+	}
+	sort.Slice(apiErrors, func(i, j int) bool {
+		if apiErrors[i].method != apiErrors[j].method {
+			return apiErrors[i].method < apiErrors[j].method
+		}
+		return apiErrors[i].code < apiErrors[j].code
+	})
+	for _, k := range apiErrors {
+		fmt.Fprintf(w, "scbot_telegram_api_errors_total{method=%s,code=%s} %d\n", quoteLabel(k.method), quoteLabel(strconv.Itoa(k.code)), m.apiErrors[k])
+	}
+
+	// items aren't labelled by chat, series of every chat would grow without limit
+	items := 0
+	for _, n := range counts {
+		items += n
+	}
+	header(w, "scbot_items", "gauge", "Items in all lists of all chats")
+	fmt.Fprintf(w, "scbot_items %d\n", items)
+	header(w, "scbot_chats_with_items", "gauge", "Chats which have any items")
+	fmt.Fprintf(w, "scbot_chats_with_items %d\n", len(counts))
+
+	header(w, "scbot_open_polls", "gauge", "Polls sent by /list and waiting for /done")
+	fmt.Fprintf(w, "scbot_open_polls %d\n", len(polls))
+
+	header(w, "scbot_snapshot_duration_seconds", "histogram", "Time of dumps of the storage")
+	m.snapshots.write(w, "scbot_snapshot_duration_seconds", "")
+	header(w, "scbot_snapshot_failures_total", "counter", "Failed dumps of the storage")
+	fmt.Fprintf(w, "scbot_snapshot_failures_total %d\n", m.snapshotFailures)
+
+	header(w, "scbot_rejected_total", "counter", "Updates and requests rejected by reason")
+	for _, reason := range []string{rejectNotAllowed, rejectRateLimit, rejectQuota} {
+		fmt.Fprintf(w, "scbot_rejected_total{reason=%s} %d\n", quoteLabel(reason), s.rejected.get(reason))
+	}
+	return nil
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// histogram counts observations in latencyBuckets
+type histogram struct {
+	buckets []int64
+	count   int64
+	sum     float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{buckets: make([]int64, len(latencyBuckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range latencyBuckets {
+		if v <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// write writes cumulative buckets, sum and count of the histogram with extra labels
+func (h *histogram) write(w io.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, le := range latencyBuckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=%s} %d\n", name, labels, sep, quoteLabel(strconv.FormatFloat(le, 'g', -1, 64)), h.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestSrv_Metrics(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}, path: filepath.Join(t.TempDir(), "dump.gob")}
+	srv := NewServer(db, bot)
+	db.metrics = srv.metrics
+
+	bot.cmd(t, 1, "/add", "milk, bread")
+	bot.cmd(t, 1, "/add", "eggs")
+	// commands are counted without the mention of the bot
+	c := &fakeContext{bot: bot, msg: &tele.Message{Text: "/add@ShoppingCatBot tea", Chat: &tele.Chat{ID: 2}, Sender: testUser}}
+	if err := bot.handlers[addCmd](c); err != nil {
+		t.Fatalf("/add with mention: %v", err)
+	}
+	bot.cmd(t, 2, "/list", "")
+	fail := srv.instrument(func(c tele.Context) error { return errors.New("can't do it") })
+	_ = fail(&fakeContext{bot: bot, msg: &tele.Message{Text: "/done", Chat: &tele.Chat{ID: 2}}})
+	// made up commands don't make new labels
+	ok := srv.instrument(func(c tele.Context) error { return nil })
+	for _, text := range []string{"/a1", "/a2@ShoppingCatBot"} {
+		_ = ok(&fakeContext{bot: bot, msg: &tele.Message{Text: text, Chat: &tele.Chat{ID: 2}}})
+	}
+	// updates rejected by the allowlist aren't counted
+	srv.allowedChats = idSet([]int64{1})
+	c = &fakeContext{bot: bot, msg: &tele.Message{Text: "/list", Chat: &tele.Chat{ID: 3}, Sender: testUser}}
+	if err := bot.handlers[listCmd](c); err != nil {
+		t.Fatalf("/list from not allowed chat: %v", err)
+	}
+	srv.allowedChats = nil
+	srv.rejected.inc(rejectQuota)
+	if err := db.Dump(); err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := srv.writeMetrics(&buf); err != nil {
+		t.Fatalf("writeMetrics() error = %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		`scbot_updates_total{command="/add",result="ok"} 3`,
+		`scbot_updates_total{command="/done",result="error"} 1`,
+		`scbot_handler_duration_seconds_bucket{command="/add",le="+Inf"} 3`,
+		`scbot_handler_duration_seconds_count{command="/list"} 1`,
+		`scbot_updates_total{command="other",result="ok"} 2`,
+		`scbot_items 4`,
+		`scbot_chats_with_items 2`,
+		`scbot_open_polls 0`,
+		`scbot_snapshot_duration_seconds_count 1`,
+		`scbot_snapshot_failures_total 0`,
+		`scbot_rejected_total{reason="quota"} 1`,
+		"# TYPE scbot_telegram_api_errors_total counter",
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("metrics don't have %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `command="/a1"`) {
+		t.Errorf("metrics have the made up command:\n%s", got)
+	}
+	if strings.Contains(got, "chat=") {
+		t.Errorf("metrics are labelled by chat:\n%s", got)
+	}
+}
+
+func TestMetrics_NotReady(t *testing.T) {
+	now := time.Date(2022, 10, 17, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		restored  bool
+		pollEvery time.Duration
+		lastPoll  time.Time
+		want      int
+	}{
+		{"webhook", true, 0, time.Time{}, 0},
+		{"not restored", false, 0, time.Time{}, 1},
+		{"never polled", true, 10 * time.Second, time.Time{}, 1},
+		{"recently polled", true, 10 * time.Second, now.Add(-time.Minute), 0},
+		{"polled long ago", true, 10 * time.Second, now.Add(-2 * time.Minute), 1},
+		{"neither", false, 10 * time.Second, time.Time{}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMetrics()
+			m.setRestored(tt.restored)
+			m.pollEvery = tt.pollEvery
+			m.lastPoll = tt.lastPoll
+			if got := m.notReady(now); len(got) != tt.want {
+				t.Errorf("notReady() = %q, want %d reasons", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetrics_Client(t *testing.T) {
+	api := newFakeAPI(t)
+	m := newMetrics()
+	b, err := makeBot(tele.Settings{URL: api.URL, Token: "token", Client: m.client(time.Second)}, Config{Poller: "long", PollTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("makeBot() error = %v", err)
+	}
+	// long polls don't time out on the client side
+	if got := m.client(90 * time.Second).Timeout; got <= 90*time.Second {
+		t.Errorf("client timeout = %s for long polls of 90s", got)
+	}
+
+	api.fail("sendMessage", 403, "Forbidden: bot was blocked by the user")
+	if _, err := b.Send(&tele.Chat{ID: 1}, "hi"); err == nil {
+		t.Errorf("Send() error = nil")
+	}
+	if _, err := b.Send(&tele.Chat{ID: 1}, "hi"); err != nil {
+		t.Errorf("Send() error = %v", err)
+	}
+	if _, err := b.Raw("getUpdates", map[string]string{"timeout": "0"}); err != nil {
+		t.Errorf("getUpdates error = %v", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if got := m.apiErrors[apiErrorKey{"sendMessage", 403}]; got != 1 || len(m.apiErrors) != 1 {
+		t.Errorf("api errors = %v, want one of sendMessage", m.apiErrors)
+	}
+	if m.lastPoll.IsZero() {
+		t.Errorf("successful getUpdates hasn't been recorded")
+	}
+}
+
+func TestSrv_ServeMonitoring(t *testing.T) {
+	srv := NewServer(&Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}, newFakeBot())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() { _ = srv.ServeMonitoring(l) }()
+
+	status := func(path string) int {
+		t.Helper()
+		resp, err := http.Get("http://" + l.Addr().String() + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	tests := []struct {
+		path     string
+		restored bool
+		want     int
+	}{
+		{"/healthz", false, http.StatusOK},
+		{"/readyz", false, http.StatusServiceUnavailable},
+		{"/readyz", true, http.StatusOK},
+		{"/metrics", true, http.StatusOK},
+		{"/debug", true, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		srv.metrics.setRestored(tt.restored)
+		if got := status(tt.path); got != tt.want {
+			t.Errorf("GET %s with restored %v = %d, want %d", tt.path, tt.restored, got, tt.want)
+		}
+	}
+}
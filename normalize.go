@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// foldName returns comparable form of item name: case folded, with collapsed whitespace and "ё" as "е"
+func foldName(s string) string {
+	var b strings.Builder
+	for i, w := range strings.Fields(s) {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		for _, r := range w {
+			b.WriteRune(foldRune(r))
+		}
+	}
+	return b.String()
+}
+
+// foldRune maps all runes of the same case orbit, like "K", "k" and Kelvin sign, to the same lower case rune
+func foldRune(r rune) rune {
+	lowest := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < lowest {
+			lowest = f
+		}
+	}
+	r = unicode.ToLower(lowest)
+	if r == 'ё' {
+		r = 'е'
+	}
+	return r
+}
+
+// singular folds the last word of folded name to the stem shared by its singular and plural forms,
+// so "tomatoes" and "tomato", "cookies" and "cookie", "помидоры" and "помидор" are the same item.
+// It's a rough guess, not a real stemmer: english plural endings are cut, "y" and "ie" become "i"
+// as they do before "es", russian words lose their last vowel
+func singular(name string) string {
+	i := strings.LastIndexByte(name, ' ') + 1
+	return name[:i] + singularWord(name[i:])
+}
+
+func singularWord(w string) string {
+	if utf8.RuneCountInString(w) < 3 {
+		return w
+	}
+
+	last, size := utf8.DecodeLastRuneInString(w)
+	if unicode.Is(unicode.Cyrillic, last) {
+		if strings.ContainsRune("аяоеыиь", last) {
+			return w[:len(w)-size]
+		}
+		return w
+	}
+
+	switch {
+	case strings.HasSuffix(w, "ies"):
+		return strings.TrimSuffix(w, "es")
+	case strings.HasSuffix(w, "ie"), strings.HasSuffix(w, "oe"):
+		return strings.TrimSuffix(w, "e")
+	case strings.HasSuffix(w, "y") && !hasSuffix(w, "ay", "ey", "oy", "uy"):
+		return strings.TrimSuffix(w, "y") + "i"
+	case hasSuffix(w, "oes", "ches", "shes", "xes", "sses", "zes"):
+		return strings.TrimSuffix(w, "es")
+	case strings.HasSuffix(w, "s") && !hasSuffix(w, "ss", "us", "is"):
+		return strings.TrimSuffix(w, "s")
+	}
+	return w
+}
+
+func hasSuffix(s string, suffixes ...string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// itemKey is the normalized name, items with the same key are the same item
+func (s *Srv) itemKey(name string) string {
+	key := foldName(name)
+	if s.foldPlurals {
+		key = singular(key)
+	}
+	return key
+}
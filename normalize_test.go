@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestFoldName(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"Milk", "milk"},
+		{"  oat   milk ", "oat milk"},
+		{"Молоко", "молоко"},
+		{"Ёжики", "ежики"},
+		{"\u212aiwi", "kiwi"}, // Kelvin sign
+		{"STRASSE", "strasse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a, func(t *testing.T) {
+			if got, want := foldName(tt.a), foldName(tt.b); got != want {
+				t.Errorf("foldName(%q) = %q, want %q", tt.a, got, want)
+			}
+		})
+	}
+}
+
+func TestSingular(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"tomatoes", "tomato"},
+		{"tomato", "tomato"},
+		{"cherry tomatoes", "cherry tomato"},
+		{"berries", "berri"},
+		{"berry", "berri"},
+		{"cookies", "cooki"},
+		{"cookie", "cooki"},
+		{"shoes", "sho"},
+		{"shoe", "sho"},
+		{"pies", "pi"},
+		{"pie", "pi"},
+		{"keys", "key"},
+		{"key", "key"},
+		{"peaches", "peach"},
+		{"boxes", "box"},
+		{"glasses", "glass"},
+		{"glass", "glass"},
+		{"apples", "apple"},
+		{"eggs", "egg"},
+		{"hummus", "hummus"},
+		{"tea", "tea"},
+		{"помидоры", "помидор"},
+		{"помидор", "помидор"},
+		{"яйца", "яйц"},
+		{"яйцо", "яйц"},
+		{"сыр", "сыр"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := singular(tt.name); got != tt.want {
+				t.Errorf("singular(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// minRetryDelay and maxRetryDelay bound the pause after failed getUpdates, it doubles on every failure in a row
+	minRetryDelay = time.Second
+	maxRetryDelay = time.Minute
+)
+
+// failingPoller is tele.Poller which reports failures it can't recover from, Run shuts the server down on them
+type failingPoller interface {
+	failures() <-chan error
+}
+
+// longPoller is tele.Poller, which gets updates by long polling. tele.LongPoller is not used, because its request
+// can't be cancelled, so stopping waits for the poll timeout, and it retries failed requests in a busy loop forever,
+// even if the token is revoked
+type longPoller struct {
+	client  *http.Client
+	url     string
+	timeout time.Duration
+	lastID  int
+	errs    chan error
+}
+
+func newLongPoller(client *http.Client, apiURL, token string, timeout time.Duration) *longPoller {
+	return &longPoller{
+		client:  client,
+		url:     apiURL + "/bot" + token + "/getUpdates",
+		timeout: timeout,
+		errs:    make(chan error, 1),
+	}
+}
+
+func (p *longPoller) failures() <-chan error {
+	return p.errs
+}
+
+// Poll gets updates until stop is closed or tg rejects the token, failed requests are retried with backoff
+func (p *longPoller) Poll(_ *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	delay := minRetryDelay
+	for ctx.Err() == nil {
+		updates, err := p.getUpdates(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		var apiErr *tele.Error
+		if errors.As(err, &apiErr) && (apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusNotFound) {
+			p.errs <- fmt.Errorf("can't get updates: %w", err)
+			return
+		}
+		if err != nil {
+			defaultLogger().Warn("can't get updates", "err", err, "retry_in", delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			if delay *= 2; delay > maxRetryDelay {
+				delay = maxRetryDelay
+			}
+			continue
+		}
+		delay = minRetryDelay
+
+		for _, u := range updates {
+			p.lastID = u.ID
+			select {
+			case dest <- u:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// getUpdates gets updates after the last one, updates before it are confirmed to tg by the offset
+func (p *longPoller) getUpdates(ctx context.Context) ([]tele.Update, error) {
+	body, err := json.Marshal(map[string]string{
+		"offset":  strconv.Itoa(p.lastID + 1),
+		"timeout": strconv.Itoa(int(p.timeout / time.Second)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		OK          bool          `json:"ok"`
+		Code        int           `json:"error_code"`
+		Description string        `json:"description"`
+		Result      []tele.Update `json:"result"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("can't decode updates: %w", err)
+	}
+	if !result.OK {
+		return nil, tele.NewError(result.Code, result.Description)
+	}
+	return result.Result, nil
+}
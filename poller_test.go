@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestLongPoller(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    int
+		desc    string
+		wantErr string
+	}{
+		{"retried", 502, "Bad Gateway", ""},
+		{"revoked token", 401, "Unauthorized", "Unauthorized"},
+		{"wrong token", 404, "Not Found", "Not Found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.fail("getUpdates", tt.code, tt.desc)
+			api.send(1, &tele.User{ID: 42}, "/list")
+
+			p := newLongPoller(http.DefaultClient, api.URL, "token", time.Second)
+			dest, stop, stopped := make(chan tele.Update, 1), make(chan struct{}), make(chan struct{})
+			go func() {
+				p.Poll(nil, dest, stop)
+				close(stopped)
+			}()
+			defer func() {
+				close(stop)
+				<-stopped
+			}()
+
+			select {
+			case u := <-dest:
+				if tt.wantErr != "" || u.Message == nil || u.Message.Text != "/list" {
+					t.Errorf("update = %+v, want error %q", u, tt.wantErr)
+				}
+			case err := <-p.failures():
+				if tt.wantErr == "" || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("failure = %v, want %q", err, tt.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("neither the update nor the failure has come")
+			}
+		})
+	}
+}
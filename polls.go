@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// OpenPoll is a poll sent by /list, it's kept in the storage until /done stops it,
+// so polls could be stopped after restart as well. Poll answers don't have chat, so the poll is found by its tg id
+type OpenPoll struct {
+	ID        string
+	ChatID    int64
+	MessageID int
+	List      string
+	// Items are in order of poll options
+	Items []PollItem
+}
+
+// PollItem is an item behind the poll option
+type PollItem struct {
+	Item Item
+	// Voters are ids of users who have ticked the option, the first one buys the item
+	Voters []int64
+	// Names of the voters by their ids, for purchase history
+	Names map[int64]string
+	// BoughtAt is the time of the purchase made by the first tick in live mode, zero if the item is not bought
+	BoughtAt time.Time
+}
+
+// message returns the poll message, which could be stopped
+func (p OpenPoll) message() tele.Editable {
+	return &tele.StoredMessage{MessageID: strconv.Itoa(p.MessageID), ChatID: p.ChatID}
+}
+
+// buyer returns id and name of the first voter still ticking the option, or of by if there is none
+func (pi PollItem) buyer(by *tele.User) (userID int64, name string) {
+	if len(pi.Voters) == 0 {
+		if by == nil {
+			return 0, ""
+		}
+		return by.ID, userName(by)
+	}
+	return pi.Voters[0], pi.Names[pi.Voters[0]]
+}
+
+// openPoll remembers poll sent into the chat
+func (s *Srv) openPoll(chatID int64, list string, msg *tele.Message, items []Item) error {
+	if msg.Poll == nil {
+		return errors.New("sent message has no poll")
+	}
+	p := OpenPoll{ID: msg.Poll.ID, ChatID: chatID, MessageID: msg.ID, List: list, Items: make([]PollItem, len(items))}
+	for i, item := range items {
+		p.Items[i].Item = item
+	}
+	if err := s.db.SaveOpenPoll(p); err != nil {
+		return fmt.Errorf("can't save poll: %w", err)
+	}
+	return nil
+}
+
+// stopPolls stops all open polls of the list and buys voted items on behalf of the ones who ticked them first,
+// or on behalf of the user if the votes haven't been seen. Items already bought in live mode, and the ones
+// which aren't in the list anymore, e.g. bought by another poll, are skipped. Every bought item is marked
+// in its poll, so a failed /done could be repeated. Deleted polls are forgotten, polls which can't be
+// stopped for now are kept for the next /done
+func (s *Srv) stopPolls(chatID int64, list string, by *tele.User, at time.Time) ([]Item, error) {
+	s.pollsMu.Lock()
+	defer s.pollsMu.Unlock()
+
+	polls, err := s.db.OpenPolls(chatID, list)
+	if err != nil {
+		return nil, fmt.Errorf("can't get polls: %w", err)
+	}
+
+	items, err := s.db.List(chatID, list)
+	if err != nil {
+		return nil, fmt.Errorf("can't get items: %w", err)
+	}
+	// items of overlapping polls are bought once, the ones bought by another poll aren't in the list anymore
+	inList := make(map[string]bool, len(items))
+	for _, item := range items {
+		inList[s.itemKey(item.Name)] = true
+	}
+
+	var removed []Item
+	for _, p := range polls {
+		voted, ok := s.stopPoll(p)
+		if !ok {
+			continue
+		}
+		for i := range p.Items {
+			pi := &p.Items[i]
+			key := s.itemKey(pi.Item.Name)
+			if !voted[i] || !pi.BoughtAt.IsZero() || !inList[key] {
+				continue
+			}
+			userID, name := pi.buyer(by)
+			if err := s.buyAs(chatID, list, pi.Item, userID, name, at); err != nil {
+				return removed, err
+			}
+			delete(inList, key)
+			removed = append(removed, pi.Item)
+			pi.BoughtAt = at
+			if err := s.db.SaveOpenPoll(p); err != nil {
+				return removed, fmt.Errorf("can't save poll: %w", err)
+			}
+		}
+		if err := s.db.DeleteOpenPoll(p.ID); err != nil {
+			return removed, fmt.Errorf("can't delete poll: %w", err)
+		}
+	}
+	return removed, nil
+}
+
+// stopPoll stops the poll and tells which of its items are voted. If the poll is already closed, e.g. by
+// the failed /done, voters seen by answers are used. ok is false if the poll should be kept for now
+func (s *Srv) stopPoll(p OpenPoll) (voted []bool, ok bool) {
+	voted = make([]bool, len(p.Items))
+	stopped, err := s.bot.StopPoll(p.message())
+	switch {
+	case err == nil:
+		for i, o := range stopped.Options {
+			if i < len(voted) {
+				voted[i] = o.VoterCount > 0
+			}
+		}
+	case isPollError(err, errPollClosed):
+		for i, pi := range p.Items {
+			voted[i] = len(pi.Voters) > 0
+		}
+	case isPollError(err, errPollNotFound, errPollMsgNotFound):
+		s.logger.Warn("poll is deleted, forgetting it", "chat", p.ChatID, "message", p.MessageID, "err", err)
+	default:
+		s.logger.Warn("can't stop poll, keeping it", "chat", p.ChatID, "message", p.MessageID, "err", err)
+		return nil, false
+	}
+	return voted, true
+}
+
+// descriptions of final errors of stopPoll, the poll won't be stopped ever
+const (
+	errPollClosed      = "poll has already been closed"
+	errPollNotFound    = "message to stop not found"
+	errPollMsgNotFound = "message with poll to stop not found"
+)
+
+// isPollError reports if err is the error of tg with any of the descriptions.
+// Telebot doesn't know errors of polls, they are told by the text only
+func isPollError(err error, descriptions ...string) bool {
+	for _, d := range descriptions {
+		if strings.Contains(err.Error(), d) {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcilePolls goes through polls left open by the previous run. Polls without any
+// item still in the list are useless, they are stopped and forgotten. The rest, and polls
+// which can't be stopped for now, wait for /done
+func (s *Srv) reconcilePolls() error {
+	s.pollsMu.Lock()
+	defer s.pollsMu.Unlock()
+
+	polls, err := s.db.AllOpenPolls()
+	if err != nil {
+		return fmt.Errorf("can't get polls: %w", err)
+	}
+
+	for _, p := range polls {
+		items, err := s.db.List(p.ChatID, p.List)
+		if err != nil {
+			return fmt.Errorf("can't get items: %w", err)
+		}
+		if hasPollItems(p, items) {
+			continue
+		}
+		if _, err := s.bot.StopPoll(p.message()); err != nil && !isPollError(err, errPollClosed, errPollNotFound, errPollMsgNotFound) {
+			s.logger.Warn("can't stop poll, keeping it", "chat", p.ChatID, "message", p.MessageID, "err", err)
+			continue
+		}
+		if err := s.db.DeleteOpenPoll(p.ID); err != nil {
+			return fmt.Errorf("can't delete poll: %w", err)
+		}
+	}
+	return nil
+}
+
+// hasPollItems reports if any item of the poll, which is not bought yet, is still in the list
+func hasPollItems(p OpenPoll, items []Item) bool {
+	for _, pi := range p.Items {
+		if !pi.BoughtAt.IsZero() {
+			continue
+		}
+		for _, item := range items {
+			if sameItem(item.Name, pi.Item.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// failingPurchases is inmem storage which can't save purchases of the item
+type failingPurchases struct {
+	*Inmem
+	fail string
+}
+
+func (s *failingPurchases) AddPurchase(chatID int64, p Purchase) error {
+	if p.Item.Name == s.fail {
+		return errors.New("disk is full")
+	}
+	return s.Inmem.AddPurchase(chatID, p)
+}
+
+func TestSrv_PollsSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.gob")
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), path: path, mu: &sync.Mutex{}}
+	NewServer(db, bot)
+
+	bot.cmd(t, 1, "/add", "milk, bread, eggs")
+	bot.cmd(t, 1, "/newlist", "hardware")
+	bot.cmd(t, 1, "/add", "@hardware screws, nails")
+	bot.cmd(t, 1, "/list", "")
+	bot.cmd(t, 1, "/list", "@hardware")
+	if err := db.Dump(); err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+
+	restarted := &Inmem{path: path, mu: &sync.Mutex{}}
+	if err := restarted.Restore(); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	srv := NewServer(restarted, bot)
+	if err := srv.reconcilePolls(); err != nil {
+		t.Fatalf("reconcilePolls() error = %v", err)
+	}
+	if len(bot.stopped[1]) != 0 {
+		t.Errorf("reconcile stopped polls with items: %v", bot.stopped[1])
+	}
+
+	bot.vote(1, "bread")
+	bot.cmd(t, 1, "/done", "")
+	if want := bot.opened[1][:1]; !reflect.DeepEqual(bot.stopped[1], want) {
+		t.Errorf("/done after restart stopped %v, want %v", bot.stopped[1], want)
+	}
+	if got, _ := restarted.List(1, defaultList); !reflect.DeepEqual(got, plainItems("milk", "eggs")) {
+		t.Errorf("items after /done = %v, want [milk eggs]", got)
+	}
+	if polls, _ := restarted.OpenPolls(1, "hardware"); len(polls) != 1 {
+		t.Errorf("hardware list has %d open polls, want 1", len(polls))
+	}
+}
+
+func TestSrv_ReconcilePolls(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	srv := NewServer(db, bot)
+
+	bot.cmd(t, 1, "/add", "milk, bread")
+	bot.cmd(t, 1, "/list", "")
+	bot.cmd(t, 2, "/add", "tea, sugar")
+	bot.cmd(t, 2, "/list", "")
+	// the poll of a deleted message can't be stopped, but it's forgotten anyway
+	bot.cmd(t, 3, "/add", "salt, pepper")
+	bot.cmd(t, 3, "/list", "")
+	delete(bot.polls, bot.opened[3][0])
+
+	// items of the first chat have gone while the bot was down
+	_ = db.Remove(1, defaultList, "milk")
+	_ = db.Remove(1, defaultList, "bread")
+	_ = db.Remove(3, defaultList, "salt")
+	_ = db.Remove(3, defaultList, "pepper")
+
+	if err := srv.reconcilePolls(); err != nil {
+		t.Fatalf("reconcilePolls() error = %v", err)
+	}
+	if !reflect.DeepEqual(bot.stopped[1], bot.opened[1]) {
+		t.Errorf("stopped polls of chat 1 = %v, want %v", bot.stopped[1], bot.opened[1])
+	}
+	if len(bot.stopped[2]) != 0 {
+		t.Errorf("stopped polls of chat 2 = %v, want none", bot.stopped[2])
+	}
+	polls, _ := db.AllOpenPolls()
+	if len(polls) != 1 || polls[0].ChatID != 2 {
+		t.Errorf("open polls after reconcile = %v, want the one of chat 2", polls)
+	}
+}
+
+func TestSrv_StopPollsFailures(t *testing.T) {
+	bot := newFakeBot()
+	db := &failingPurchases{Inmem: &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}}
+	srv := NewServer(db, bot)
+	purchases := func() []string {
+		history, _ := db.History(1, time.Time{})
+		var names []string
+		for _, p := range history {
+			names = append(names, p.Item.Name)
+		}
+		return names
+	}
+
+	bot.cmd(t, 1, "/add", "milk, bread, eggs")
+	bot.cmd(t, 1, "/list", "")
+	// the same items are ticked in both polls
+	bot.cmd(t, 1, "/list", "")
+	bot.vote(1, "milk")
+	bot.vote(1, "bread")
+	for _, msgID := range bot.opened[1] {
+		bot.answer(t, msgID, testUser, 0, 1)
+	}
+
+	// the poll is kept while tg fails to stop it
+	bot.stopErr = tele.NewError(429, "Too Many Requests: retry after 5")
+	bot.cmd(t, 1, "/done", "")
+	// the list is sent again with one more poll
+	if polls, _ := db.OpenPolls(1, defaultList); len(polls) != 3 {
+		t.Errorf("open polls after failed stop = %d, want 3", len(polls))
+	}
+	if got := purchases(); len(got) != 0 {
+		t.Errorf("purchases after failed stop = %v, want none", got)
+	}
+	bot.stopErr = nil
+
+	// the purchase of the second item fails, the first one isn't bought again by the next /done
+	db.fail = "milk"
+	if _, err := srv.stopPolls(1, defaultList, testUser, time.Now()); err == nil {
+		t.Fatalf("stopPolls() hasn't failed")
+	}
+	db.fail = ""
+	bot.cmd(t, 1, "/done", "")
+	if got, want := purchases(), []string{"bread", "milk"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("purchases = %v, want %v", got, want)
+	}
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, plainItems("eggs")) {
+		t.Errorf("items after /done = %v, want [eggs]", got)
+	}
+	if polls, _ := db.OpenPolls(1, defaultList); len(polls) != 0 {
+		t.Errorf("open polls after /done = %d, want none", len(polls))
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	addRecipeUnique = "addrecipe"
+	// maxCallbackData is the limit of button data in bytes
+	maxCallbackData = 64
+	// maxRecipeName is the limit of recipe names in bytes, the name is the data of its button
+	// and telebot puts the unique of the button before it as "\f<unique>|<name>"
+	maxRecipeName = maxCallbackData - len("\f"+addRecipeUnique+"|")
+)
+
+// endpoint of recipe add buttons
+var addRecipeBtn = tele.Btn{Unique: addRecipeUnique}
+
+// Recipe is a named set of items, which could be added to the list at once
+type Recipe struct {
+	Name string
+	// Servings is how many servings the items are for, zero if it wasn't specified
+	Servings int
+	Items    []Item
+}
+
+// String formats recipe as it's shown in /recipes
+func (r Recipe) String() string {
+	names := make([]string, 0, len(r.Items))
+	for _, item := range r.Items {
+		names = append(names, item.String())
+	}
+	title := r.Name
+	if r.Servings > 0 {
+		title += fmt.Sprintf(" (%d servings)", r.Servings)
+	}
+	return title + ": " + strings.Join(names, ", ")
+}
+
+// scaled returns items of the recipe for the servings, zero servings are the ones of the recipe.
+// Countable items are rounded up, items without quantity are not scaled. Recipes without servings are for one serving
+func (r Recipe) scaled(servings int) []Item {
+	base := r.Servings
+	if base == 0 {
+		base = 1
+	}
+	if servings <= 0 {
+		servings = base
+	}
+	items := make([]Item, len(r.Items))
+	for i, item := range r.Items {
+		if item.Quantity != 0 && servings != base {
+			item.Quantity = item.Quantity * float64(servings) / float64(base)
+			if item.Unit == "" || item.Unit == "pcs" || item.Unit == "pack" {
+				item.Quantity = math.Ceil(item.Quantity - 1e-9)
+			}
+		}
+		items[i] = item
+	}
+	return items
+}
+
+// recipe manages recipes of the chat:
+// "/recipe save pancakes 4" with items on the following lines, "/recipe add pancakes 8",
+// "/recipe show pancakes", "/recipe delete pancakes" and "/recipe export"
+func (s *Srv) recipe(c tele.Context) error {
+	chatID := c.Chat().ID
+	list, payload, err := s.targetList(c)
+	if err != nil {
+		return s.reply(c, err)
+	}
+	usage := fmt.Sprintf("Usage: %s save|add|show|delete <name>, or %s export", recipeCmd, recipeCmd)
+
+	head, body, _ := strings.Cut(payload, "\n")
+	words := strings.Fields(head)
+	if len(words) == 0 {
+		return c.Send(usage)
+	}
+	sub := strings.ToLower(words[0])
+	if sub == "export" {
+		return s.exportRecipes(c)
+	}
+	name, servings := parseRecipeName(words[1:])
+	if name == "" {
+		return c.Send(usage)
+	}
+
+	switch sub {
+	case "save":
+		return s.saveRecipe(c, Recipe{Name: name, Servings: servings, Items: parseItems(body)})
+	case "add":
+		r, err := s.findRecipe(chatID, name)
+		if err != nil {
+			return s.reply(c, err)
+		}
+		reply, err := s.addToList(chatID, list, r.scaled(servings))
+		if err != nil {
+			return err
+		}
+		return c.Send(reply)
+	case "show":
+		r, err := s.findRecipe(chatID, name)
+		if err != nil {
+			return s.reply(c, err)
+		}
+		return c.Send(r.String())
+	case "delete":
+		ok, err := s.db.DeleteRecipe(chatID, name)
+		if err != nil {
+			return fmt.Errorf("can't delete recipe: %w", err)
+		}
+		if !ok {
+			return c.Send(fmt.Sprintf("There is no recipe %q, see %s", name, recipesCmd))
+		}
+		return c.Send(fmt.Sprintf("Deleted recipe %s", name))
+	}
+	return c.Send(usage)
+}
+
+// parseRecipeName returns the name of the recipe and servings, if the last word is a number
+func parseRecipeName(words []string) (name string, servings int) {
+	if len(words) > 1 {
+		if n, err := strconv.Atoi(words[len(words)-1]); err == nil && n > 0 {
+			servings, words = n, words[:len(words)-1]
+		}
+	}
+	return strings.Join(words, " "), servings
+}
+
+// saveRecipe saves the recipe, or replaces the one with the same name
+func (s *Srv) saveRecipe(c tele.Context, r Recipe) error {
+	if len(r.Items) == 0 {
+		return c.Send(fmt.Sprintf("Usage: %s save <name> [servings], and items on the following lines", recipeCmd))
+	}
+	if len(r.Name) > maxRecipeName {
+		return c.Send(fmt.Sprintf("Recipe name is too long, it could be up to %d latin letters, fewer of other alphabets", maxRecipeName))
+	}
+
+	chatID := c.Chat().ID
+	_, exists, err := s.db.Recipe(chatID, r.Name)
+	if err != nil {
+		return fmt.Errorf("can't get recipe: %w", err)
+	}
+	if err := s.db.SaveRecipe(chatID, r); err != nil {
+		return fmt.Errorf("can't save recipe: %w", err)
+	}
+
+	verb := "Saved"
+	if exists {
+		verb = "Updated"
+	}
+	return c.Send(fmt.Sprintf("%s recipe %s", verb, r))
+}
+
+// findRecipe returns recipe of the chat, or userError if there is no such one
+func (s *Srv) findRecipe(chatID int64, name string) (Recipe, error) {
+	r, ok, err := s.db.Recipe(chatID, name)
+	if err != nil {
+		return r, fmt.Errorf("can't get recipe: %w", err)
+	}
+	if !ok {
+		return r, userError(fmt.Sprintf("There is no recipe %q, see %s", name, recipesCmd))
+	}
+	return r, nil
+}
+
+// showRecipes sends all recipes of the chat with buttons to add them to the active list
+func (s *Srv) showRecipes(c tele.Context) error {
+	recipes, err := s.db.Recipes(c.Chat().ID)
+	if err != nil {
+		return fmt.Errorf("can't get recipes: %w", err)
+	}
+	if len(recipes) == 0 {
+		return c.Send(fmt.Sprintf("There are no recipes, save one by %s save <name> with items on the following lines", recipeCmd))
+	}
+
+	rm := &tele.ReplyMarkup{}
+	lines := []string{"Recipes:"}
+	var rows []tele.Row
+	for _, r := range recipes {
+		lines = append(lines, r.String())
+		// names saved before they were limited in bytes can't be button data, tg would reject the whole message
+		if len(r.Name) <= maxRecipeName {
+			rows = append(rows, rm.Row(rm.Data("Add "+r.Name, addRecipeBtn.Unique, r.Name)))
+		}
+	}
+	rm.Inline(rows...)
+	return c.Send(strings.Join(lines, "\n"), rm)
+}
+
+// addRecipe adds items of the recipe to the active list by tap on its button
+func (s *Srv) addRecipe(c tele.Context) error {
+	chatID := c.Chat().ID
+	r, err := s.findRecipe(chatID, c.Data())
+	if err != nil {
+		_ = c.Respond()
+		return s.reply(c, err)
+	}
+	list, err := s.activeList(chatID)
+	if err != nil {
+		return err
+	}
+	reply, err := s.addToList(chatID, list, r.scaled(0))
+	if err != nil {
+		return err
+	}
+	_ = c.Respond()
+	return c.Send(reply)
+}
+
+// exportRecipes sends all recipes of the chat as a text file. Every recipe in it is a /recipe save command,
+// so recipes could be moved into another chat by sending them one by one
+func (s *Srv) exportRecipes(c tele.Context) error {
+	recipes, err := s.db.Recipes(c.Chat().ID)
+	if err != nil {
+		return fmt.Errorf("can't get recipes: %w", err)
+	}
+	if len(recipes) == 0 {
+		return c.Send("There are no recipes to export")
+	}
+
+	var b bytes.Buffer
+	for i, r := range recipes {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%s save %s", recipeCmd, r.Name)
+		if r.Servings > 0 {
+			fmt.Fprintf(&b, " %d", r.Servings)
+		}
+		b.WriteString("\n")
+		for _, item := range r.Items {
+			b.WriteString(item.String() + "\n")
+		}
+	}
+	return c.Send(&tele.Document{File: tele.FromReader(&b), FileName: "recipes.txt", MIME: "text/plain"})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestRecipe_Scaled(t *testing.T) {
+	pancakes := Recipe{Name: "pancakes", Servings: 4, Items: []Item{
+		{Name: "eggs", Quantity: 3},
+		{Name: "milk", Quantity: 0.5, Unit: "l"},
+		{Name: "butter", Quantity: 1, Unit: "pack"},
+		{Name: "salt"},
+	}}
+	tests := []struct {
+		name     string
+		r        Recipe
+		servings int
+		want     []Item
+	}{
+		{"same servings", pancakes, 4, pancakes.Items},
+		{"servings of the recipe", pancakes, 0, pancakes.Items},
+		{"double", pancakes, 8, []Item{{Name: "eggs", Quantity: 6}, {Name: "milk", Quantity: 1, Unit: "l"}, {Name: "butter", Quantity: 2, Unit: "pack"}, {Name: "salt"}}},
+		{"countable rounded up", pancakes, 2, []Item{{Name: "eggs", Quantity: 2}, {Name: "milk", Quantity: 0.25, Unit: "l"}, {Name: "butter", Quantity: 1, Unit: "pack"}, {Name: "salt"}}},
+		{"no servings is one serving", Recipe{Items: []Item{{Name: "tea", Quantity: 2, Unit: "g"}}}, 3, []Item{{Name: "tea", Quantity: 6, Unit: "g"}}},
+		{"no servings at all", Recipe{Items: []Item{{Name: "tea", Quantity: 2, Unit: "g"}}}, 0, []Item{{Name: "tea", Quantity: 2, Unit: "g"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.scaled(tt.servings); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scaled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSrv_Recipes(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	NewServer(db, bot)
+
+	lastReply := func() interface{} { return bot.sent[1][len(bot.sent[1])-1] }
+	items := func(list string) []Item {
+		got, _ := db.List(1, list)
+		return got
+	}
+
+	bot.cmd(t, 1, "/recipe", "save pancakes")
+	if got := lastReply(); got != "Usage: /recipe save <name> [servings], and items on the following lines" {
+		t.Errorf("/recipe save without items = %q", got)
+	}
+	bot.cmd(t, 1, "/recipe", "save Pancakes 2\n2 eggs\nmilk 0.3l")
+	bot.cmd(t, 1, "/recipe", "save pancakes 4\n3 eggs, milk 0.5l\nflour 200g\nsalt")
+	if got := lastReply(); got != "Updated recipe pancakes (4 servings): eggs ×3, milk 0.5 l, flour 200 g, salt" {
+		t.Errorf("/recipe save of existing recipe = %q", got)
+	}
+	bot.cmd(t, 1, "/recipe", "save borscht\nbeetroot 3x\ncabbage")
+
+	bot.cmd(t, 1, "/add", "eggs 2")
+	bot.cmd(t, 1, "/recipe", "add Pancakes 8")
+	if got := lastReply(); got != "Added 3 item(s): milk, flour, salt\nAlready on the list: eggs" {
+		t.Errorf("/recipe add reply = %q", got)
+	}
+	want := []Item{{Name: "eggs", Quantity: 8}, {Name: "milk", Quantity: 1, Unit: "l"}, {Name: "flour", Quantity: 400, Unit: "g"}, {Name: "salt"}}
+	if got := items(defaultList); !reflect.DeepEqual(got, want) {
+		t.Errorf("items after /recipe add = %v, want %v", got, want)
+	}
+	bot.cmd(t, 1, "/recipe", "add lasagna")
+	if got := lastReply(); got != `There is no recipe "lasagna", see /recipes` {
+		t.Errorf("/recipe add of unknown recipe = %q", got)
+	}
+
+	bot.cmd(t, 1, "/recipes", "")
+	if got := lastReply(); got != "Recipes:\nborscht: beetroot ×3, cabbage\npancakes (4 servings): eggs ×3, milk 0.5 l, flour 200 g, salt" {
+		t.Errorf("/recipes = %q", got)
+	}
+	if got := bot.buttons(bot.lastID); !reflect.DeepEqual(got, []string{"Add borscht", "Add pancakes"}) {
+		t.Errorf("/recipes buttons = %v", got)
+	}
+	bot.cmd(t, 1, "/newlist", "dinner")
+	bot.cmd(t, 1, "/use", "dinner")
+	bot.tap(t, 1, bot.lastID, addRecipeBtn, "borscht")
+	if got := items("dinner"); !reflect.DeepEqual(got, []Item{{Name: "beetroot", Quantity: 3}, {Name: "cabbage"}}) {
+		t.Errorf("items after tap on recipe = %v", got)
+	}
+
+	bot.cmd(t, 1, "/recipe", "export")
+	doc, ok := lastReply().(*tele.Document)
+	if !ok {
+		t.Fatalf("export is not a document: %v", lastReply())
+	}
+	data, _ := io.ReadAll(doc.FileReader)
+	wantExport := "/recipe save borscht\nbeetroot ×3\ncabbage\n\n/recipe save pancakes 4\neggs ×3\nmilk 0.5 l\nflour 200 g\nsalt\n"
+	if string(data) != wantExport {
+		t.Errorf("exported recipes = %q, want %q", data, wantExport)
+	}
+
+	bot.cmd(t, 1, "/recipe", "delete borscht")
+	bot.cmd(t, 1, "/recipe", "show borscht")
+	if got := lastReply(); got != `There is no recipe "borscht", see /recipes` {
+		t.Errorf("/recipe show of deleted recipe = %q", got)
+	}
+	if recipes, _ := db.Recipes(2); len(recipes) != 0 {
+		t.Errorf("recipes of another chat = %v", recipes)
+	}
+}
+
+func TestSrv_RecipeNameLimit(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	NewServer(db, bot)
+	lastReply := func() interface{} { return bot.sent[1][len(bot.sent[1])-1] }
+
+	tests := []struct {
+		name  string
+		saved bool
+	}{
+		{strings.Repeat("a", maxRecipeName), true},
+		{strings.Repeat("a", maxRecipeName+1), false},
+		// 3 bytes a letter, so 18 letters don't fit
+		{strings.Repeat("寿", 17), true},
+		{strings.Repeat("寿", 18), false},
+		{strings.Repeat("🥞", 14), false},
+	}
+	for _, tt := range tests {
+		bot.cmd(t, 1, "/recipe", "save "+tt.name+"\neggs")
+		if _, ok, _ := db.Recipe(1, tt.name); ok != tt.saved {
+			t.Errorf("recipe of %d bytes is saved: %v, want %v, reply %q", len(tt.name), ok, tt.saved, lastReply())
+		}
+	}
+
+	// the name saved before the limit is shown without the button, which tg would reject
+	if err := db.SaveRecipe(1, Recipe{Name: strings.Repeat("寿", 24), Items: plainItems("rice")}); err != nil {
+		t.Fatalf("SaveRecipe() error = %v", err)
+	}
+	bot.cmd(t, 1, "/recipes", "")
+	for _, row := range bot.markups[bot.lastID].InlineKeyboard {
+		for _, btn := range row {
+			if n := len("\f" + btn.Unique + "|" + btn.Data); n > maxCallbackData {
+				t.Errorf("button data %q is %d bytes", btn.Data, n)
+			}
+		}
+	}
+	if got := len(bot.buttons(bot.lastID)); got != 2 {
+		t.Errorf("/recipes has %d buttons, want 2", got)
+	}
+}
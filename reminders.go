@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// reminderGrace is how late a reminder could go off, e.g. after downtime.
+// Reminders missed for longer are skipped till the next time
+const reminderGrace = time.Hour
+
+// endpoint of reminder cancel buttons
+var unremindBtn = tele.Btn{Unique: "unremind"}
+
+var clockRx = regexp.MustCompile(`^(\d{1,2})[:.](\d{2})$`)
+
+// Reminder posts the list into the chat at the time of day, every day or on the weekday
+type Reminder struct {
+	ID     int64
+	ChatID int64
+	List   string
+	// Weekday is the day of the reminder, unless it's Daily
+	Weekday time.Weekday
+	Daily   bool
+	// Hour and Minute are the time of day in the time zone of the chat
+	Hour, Minute int
+	// Next is when the reminder goes off next time
+	Next time.Time
+}
+
+// next returns the first time of the reminder after the time
+func (r Reminder) next(loc *time.Location, after time.Time) time.Time {
+	t := after.In(loc)
+	var next time.Time
+	for d := 0; d <= 7; d++ {
+		next = time.Date(t.Year(), t.Month(), t.Day()+d, r.Hour, r.Minute, 0, 0, loc)
+		if next.After(after) && (r.Daily || next.Weekday() == r.Weekday) {
+			break
+		}
+	}
+	return next
+}
+
+// schedule describes when the reminder goes off, like "every Saturday at 10:00"
+func (r Reminder) schedule() string {
+	when := "day"
+	if !r.Daily {
+		when = r.Weekday.String()
+	}
+	return fmt.Sprintf("every %s at %02d:%02d", when, r.Hour, r.Minute)
+}
+
+// addReminder schedules posts of the list: "/remind 18:30" every day or "/remind sat 10:00" every week
+func (s *Srv) addReminder(c tele.Context) error {
+	chatID := c.Chat().ID
+	list, payload, err := s.targetList(c)
+	if err != nil {
+		return s.reply(c, err)
+	}
+	r, err := parseReminder(payload)
+	if err != nil {
+		return s.reply(c, err)
+	}
+	r.ChatID, r.List = chatID, list
+
+	settings, err := s.db.Settings(chatID)
+	if err != nil {
+		return fmt.Errorf("can't get settings: %w", err)
+	}
+	loc := s.location(settings)
+	r.Next = r.next(loc, s.now())
+
+	reminders, err := s.db.Reminders(chatID)
+	if err != nil {
+		return fmt.Errorf("can't get reminders: %w", err)
+	}
+	for _, existing := range reminders {
+		if existing.List == list && existing.schedule() == r.schedule() {
+			r.ID = existing.ID
+		}
+	}
+	if _, err := s.db.SaveReminder(r); err != nil {
+		return fmt.Errorf("can't save reminder: %w", err)
+	}
+
+	return c.Send(fmt.Sprintf("I'll post %s %s (%s), next time on %s",
+		listRef(list), r.schedule(), loc, r.Next.In(loc).Format(dayFormat)))
+}
+
+// parseReminder parses schedule of the reminder: "18:30", "sat 10:00" or "every Saturday 10:00"
+func parseReminder(text string) (Reminder, error) {
+	usage := userError(fmt.Sprintf("Usage: %s [weekday] HH:MM, like %s sat 10:00", remindCmd, remindCmd))
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) > 0 && words[0] == "every" {
+		words = words[1:]
+	}
+	if len(words) == 0 || len(words) > 2 {
+		return Reminder{}, usage
+	}
+
+	r := Reminder{Daily: true}
+	if len(words) == 2 {
+		day, ok := parseWeekday(words[0])
+		if !ok && words[0] != "day" {
+			return Reminder{}, userError(fmt.Sprintf("Can't understand %q, use weekdays like sat or Saturday", words[0]))
+		}
+		r.Weekday, r.Daily = day, !ok
+		words = words[1:]
+	}
+
+	m := clockRx.FindStringSubmatch(words[0])
+	if m == nil {
+		return Reminder{}, usage
+	}
+	r.Hour, _ = strconv.Atoi(m[1])
+	r.Minute, _ = strconv.Atoi(m[2])
+	if r.Hour > 23 || r.Minute > 59 {
+		return Reminder{}, userError(fmt.Sprintf("There is no %s time", words[0]))
+	}
+	return r, nil
+}
+
+// parseWeekday parses english weekday, full or three letters
+func parseWeekday(s string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// showReminders sends all reminders of the chat with buttons to cancel them
+func (s *Srv) showReminders(c tele.Context) error {
+	text, rm, err := s.renderReminders(c.Chat().ID)
+	if err != nil {
+		return err
+	}
+	return c.Send(text, rm)
+}
+
+// removeReminder cancels the reminder by tap on its button and redraws the rest of reminders
+func (s *Srv) removeReminder(c tele.Context) error {
+	chatID := c.Chat().ID
+	id, err := strconv.ParseInt(c.Data(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("can't parse reminder id: %w", err)
+	}
+	if _, err := s.db.DeleteReminder(chatID, id); err != nil {
+		return fmt.Errorf("can't delete reminder: %w", err)
+	}
+
+	text, rm, err := s.renderReminders(chatID)
+	if err != nil {
+		return err
+	}
+	_ = c.Respond()
+	return c.Edit(text, rm)
+}
+
+// renderReminders describes reminders of the chat, one cancel button per reminder
+func (s *Srv) renderReminders(chatID int64) (string, *tele.ReplyMarkup, error) {
+	reminders, err := s.db.Reminders(chatID)
+	if err != nil {
+		return "", nil, fmt.Errorf("can't get reminders: %w", err)
+	}
+	rm := &tele.ReplyMarkup{}
+	if len(reminders) == 0 {
+		return fmt.Sprintf("There are no reminders, add one by %s sat 10:00", remindCmd), rm, nil
+	}
+	settings, err := s.db.Settings(chatID)
+	if err != nil {
+		return "", nil, fmt.Errorf("can't get settings: %w", err)
+	}
+	loc := s.location(settings)
+
+	lines := []string{fmt.Sprintf("Reminders (%s):", loc)}
+	var rows []tele.Row
+	for _, r := range reminders {
+		line := fmt.Sprintf("%s %s, next time on %s", listTitle(r.List), r.schedule(), r.Next.In(loc).Format(dayFormat))
+		lines = append(lines, line)
+		rows = append(rows, rm.Row(rm.Data("✕ "+r.schedule(), unremindBtn.Unique, strconv.FormatInt(r.ID, 10))))
+	}
+	rm.Inline(rows...)
+	return strings.Join(lines, "\n"), rm, nil
+}
+
+// sendDueReminders posts lists of reminders due at the time and schedules them for the next time.
+// Reminders late for more than reminderGrace, e.g. because the bot was down, are skipped
+func (s *Srv) sendDueReminders(at time.Time) error {
+	reminders, err := s.db.DueReminders(at)
+	if err != nil {
+		return fmt.Errorf("can't get reminders: %w", err)
+	}
+
+	for _, r := range reminders {
+		settings, err := s.db.Settings(r.ChatID)
+		if err != nil {
+			return fmt.Errorf("can't get settings: %w", err)
+		}
+		if late := at.Sub(r.Next); late <= reminderGrace {
+			if err := s.sendList(&tele.Chat{ID: r.ChatID}, r.List); err != nil {
+				s.logger.Warn("can't send reminder", "chat", r.ChatID, "reminder", r.ID, "err", err)
+			}
+		} else {
+			s.logger.Info("reminder is skipped, it's too late", "chat", r.ChatID, "reminder", r.ID, "late", late)
+		}
+
+		r.Next = r.next(s.location(settings), at)
+		if _, err := s.db.SaveReminder(r); err != nil {
+			return fmt.Errorf("can't save reminder: %w", err)
+		}
+	}
+	return nil
+}
+
+// setTimeZone sets time zone of the chat: "/timezone Europe/Berlin". Reminders are rescheduled in the new zone
+func (s *Srv) setTimeZone(c tele.Context) error {
+	chatID := c.Chat().ID
+	settings, err := s.db.Settings(chatID)
+	if err != nil {
+		return fmt.Errorf("can't get settings: %w", err)
+	}
+
+	name := strings.TrimSpace(cmdPayload(c.Message().Text))
+	if name == "" {
+		return c.Send(fmt.Sprintf("Time zone: %s, change it by %s Europe/Berlin", s.location(settings), timeZoneCmd))
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return c.Send(fmt.Sprintf("Unknown time zone %q, use names like Europe/Berlin", name))
+	}
+
+	settings.TimeZone = loc.String()
+	if err := s.db.SetSettings(chatID, settings); err != nil {
+		return fmt.Errorf("can't save settings: %w", err)
+	}
+	reminders, err := s.db.Reminders(chatID)
+	if err != nil {
+		return fmt.Errorf("can't get reminders: %w", err)
+	}
+	for _, r := range reminders {
+		r.Next = r.next(loc, s.now())
+		if _, err := s.db.SaveReminder(r); err != nil {
+			return fmt.Errorf("can't save reminder: %w", err)
+		}
+	}
+
+	return c.Send(fmt.Sprintf("Time zone: %s", loc))
+}
+
+// location returns time zone of the chat
+func (s *Srv) location(settings ChatSettings) *time.Location {
+	if settings.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(settings.TimeZone)
+	if err != nil {
+		s.logger.Warn("can't load time zone", "zone", settings.TimeZone, "err", err)
+		return time.UTC
+	}
+	return loc
+}
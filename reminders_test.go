@@ -0,0 +1,151 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestParseReminder(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    Reminder
+		wantErr bool
+	}{
+		{"18:30", Reminder{Daily: true, Hour: 18, Minute: 30}, false},
+		{"9.05", Reminder{Daily: true, Hour: 9, Minute: 5}, false},
+		{"sat 10:00", Reminder{Weekday: time.Saturday, Hour: 10}, false},
+		{"every Sunday 08:15", Reminder{Weekday: time.Sunday, Hour: 8, Minute: 15}, false},
+		{"every day 07:00", Reminder{Daily: true, Hour: 7}, false},
+		{"24:00", Reminder{}, true},
+		{"someday 10:00", Reminder{}, true},
+		{"sat", Reminder{}, true},
+		{"", Reminder{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := parseReminder(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReminder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseReminder() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReminder_Next(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("can't load location: %v", err)
+	}
+	// Saturday, the day before DST ends in Berlin
+	after := time.Date(2022, 10, 29, 12, 0, 0, 0, berlin)
+	tests := []struct {
+		name string
+		r    Reminder
+		want time.Time
+	}{
+		{"later today", Reminder{Daily: true, Hour: 18, Minute: 30}, time.Date(2022, 10, 29, 18, 30, 0, 0, berlin)},
+		{"tomorrow after DST ends", Reminder{Daily: true, Hour: 9}, time.Date(2022, 10, 30, 9, 0, 0, 0, berlin)},
+		{"right now is already past", Reminder{Daily: true, Hour: 12}, time.Date(2022, 10, 30, 12, 0, 0, 0, berlin)},
+		{"next weekday", Reminder{Weekday: time.Monday, Hour: 10}, time.Date(2022, 10, 31, 10, 0, 0, 0, berlin)},
+		{"same weekday passed", Reminder{Weekday: time.Saturday, Hour: 10}, time.Date(2022, 11, 5, 10, 0, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.next(berlin, after); !got.Equal(tt.want) {
+				t.Errorf("next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSrv_Reminders(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	srv := NewServer(db, bot)
+	// the fake clock, Monday 17:00 in Berlin
+	now := time.Date(2022, 10, 17, 15, 0, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+
+	lastReply := func() interface{} { return bot.sent[1][len(bot.sent[1])-1] }
+
+	bot.cmd(t, 1, "/timezone", "Mars/Olympus")
+	if got := lastReply(); got != `Unknown time zone "Mars/Olympus", use names like Europe/Berlin` {
+		t.Errorf("/timezone with unknown zone = %q", got)
+	}
+	bot.cmd(t, 1, "/timezone", "Europe/Berlin")
+	bot.cmd(t, 1, "/timezone", "")
+	if got := lastReply(); got != "Time zone: Europe/Berlin, change it by /timezone Europe/Berlin" {
+		t.Errorf("/timezone = %q", got)
+	}
+
+	bot.cmd(t, 1, "/remind", "18:30")
+	if got := lastReply(); got != "I'll post the list every day at 18:30 (Europe/Berlin), next time on Mon, Oct 17" {
+		t.Errorf("/remind reply = %q", got)
+	}
+	bot.cmd(t, 1, "/newlist", "hardware")
+	bot.cmd(t, 1, "/remind", "@hardware sat 10:00")
+	bot.cmd(t, 1, "/remind", "18:30")
+	bot.cmd(t, 1, "/remind", "25:00")
+	if got := lastReply(); got != "There is no 25:00 time" {
+		t.Errorf("/remind with wrong time = %q", got)
+	}
+
+	bot.cmd(t, 1, "/reminders", "")
+	want := "Reminders (Europe/Berlin):\nShopping list every day at 18:30, next time on Mon, Oct 17\nShopping list @hardware every Saturday at 10:00, next time on Sat, Oct 22"
+	if got := lastReply(); got != want {
+		t.Errorf("/reminders = %q, want %q", got, want)
+	}
+	remindersMsg := bot.lastID
+
+	// the list is posted the same way as by /list
+	bot.cmd(t, 1, "/add", "milk, bread")
+	now = time.Date(2022, 10, 17, 16, 30, 0, 0, time.UTC)
+	srv.runDueJobs(now)
+	if _, ok := bot.sent[1][len(bot.sent[1])-2].(*tele.Poll); !ok || lastReply() != "/done" {
+		t.Errorf("reminder hasn't posted the list, the last message is %v", lastReply())
+	}
+	sent := len(bot.sent[1])
+	srv.runDueJobs(now.Add(time.Minute))
+	if len(bot.sent[1]) != sent {
+		t.Errorf("reminder has gone off twice: %v", bot.sent[1][sent:])
+	}
+
+	// the bot was down on the next day, the late reminder is skipped, but the one within grace time is not
+	now = time.Date(2022, 10, 18, 18, 0, 0, 0, time.UTC)
+	srv.runDueJobs(now)
+	if len(bot.sent[1]) != sent {
+		t.Errorf("late reminder has gone off: %v", bot.sent[1][sent:])
+	}
+	reminders, _ := db.Reminders(1)
+	if next := reminders[0].Next; !next.Equal(time.Date(2022, 10, 19, 16, 30, 0, 0, time.UTC)) {
+		t.Errorf("next time of late reminder = %v", next)
+	}
+	now = time.Date(2022, 10, 22, 8, 59, 0, 0, time.UTC)
+	srv.runDueJobs(now)
+	if got := lastReply(); got != "List is empty" {
+		t.Errorf("reminder of hardware list hasn't gone off, the last message is %v", got)
+	}
+
+	// reminders follow the time zone of the chat
+	bot.cmd(t, 1, "/timezone", "Asia/Tokyo")
+	reminders, _ = db.Reminders(1)
+	if next := reminders[1].Next; !next.Equal(time.Date(2022, 10, 29, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("next time of reminder in another zone = %v", next)
+	}
+
+	bot.tap(t, 1, remindersMsg, unremindBtn, "1")
+	if got := bot.edited[remindersMsg]; got != "Reminders (Asia/Tokyo):\nShopping list @hardware every Saturday at 10:00, next time on Sat, Oct 29" {
+		t.Errorf("reminders after cancel = %q", got)
+	}
+	bot.tap(t, 2, remindersMsg, unremindBtn, "2")
+	if reminders, _ := db.Reminders(1); len(reminders) != 1 {
+		t.Errorf("reminder has been canceled from another chat: %v", reminders)
+	}
+}
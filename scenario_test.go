@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// startBot runs the server on real telebot with long polling of the fake Bot API, it's shut down by the end of the test
+func startBot(t *testing.T, api *fakeAPI, db ItemStorager) *Srv {
+	t.Helper()
+	srv := newBotServer(t, api, db)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- srv.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	})
+	return srv
+}
+
+// newBotServer makes the server on real telebot with long polling of the fake Bot API
+func newBotServer(t *testing.T, api *fakeAPI, db ItemStorager) *Srv {
+	t.Helper()
+	b, err := makeBot(tele.Settings{URL: api.URL, Token: "token"}, Config{Poller: "long", PollTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("makeBot() error = %v", err)
+	}
+	srv := NewServer(db, b)
+	b.OnError = srv.onError
+	return srv
+}
+
+// pollOption returns the index of the poll option, or fails the test
+func pollOption(t *testing.T, msg tele.Message, text string) int {
+	t.Helper()
+	for i, o := range msg.Poll.Options {
+		if o.Text == text {
+			return i
+		}
+	}
+	t.Fatalf("there is no %q in poll %q", text, msg.Poll.Question)
+	return -1
+}
+
+// sentPolls returns messages with polls
+func sentPolls(msgs []tele.Message) []tele.Message {
+	var res []tele.Message
+	for _, msg := range msgs {
+		if msg.Poll != nil {
+			res = append(res, msg)
+		}
+	}
+	return res
+}
+
+func TestScenario_Chats(t *testing.T) {
+	api := newFakeAPI(t)
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}, path: filepath.Join(t.TempDir(), "dump.gob")}
+	startBot(t, api, db)
+
+	home, group := int64(1), int64(-100)
+	alice, bob := &tele.User{ID: 42, FirstName: "Alice"}, &tele.User{ID: 43, FirstName: "Bob"}
+
+	// every command is sent once the reply to the previous one in the chat has come,
+	// updates are handled concurrently
+	do := func(chatID int64, u *tele.User, text, reply string) {
+		t.Helper()
+		api.send(chatID, u, text)
+		waitFor(t, "the reply to "+text, func() bool { return api.lastMessage(chatID).Text == reply })
+	}
+
+	do(home, alice, "/add milk, bread, eggs", "Added 3 item(s): milk, bread, eggs")
+	do(group, bob, "/add tea, 2 kg potatoes", "Added 2 item(s): tea, potatoes")
+	do(group, alice, "/add Tea", "Already on the list: tea")
+	do(group, bob, "/live on", "Items will be bought as soon as they are ticked in the list polls")
+	do(home, alice, "/list", doneCmd)
+	do(group, bob, "/list", doneCmd)
+
+	homePolls, groupPolls := sentPolls(api.messages(home)), sentPolls(api.messages(group))
+	if len(homePolls) != 1 || len(homePolls[0].Poll.Options) != 3 {
+		t.Fatalf("polls of home chat = %+v", homePolls)
+	}
+	if len(groupPolls) != 1 || len(groupPolls[0].Poll.Options) != 2 {
+		t.Fatalf("polls of group = %+v", groupPolls)
+	}
+
+	// votes in live mode buy items right away, other chats wait for /done
+	homePoll, groupPoll := homePolls[0], groupPolls[0]
+	api.vote(t, home, homePoll.ID, alice, pollOption(t, homePoll, "milk"), pollOption(t, homePoll, "eggs"))
+	api.vote(t, group, groupPoll.ID, bob, pollOption(t, groupPoll, "tea"))
+	waitFor(t, "tea to be bought", func() bool {
+		items, _ := db.List(group, defaultList)
+		return len(items) == 1
+	})
+	if items, _ := db.List(home, defaultList); len(items) != 3 {
+		t.Errorf("items of home chat before /done = %v", items)
+	}
+
+	do(home, alice, "/done", "The only one item in the list: bread")
+	do(group, bob, "/done", "The only one item in the list: potatoes 2 kg")
+	for _, chatID := range []int64{home, group} {
+		if p := sentPolls(api.messages(chatID))[0]; !p.Poll.Closed {
+			t.Errorf("poll of chat %d hasn't been stopped", chatID)
+		}
+		if polls, _ := db.OpenPolls(chatID, defaultList); len(polls) != 0 {
+			t.Errorf("open polls of chat %d after /done = %+v", chatID, polls)
+		}
+	}
+
+	if got, _ := db.List(home, defaultList); !reflect.DeepEqual(got, plainItems("bread")) {
+		t.Errorf("items of home chat = %v", got)
+	}
+	if got, _ := db.List(group, defaultList); len(got) != 1 || got[0].Name != "potatoes" {
+		t.Errorf("items of group = %v", got)
+	}
+	if got := api.count("stopPoll"); got != 2 {
+		t.Errorf("stopPoll has been called %d times, want 2", got)
+	}
+}
+
+func TestScenario_APIErrors(t *testing.T) {
+	api := newFakeAPI(t)
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}, path: filepath.Join(t.TempDir(), "dump.gob")}
+	startBot(t, api, db)
+	alice := &tele.User{ID: 42, FirstName: "Alice"}
+
+	api.send(1, alice, "/add milk, bread")
+	waitFor(t, "items to be added", func() bool {
+		items, _ := db.List(1, defaultList)
+		return len(items) == 2
+	})
+
+	// the failed poll isn't kept, there is nothing to stop by /done
+	api.fail("sendPoll", 429, "Too Many Requests: retry after 5")
+	api.send(1, alice, "/list")
+	waitFor(t, "the apology", func() bool { return api.lastMessage(1).Text == failureText })
+	api.send(1, alice, "/list")
+	waitFor(t, "the poll", func() bool { return api.lastMessage(1).Text == doneCmd })
+	if got := sentPolls(api.messages(1)); len(got) != 1 {
+		t.Fatalf("polls = %+v, want the only one", got)
+	}
+	if got, _ := db.OpenPolls(1, defaultList); len(got) != 1 {
+		t.Errorf("open polls = %+v, want the only one", got)
+	}
+
+	// the poll which can't be stopped, e.g. a deleted one, is forgotten and its votes are lost
+	p := sentPolls(api.messages(1))[0]
+	api.vote(t, 1, p.ID, alice, pollOption(t, p, "milk"))
+	api.fail("stopPoll", 400, "Bad Request: message with poll to stop not found")
+	api.send(1, alice, "/done")
+	waitFor(t, "the new poll", func() bool {
+		return len(sentPolls(api.messages(1))) == 2 && api.lastMessage(1).Text == doneCmd
+	})
+	if got, _ := db.List(1, defaultList); len(got) != 2 {
+		t.Errorf("items after failed stop = %v, want both", got)
+	}
+	if got, _ := db.OpenPolls(1, defaultList); len(got) != 1 || got[0].MessageID == p.ID {
+		t.Errorf("open polls after failed stop = %+v, want the new one", got)
+	}
+
+	// the bot kicked out of the group can't reply, even to apologize, but the items are added
+	sent := api.count("sendMessage")
+	api.fail("sendMessage", 403, "Forbidden: bot was kicked from the supergroup chat")
+	api.fail("sendMessage", 403, "Forbidden: bot was kicked from the supergroup chat")
+	api.send(-100, alice, "/add tea")
+	waitFor(t, "the reply and the apology to fail", func() bool { return api.count("sendMessage") == sent+2 })
+	if got := api.messages(-100); len(got) != 0 {
+		t.Errorf("messages sent into the group = %+v", got)
+	}
+	if got, _ := db.List(-100, defaultList); !reflect.DeepEqual(got, plainItems("tea")) {
+		t.Errorf("items of group = %v", got)
+	}
+}
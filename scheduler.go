@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// schedulerInterval is how often due jobs are checked
+const schedulerInterval = time.Minute
+
+// RunScheduler runs due jobs, staples and reminders, every interval until ctx is done.
+// Jobs missed while the bot was down are run on start
+func (s *Srv) RunScheduler(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		s.runDueJobs(s.now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// runDueJobs runs all jobs due at the time, a failed job doesn't stop the rest
+func (s *Srv) runDueJobs(at time.Time) {
+	if err := s.addDueStaples(at); err != nil {
+		s.logger.Error("can't add staples", "err", err)
+	}
+	if err := s.sendDueReminders(at); err != nil {
+		s.logger.Error("can't send reminders", "err", err)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// defaultShutdownTimeout is how long Run waits for the shutdown once its context is done
+const defaultShutdownTimeout = 5 * time.Second
+
+var (
+	errServerClosed = errors.New("server is shut down")
+	// errNotDrained means in-flight updates or scheduled jobs haven't finished by the deadline of the shutdown
+	errNotDrained = errors.New("server isn't drained")
+	// errNotFlushed means storage hasn't been flushed on shutdown, recent changes could be lost
+	errNotFlushed = errors.New("storage isn't flushed")
+)
+
+// stepErrors are errors of the steps of the shutdown, errors.Is matches any of them
+type stepErrors []error
+
+func (e stepErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e stepErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run gets updates and runs scheduled jobs, staples, reminders and snapshots of inmem storage.
+// It blocks until Shutdown is called, ctx is done or the poller fails, e.g. the webhook listener dies
+// or the token is revoked. In the latter cases it shuts the server down within shutdownTimeout itself.
+// It returns the failure of the poller along with errors of the shutdown
+func (s *Srv) Run(ctx context.Context) error {
+	s.lifeMu.Lock()
+	if s.started {
+		s.lifeMu.Unlock()
+		return errors.New("server is already running")
+	}
+	if s.closed {
+		s.lifeMu.Unlock()
+		return errServerClosed
+	}
+	s.started = true
+	jobs, stopJobs := context.WithCancel(context.Background())
+	s.stopJobs = stopJobs
+	s.lifeMu.Unlock()
+
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		s.RunScheduler(jobs, schedulerInterval)
+	}()
+	if inmem, ok := s.db.(*Inmem); ok && s.snapshotInterval > 0 {
+		s.jobs.Add(1)
+		go func() {
+			defer s.jobs.Done()
+			inmem.Snapshot(jobs, s.snapshotInterval)
+		}()
+	}
+	go s.bot.Start()
+	s.logger.Info("bot is started")
+
+	var failed error
+	select {
+	case <-ctx.Done():
+	case <-s.done:
+		return s.shutdownErr
+	case err := <-pollerFailures(s.bot):
+		s.logger.Error("can't get updates anymore", "err", err)
+		failed = err
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	err := s.Shutdown(sctx)
+	if failed == nil {
+		return err
+	}
+	errs := stepErrors{failed}
+	var steps stepErrors
+	if errors.As(err, &steps) {
+		errs = append(errs, steps...)
+	}
+	return errs
+}
+
+// pollerFailures returns failures of the poller of the bot, nil channel if it doesn't report them
+func pollerFailures(b TgBot) <-chan error {
+	tb, ok := b.(*tele.Bot)
+	if !ok {
+		return nil
+	}
+	p := tb.Poller
+	if mp, ok := p.(*tele.MiddlewarePoller); ok {
+		p = mp.Poller
+	}
+	if fp, ok := p.(failingPoller); ok {
+		return fp.failures()
+	}
+	return nil
+}
+
+// Shutdown stops getting updates, waits for in-flight updates, stops scheduled jobs, flushes and closes storage.
+// Storage is flushed even if ctx is done before updates and jobs are finished, but it isn't closed then,
+// the error tells about every failed step. Shutdown could be called before Run and more than once, the server can't be run after it
+func (s *Srv) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		s.shutdownErr = s.shutdown(ctx)
+		close(s.done)
+	})
+	return s.shutdownErr
+}
+
+func (s *Srv) shutdown(ctx context.Context) error {
+	s.lifeMu.Lock()
+	s.closed = true
+	started, stopJobs := s.started, s.stopJobs
+	s.lifeMu.Unlock()
+	s.logger.Info("shutting down")
+
+	var errs stepErrors
+	if started {
+		// webhook is removed from tg on stop, the long poll is cancelled
+		if err := wait(ctx, s.bot.Stop); err != nil {
+			s.logger.Error("polling hasn't stopped", "err", err)
+			errs = append(errs, fmt.Errorf("%w: polling: %v", errNotDrained, err))
+		} else {
+			s.logger.Info("polling is stopped")
+		}
+
+		if err := wait(ctx, s.handlers.Wait); err != nil {
+			s.logger.Error("in-flight updates haven't finished", "err", err)
+			errs = append(errs, fmt.Errorf("%w: in-flight updates: %v", errNotDrained, err))
+		} else {
+			s.logger.Info("in-flight updates are finished")
+		}
+
+		stopJobs()
+		if err := wait(ctx, s.jobs.Wait); err != nil {
+			s.logger.Error("scheduled jobs haven't finished", "err", err)
+			errs = append(errs, fmt.Errorf("%w: scheduled jobs: %v", errNotDrained, err))
+		} else {
+			s.logger.Info("scheduled jobs are stopped")
+		}
+	}
+
+	if err := s.db.Dump(); err != nil {
+		s.logger.Error("can't dump items", "err", err)
+		errs = append(errs, fmt.Errorf("%w: %v", errNotFlushed, err))
+	} else {
+		s.logger.Info("items are dumped")
+	}
+	// storage is left open under handlers and jobs which are still running, the process exits anyway
+	if c, ok := s.db.(io.Closer); ok && errors.Is(errs, errNotDrained) {
+		s.logger.Warn("storage isn't closed, the server isn't drained")
+	} else if ok {
+		if err := c.Close(); err != nil {
+			s.logger.Error("can't close storage", "err", err)
+			errs = append(errs, fmt.Errorf("%w: can't close storage: %v", errNotFlushed, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// wait waits for f to return until ctx is done
+func wait(ctx context.Context, f func()) error {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// track runs handlers of real bots in their own goroutines and counts them, so Shutdown could wait for them.
+// Telebot runs the middleware synchronously then, see makeBot, and every update got before polling
+// is stopped is counted
+func (s *Srv) track(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if !s.async {
+			return next(c)
+		}
+		s.handlers.Add(1)
+		go func() {
+			defer s.handlers.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					s.log(c).Error("handler panicked", "panic", r)
+				}
+			}()
+			if err := next(c); err != nil {
+				s.onError(err, c)
+			}
+		}()
+		return nil
+	}
+}
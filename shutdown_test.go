@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// slowStore is inmem storage which blocks Add until release is closed
+type slowStore struct {
+	*Inmem
+	entered chan struct{}
+	release chan struct{}
+	closed  bool
+}
+
+func (s *slowStore) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *slowStore) Add(chatID int64, list string, item Item) error {
+	s.entered <- struct{}{}
+	<-s.release
+	return s.Inmem.Add(chatID, list, item)
+}
+
+func TestSrv_Shutdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		// releaseAfter is when the blocked handler is released, it's never released before the deadline if zero
+		releaseAfter time.Duration
+		dumpPath     func(dir string) string
+		wantErr      []error
+		wantItems    []Item
+		// wantOpen means storage is left open under the running handler
+		wantOpen bool
+	}{
+		{
+			name: "drained", timeout: 5 * time.Second, releaseAfter: 200 * time.Millisecond,
+			dumpPath:  func(dir string) string { return filepath.Join(dir, "dump.gob") },
+			wantItems: plainItems("milk"),
+		},
+		{
+			name: "deadline", timeout: 200 * time.Millisecond,
+			dumpPath: func(dir string) string { return filepath.Join(dir, "dump.gob") },
+			wantErr:  []error{errNotDrained},
+			wantOpen: true,
+		},
+		{
+			name: "dump failed", timeout: 5 * time.Second, releaseAfter: 200 * time.Millisecond,
+			dumpPath: func(dir string) string { return filepath.Join(dir, "missing", "dump.gob") },
+			wantErr:  []error{errNotFlushed},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			path := tt.dumpPath(t.TempDir())
+			db := &slowStore{
+				Inmem:   &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}, path: path},
+				entered: make(chan struct{}),
+				release: make(chan struct{}),
+			}
+			srv := newBotServer(t, api, db)
+			done := make(chan error)
+			go func() { done <- srv.Run(context.Background()) }()
+
+			api.send(1, &tele.User{ID: 42}, "/add milk")
+			select {
+			case <-db.entered:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("the update hasn't been handled")
+			}
+			released := make(chan struct{})
+			if tt.releaseAfter > 0 {
+				time.AfterFunc(tt.releaseAfter, func() { close(released); close(db.release) })
+			} else {
+				// the handler is let go once the shutdown has given up on it
+				defer close(db.release)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			err := srv.Shutdown(ctx)
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("Shutdown() error = %v, want %v", err, want)
+				}
+			}
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Errorf("Shutdown() error = %v", err)
+			}
+			if db.closed == tt.wantOpen {
+				t.Errorf("storage is closed = %v, want %v", db.closed, !tt.wantOpen)
+			}
+			if tt.releaseAfter > 0 {
+				select {
+				case <-released:
+				default:
+					t.Errorf("Shutdown() has returned before the in-flight update is finished")
+				}
+			}
+			if got := <-done; !reflect.DeepEqual(got, err) {
+				t.Errorf("Run() error = %v, want the error of Shutdown() %v", got, err)
+			}
+			if again := srv.Shutdown(context.Background()); !reflect.DeepEqual(again, err) {
+				t.Errorf("second Shutdown() error = %v, want %v", again, err)
+			}
+
+			if tt.wantItems == nil {
+				return
+			}
+			restored := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}, path: path}
+			if err := restored.Restore(); err != nil {
+				t.Fatalf("Restore() error = %v", err)
+			}
+			if got, _ := restored.List(1, defaultList); !reflect.DeepEqual(got, tt.wantItems) {
+				t.Errorf("dumped items = %v, want %v", got, tt.wantItems)
+			}
+			if got := api.lastMessage(1).Text; got != "Added 1 item(s): milk" {
+				t.Errorf("reply = %q", got)
+			}
+		})
+	}
+}
+
+func TestSrv_ShutdownBeforeRun(t *testing.T) {
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}, path: filepath.Join(t.TempDir(), "dump.gob")}
+	srv := NewServer(db, newFakeBot())
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if err := srv.Run(context.Background()); !errors.Is(err, errServerClosed) {
+		t.Errorf("Run() after Shutdown() error = %v, want %v", err, errServerClosed)
+	}
+}
+
+func TestSrv_ShutdownLongPoll(t *testing.T) {
+	api := newFakeAPI(t)
+	b, err := makeBot(tele.Settings{URL: api.URL, Token: "token"}, Config{Poller: "long", PollTimeout: 30 * time.Second})
+	if err != nil {
+		t.Fatalf("makeBot() error = %v", err)
+	}
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}, path: filepath.Join(t.TempDir(), "dump.gob")}
+	srv := NewServer(db, b)
+	done := make(chan error)
+	go func() { done <- srv.Run(context.Background()) }()
+	waitFor(t, "the long poll", func() bool { return api.called("getUpdates") })
+
+	// the long poll is cancelled, the shutdown doesn't wait for its timeout
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Shutdown() took %s", took)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func TestSrv_RunPollerFailed(t *testing.T) {
+	api := newFakeAPI(t)
+	path := filepath.Join(t.TempDir(), "dump.gob")
+	srv := newBotServer(t, api, &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}, path: path})
+	api.fail("getUpdates", 401, "Unauthorized")
+
+	done := make(chan error)
+	go func() { done <- srv.Run(context.Background()) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+			t.Errorf("Run() error = %v, want the failure of the poller", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run() hasn't returned after the poller failed")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("items aren't dumped after the poller failed: %v", err)
+	}
+}
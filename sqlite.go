@@ -0,0 +1,1439 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	// pure go sqlite driver, so we still can build without CGO
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations are applied one by one in order, index of the last applied one (+1) is kept in user_version
+var sqliteMigrations = []func(tx *sql.Tx) error{
+	execSQL(`
+		CREATE TABLE IF NOT EXISTS items (
+			id      INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER NOT NULL,
+			item    TEXT    NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS items_chat_id ON items (chat_id);
+
+		CREATE TABLE IF NOT EXISTS chat_settings (
+			chat_id INTEGER PRIMARY KEY,
+			data    TEXT    NOT NULL
+		);
+	`),
+	migrateStructuredItems,
+	execSQL(`
+		ALTER TABLE items ADD COLUMN list TEXT NOT NULL DEFAULT 'default';
+		DROP INDEX IF EXISTS items_chat_id;
+		CREATE INDEX IF NOT EXISTS items_chat_id_list ON items (chat_id, list);
+
+		CREATE TABLE IF NOT EXISTS lists (
+			chat_id INTEGER NOT NULL,
+			name    TEXT    NOT NULL,
+			PRIMARY KEY (chat_id, name)
+		);
+	`),
+	execSQL(`
+		CREATE TABLE IF NOT EXISTS purchases (
+			id        INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id   INTEGER NOT NULL,
+			list      TEXT    NOT NULL,
+			name      TEXT    NOT NULL,
+			quantity  REAL    NOT NULL DEFAULT 0,
+			unit      TEXT    NOT NULL DEFAULT '',
+			note      TEXT    NOT NULL DEFAULT '',
+			user_id   INTEGER NOT NULL DEFAULT 0,
+			user_name TEXT    NOT NULL DEFAULT '',
+			bought_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS purchases_chat_id_bought_at ON purchases (chat_id, bought_at);
+	`),
+	execSQL(`
+		CREATE TABLE IF NOT EXISTS undo_journal (
+			id         INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id    INTEGER NOT NULL,
+			cmd        TEXT    NOT NULL,
+			list       TEXT    NOT NULL,
+			items      TEXT    NOT NULL,
+			created_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS undo_journal_chat_id ON undo_journal (chat_id);
+	`),
+	execSQL(`
+		CREATE TABLE IF NOT EXISTS open_polls (
+			id         TEXT    PRIMARY KEY,
+			chat_id    INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			list       TEXT    NOT NULL,
+			items      TEXT    NOT NULL
+		);
+	`),
+	execSQL(`CREATE INDEX IF NOT EXISTS open_polls_chat_id_list ON open_polls (chat_id, list)`),
+	execSQL(`
+		CREATE TABLE IF NOT EXISTS staples (
+			id      INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER NOT NULL,
+			list    TEXT    NOT NULL,
+			item    TEXT    NOT NULL,
+			every   INTEGER NOT NULL,
+			next_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS staples_chat_id ON staples (chat_id);
+		CREATE INDEX IF NOT EXISTS staples_next_at ON staples (next_at);
+	`),
+	execSQL(`
+		CREATE TABLE IF NOT EXISTS reminders (
+			id      INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER NOT NULL,
+			list    TEXT    NOT NULL,
+			weekday INTEGER NOT NULL,
+			daily   INTEGER NOT NULL,
+			hour    INTEGER NOT NULL,
+			minute  INTEGER NOT NULL,
+			next_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS reminders_chat_id ON reminders (chat_id);
+		CREATE INDEX IF NOT EXISTS reminders_next_at ON reminders (next_at);
+	`),
+	execSQL(`
+		CREATE TABLE IF NOT EXISTS recipes (
+			chat_id  INTEGER NOT NULL,
+			key      TEXT    NOT NULL,
+			name     TEXT    NOT NULL,
+			servings INTEGER NOT NULL DEFAULT 0,
+			items    TEXT    NOT NULL,
+			PRIMARY KEY (chat_id, key)
+		);
+	`),
+	execSQL(`
+		CREATE TABLE IF NOT EXISTS user_chats (
+			id      INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			chat_id INTEGER NOT NULL,
+			title   TEXT    NOT NULL DEFAULT '',
+			UNIQUE (user_id, chat_id)
+		);
+	`),
+}
+
+func execSQL(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// migrateStructuredItems splits plain string items into name, quantity, unit and note
+func migrateStructuredItems(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		ALTER TABLE items RENAME COLUMN item TO name;
+		ALTER TABLE items ADD COLUMN quantity REAL NOT NULL DEFAULT 0;
+		ALTER TABLE items ADD COLUMN unit TEXT NOT NULL DEFAULT '';
+		ALTER TABLE items ADD COLUMN note TEXT NOT NULL DEFAULT '';
+	`); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT id, name FROM items`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	parsed := make(map[int64]Item)
+	for rows.Next() {
+		var (
+			id   int64
+			name string
+		)
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		parsed[id] = parseItem(name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, item := range parsed {
+		if _, err := tx.Exec(
+			`UPDATE items SET name = ?, quantity = ?, unit = ?, note = ? WHERE id = ?`,
+			item.Name, item.Quantity, item.Unit, item.Note, id,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SQLite is embedded sqlite implementation of ItemStorager.
+// Every write goes in its own transaction, so nothing is lost if the bot crashes.
+type SQLite struct {
+	db       *sql.DB
+	dumpPath string
+	// limits are quotas of every chat, they aren't checked on Restore
+	limits Limits
+	// fresh is true if the db has been created by NewSQLite, its schema had no version before migrations
+	fresh bool
+}
+
+var _ ItemStorager = (*SQLite)(nil)
+
+// NewSQLite opens (or creates) sqlite database in dbPath and migrates the schema.
+// dumpPath is used by Dump and Restore to export and import items in gob format
+func NewSQLite(dbPath, dumpPath string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't open sqlite db: %w", err)
+	}
+	// sqlite allows only one writer at a time anyway
+	db.SetMaxOpenConns(1)
+
+	s := &SQLite{db: db, dumpPath: dumpPath}
+	if err := s.migrate(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// migrate applies all migrations which haven't been applied yet
+func (s *SQLite) migrate() error {
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("can't get schema version: %w", err)
+	}
+	s.fresh = version == 0
+
+	for ; version < len(sqliteMigrations); version++ {
+		m := sqliteMigrations[version]
+		next := version + 1
+		err := s.tx(func(tx *sql.Tx) error {
+			if err := m(tx); err != nil {
+				return err
+			}
+			// pragma can't take query args
+			_, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, next))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("can't migrate schema to version %d: %w", next, err)
+		}
+	}
+	return nil
+}
+
+// Add inserts item into the list of chatID or merges it into the same item
+func (s *SQLite) Add(chatID int64, list string, item Item) error {
+	if err := s.limits.checkItem(item); err != nil {
+		return err
+	}
+	return s.tx(func(tx *sql.Tx) error {
+		return addItem(tx, chatID, list, item, s.limits)
+	})
+}
+
+// PutBack adds items removed before into the list of chatID regardless of quotas, all or none of them
+func (s *SQLite) PutBack(chatID int64, list string, items []Item) error {
+	return s.tx(func(tx *sql.Tx) error {
+		for _, item := range items {
+			if err := addItem(tx, chatID, list, item, Limits{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Remove deletes the first matched item from the list of chatID
+func (s *SQLite) Remove(chatID int64, list, name string) error {
+	return s.tx(func(tx *sql.Tx) error {
+		ids, items, err := selectItems(tx, chatID, list)
+		if err != nil {
+			return err
+		}
+
+		for i, item := range items {
+			if sameItem(item.Name, name) {
+				_, err := tx.Exec(`DELETE FROM items WHERE id = ?`, ids[i])
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Take deletes the first item with name from the list of chatID and returns it
+func (s *SQLite) Take(chatID int64, list, name string) (item Item, ok bool, err error) {
+	err = s.tx(func(tx *sql.Tx) error {
+		ids, items, err := selectItems(tx, chatID, list)
+		if err != nil {
+			return err
+		}
+		for i := range items {
+			if sameItem(items[i].Name, name) {
+				item, ok = items[i], true
+				_, err := tx.Exec(`DELETE FROM items WHERE id = ?`, ids[i])
+				return err
+			}
+		}
+		return nil
+	})
+	return item, ok, err
+}
+
+// Replace updates the first item with name, or merges the new item into the same one and deletes the old one
+func (s *SQLite) Replace(chatID int64, list, name string, item Item) (ok bool, err error) {
+	if err := s.limits.checkItem(item); err != nil {
+		return false, err
+	}
+	err = s.tx(func(tx *sql.Tx) error {
+		ids, items, err := selectItems(tx, chatID, list)
+		if err != nil {
+			return err
+		}
+		i := -1
+		for j := range items {
+			if sameItem(items[j].Name, name) {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return nil
+		}
+		ok = true
+
+		for j, existing := range items {
+			if j == i {
+				continue
+			}
+			if merged, mergedOK := mergeItems(existing, item); mergedOK {
+				if _, err := tx.Exec(
+					`UPDATE items SET quantity = ?, unit = ?, note = ? WHERE id = ?`,
+					merged.Quantity, merged.Unit, merged.Note, ids[j],
+				); err != nil {
+					return err
+				}
+				_, err := tx.Exec(`DELETE FROM items WHERE id = ?`, ids[i])
+				return err
+			}
+		}
+		_, err = tx.Exec(
+			`UPDATE items SET name = ?, quantity = ?, unit = ?, note = ? WHERE id = ?`,
+			item.Name, item.Quantity, item.Unit, item.Note, ids[i],
+		)
+		return err
+	})
+	return ok, err
+}
+
+// Clear deletes all items from the list of chatID, the list itself is kept
+func (s *SQLite) Clear(chatID int64, list string) (items []Item, err error) {
+	err = s.tx(func(tx *sql.Tx) error {
+		if _, items, err = selectItems(tx, chatID, list); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM items WHERE chat_id = ? AND list = ?`, chatID, list)
+		return err
+	})
+	return items, err
+}
+
+// GetAll return bunches of items from the list of chatID in insertion order
+func (s *SQLite) GetAll(chatID int64, list string) ([][]Item, error) {
+	items, err := s.List(chatID, list)
+	if err != nil {
+		return nil, err
+	}
+	return makeBunches(items), nil
+}
+
+// List returns items from the list of chatID in insertion order
+func (s *SQLite) List(chatID int64, list string) ([]Item, error) {
+	rows, err := s.db.Query(`SELECT id, name, quantity, unit, note FROM items WHERE chat_id = ? AND list = ? ORDER BY id`, chatID, list)
+	if err != nil {
+		return nil, fmt.Errorf("can't select items: %w", err)
+	}
+	_, items, err := scanItems(rows)
+	return items, err
+}
+
+// CreateList creates new empty list in chatID
+func (s *SQLite) CreateList(chatID int64, list string) error {
+	if list == defaultList {
+		return fmt.Errorf("%w: %s", errListExists, list)
+	}
+	// lists are counted in the same transaction, so concurrent calls can't go over the quota
+	return s.tx(func(tx *sql.Tx) error {
+		if s.limits.Lists > 0 {
+			lists, err := selectLists(tx, chatID)
+			if err != nil {
+				return err
+			}
+			if !containsString(lists, list) {
+				if err := s.limits.checkLists(len(lists)); err != nil {
+					return err
+				}
+			}
+		}
+		res, err := tx.Exec(`INSERT INTO lists (chat_id, name) VALUES (?, ?) ON CONFLICT DO NOTHING`, chatID, list)
+		if err != nil {
+			return fmt.Errorf("can't insert list: %w", err)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return fmt.Errorf("%w: %s", errListExists, list)
+		}
+		return nil
+	})
+}
+
+// Lists returns names of all lists of chatID, the default one goes first
+func (s *SQLite) Lists(chatID int64) ([]string, error) {
+	return selectLists(s.db, chatID)
+}
+
+// queryer is either db or transaction
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// selectLists selects names of all lists of chatID, the default one goes first
+func selectLists(q queryer, chatID int64) ([]string, error) {
+	rows, err := q.Query(`
+		SELECT name FROM lists WHERE chat_id = ? AND name != ?
+		UNION
+		SELECT DISTINCT list FROM items WHERE chat_id = ? AND list != ?
+		ORDER BY 1`,
+		chatID, defaultList, chatID, defaultList,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't select lists: %w", err)
+	}
+	defer rows.Close()
+
+	names := []string{defaultList}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("can't scan list: %w", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't read lists: %w", err)
+	}
+	return names, nil
+}
+
+// ItemCounts returns the number of items in all lists of every chat which has any
+func (s *SQLite) ItemCounts() (map[int64]int, error) {
+	rows, err := s.db.Query(`SELECT chat_id, COUNT(*) FROM items GROUP BY chat_id`)
+	if err != nil {
+		return nil, fmt.Errorf("can't count items: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int64]int)
+	for rows.Next() {
+		var (
+			chatID int64
+			n      int
+		)
+		if err := rows.Scan(&chatID, &n); err != nil {
+			return nil, fmt.Errorf("can't scan item count: %w", err)
+		}
+		counts[chatID] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't read item counts: %w", err)
+	}
+	return counts, nil
+}
+
+// AddPurchase records item bought in chatID and drops the oldest purchases over historyLimit
+func (s *SQLite) AddPurchase(chatID int64, p Purchase) error {
+	return s.tx(func(tx *sql.Tx) error {
+		if err := insertPurchase(tx, chatID, p); err != nil {
+			return err
+		}
+		_, err := tx.Exec(
+			`DELETE FROM purchases WHERE chat_id = ? AND id NOT IN (
+				SELECT id FROM purchases WHERE chat_id = ? ORDER BY id DESC LIMIT ?
+			)`,
+			chatID, chatID, historyLimit,
+		)
+		return err
+	})
+}
+
+// History returns purchases made in chatID since the time, the oldest first
+func (s *SQLite) History(chatID int64, since time.Time) ([]Purchase, error) {
+	rows, err := s.db.Query(
+		`SELECT list, name, quantity, unit, note, user_id, user_name, bought_at
+		FROM purchases WHERE chat_id = ? AND bought_at >= ? ORDER BY bought_at, id`,
+		chatID, since.UnixNano(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't select purchases: %w", err)
+	}
+	defer rows.Close()
+
+	var history []Purchase
+	for rows.Next() {
+		p, err := scanPurchase(rows)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't read purchases: %w", err)
+	}
+	return history, nil
+}
+
+// RemovePurchases removes purchases made in chatID at exactly that time
+func (s *SQLite) RemovePurchases(chatID int64, at time.Time) error {
+	return s.tx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM purchases WHERE chat_id = ? AND bought_at = ?`, chatID, at.UnixNano())
+		return err
+	})
+}
+
+// RemovePurchase removes the latest purchase of the item of the list made in chatID at exactly that time
+func (s *SQLite) RemovePurchase(chatID int64, list, name string, at time.Time) error {
+	return s.tx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			`DELETE FROM purchases WHERE id = (
+				SELECT id FROM purchases WHERE chat_id = ? AND list = ? AND name = ? AND bought_at = ? ORDER BY id DESC LIMIT 1
+			)`,
+			chatID, list, name, at.UnixNano(),
+		)
+		return err
+	})
+}
+
+// PushUndo adds entry into the undo journal of chatID and drops the oldest entries over undoLimit.
+// Items of the entry are stored as json
+func (s *SQLite) PushUndo(chatID int64, e UndoEntry) error {
+	return s.tx(func(tx *sql.Tx) error {
+		if err := insertUndo(tx, chatID, e); err != nil {
+			return err
+		}
+		_, err := tx.Exec(
+			`DELETE FROM undo_journal WHERE chat_id = ? AND id NOT IN (
+				SELECT id FROM undo_journal WHERE chat_id = ? ORDER BY id DESC LIMIT ?
+			)`,
+			chatID, chatID, undoLimit,
+		)
+		return err
+	})
+}
+
+// PopUndo takes the last entry from the undo journal of chatID
+func (s *SQLite) PopUndo(chatID int64) (e UndoEntry, ok bool, err error) {
+	err = s.tx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(
+			`SELECT id, cmd, list, items, created_at FROM undo_journal WHERE chat_id = ? ORDER BY id DESC LIMIT 1`,
+			chatID,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			return rows.Err()
+		}
+
+		var id int64
+		if e, err = scanUndo(rows, &id); err != nil {
+			return err
+		}
+		_ = rows.Close()
+
+		ok = true
+		_, err = tx.Exec(`DELETE FROM undo_journal WHERE id = ?`, id)
+		return err
+	})
+	return e, ok, err
+}
+
+// SaveOpenPoll saves poll or replaces the one with the same id. Items of the poll are stored as json
+func (s *SQLite) SaveOpenPoll(p OpenPoll) error {
+	return s.tx(func(tx *sql.Tx) error {
+		return insertOpenPoll(tx, p)
+	})
+}
+
+// OpenPoll returns poll by its tg id
+func (s *SQLite) OpenPoll(pollID string) (p OpenPoll, ok bool, err error) {
+	rows, err := s.db.Query(`SELECT id, chat_id, message_id, list, items FROM open_polls WHERE id = ?`, pollID)
+	if err != nil {
+		return p, false, fmt.Errorf("can't select poll: %w", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return p, false, rows.Err()
+	}
+	p, err = scanOpenPoll(rows)
+	return p, err == nil, err
+}
+
+// OpenPolls returns open polls of the list of chatID ordered by message
+func (s *SQLite) OpenPolls(chatID int64, list string) ([]OpenPoll, error) {
+	rows, err := s.db.Query(
+		`SELECT id, chat_id, message_id, list, items FROM open_polls WHERE chat_id = ? AND list = ? ORDER BY message_id`,
+		chatID, list,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't select polls: %w", err)
+	}
+	return scanOpenPolls(rows)
+}
+
+// AllOpenPolls returns open polls of all chats
+func (s *SQLite) AllOpenPolls() ([]OpenPoll, error) {
+	rows, err := s.db.Query(`SELECT id, chat_id, message_id, list, items FROM open_polls ORDER BY chat_id, message_id`)
+	if err != nil {
+		return nil, fmt.Errorf("can't select polls: %w", err)
+	}
+	return scanOpenPolls(rows)
+}
+
+// DeleteOpenPoll forgets the poll
+func (s *SQLite) DeleteOpenPoll(pollID string) error {
+	return s.tx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM open_polls WHERE id = ?`, pollID)
+		return err
+	})
+}
+
+// SaveStaple adds new staple, if its ID is zero, or replaces the one with the same ID. It returns ID of the staple.
+// The item of the staple is stored as json
+func (s *SQLite) SaveStaple(st Staple) (id int64, err error) {
+	err = s.tx(func(tx *sql.Tx) error {
+		id, err = insertStaple(tx, st)
+		return err
+	})
+	return id, err
+}
+
+// Staples returns staples of chatID ordered by ID
+func (s *SQLite) Staples(chatID int64) ([]Staple, error) {
+	rows, err := s.db.Query(`SELECT id, chat_id, list, item, every, next_at FROM staples WHERE chat_id = ? ORDER BY id`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("can't select staples: %w", err)
+	}
+	return scanStaples(rows)
+}
+
+// DueStaples returns staples of all chats which should be added to their lists at or before the time
+func (s *SQLite) DueStaples(at time.Time) ([]Staple, error) {
+	rows, err := s.db.Query(`SELECT id, chat_id, list, item, every, next_at FROM staples WHERE next_at <= ? ORDER BY id`, at.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("can't select staples: %w", err)
+	}
+	return scanStaples(rows)
+}
+
+// DeleteStaple forgets the staple of chatID and reports if there was one
+func (s *SQLite) DeleteStaple(chatID, id int64) (ok bool, err error) {
+	err = s.tx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM staples WHERE id = ? AND chat_id = ?`, id, chatID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		ok = n > 0
+		return err
+	})
+	return ok, err
+}
+
+// SaveReminder adds new reminder, if its ID is zero, or replaces the one with the same ID. It returns ID of the reminder
+func (s *SQLite) SaveReminder(r Reminder) (id int64, err error) {
+	err = s.tx(func(tx *sql.Tx) error {
+		id, err = insertReminder(tx, r)
+		return err
+	})
+	return id, err
+}
+
+// Reminders returns reminders of chatID ordered by ID
+func (s *SQLite) Reminders(chatID int64) ([]Reminder, error) {
+	rows, err := s.db.Query(
+		`SELECT id, chat_id, list, weekday, daily, hour, minute, next_at FROM reminders WHERE chat_id = ? ORDER BY id`,
+		chatID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't select reminders: %w", err)
+	}
+	return scanReminders(rows)
+}
+
+// DueReminders returns reminders of all chats which should go off at or before the time
+func (s *SQLite) DueReminders(at time.Time) ([]Reminder, error) {
+	rows, err := s.db.Query(
+		`SELECT id, chat_id, list, weekday, daily, hour, minute, next_at FROM reminders WHERE next_at <= ? ORDER BY id`,
+		at.UnixNano(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't select reminders: %w", err)
+	}
+	return scanReminders(rows)
+}
+
+// DeleteReminder forgets the reminder of chatID and reports if there was one
+func (s *SQLite) DeleteReminder(chatID, id int64) (ok bool, err error) {
+	err = s.tx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM reminders WHERE id = ? AND chat_id = ?`, id, chatID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		ok = n > 0
+		return err
+	})
+	return ok, err
+}
+
+// SaveRecipe saves recipe of chatID or replaces the one with the same name regardless of case.
+// Items of the recipe are stored as json
+func (s *SQLite) SaveRecipe(chatID int64, r Recipe) error {
+	return s.tx(func(tx *sql.Tx) error {
+		return insertRecipe(tx, chatID, r)
+	})
+}
+
+// Recipe returns recipe of chatID by its name regardless of case
+func (s *SQLite) Recipe(chatID int64, name string) (Recipe, bool, error) {
+	rows, err := s.db.Query(`SELECT chat_id, name, servings, items FROM recipes WHERE chat_id = ? AND key = ?`, chatID, foldName(name))
+	if err != nil {
+		return Recipe{}, false, fmt.Errorf("can't select recipe: %w", err)
+	}
+	recipes, err := scanRecipes(rows)
+	if err != nil || len(recipes) == 0 {
+		return Recipe{}, false, err
+	}
+	return recipes[0].Recipe, true, nil
+}
+
+// Recipes returns recipes of chatID ordered by name
+func (s *SQLite) Recipes(chatID int64) ([]Recipe, error) {
+	rows, err := s.db.Query(`SELECT chat_id, name, servings, items FROM recipes WHERE chat_id = ? ORDER BY key`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("can't select recipes: %w", err)
+	}
+	chatRecipes, err := scanRecipes(rows)
+	if err != nil {
+		return nil, err
+	}
+	recipes := make([]Recipe, 0, len(chatRecipes))
+	for _, r := range chatRecipes {
+		recipes = append(recipes, r.Recipe)
+	}
+	return recipes, nil
+}
+
+// DeleteRecipe forgets the recipe of chatID and reports if there was one
+func (s *SQLite) DeleteRecipe(chatID int64, name string) (ok bool, err error) {
+	err = s.tx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM recipes WHERE chat_id = ? AND key = ?`, chatID, foldName(name))
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		ok = n > 0
+		return err
+	})
+	return ok, err
+}
+
+// SaveUserChat adds the chat to chats of userID, or updates its title
+func (s *SQLite) SaveUserChat(userID int64, chat UserChat) error {
+	return s.tx(func(tx *sql.Tx) error {
+		return insertUserChat(tx, userID, chat)
+	})
+}
+
+// UserChats returns chats of userID in order they were saved first
+func (s *SQLite) UserChats(userID int64) ([]UserChat, error) {
+	rows, err := s.db.Query(`SELECT user_id, chat_id, title FROM user_chats WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("can't select chats of user: %w", err)
+	}
+	userChats, err := scanUserChats(rows)
+	if err != nil {
+		return nil, err
+	}
+	chats := make([]UserChat, 0, len(userChats))
+	for _, uc := range userChats {
+		chats = append(chats, uc.UserChat)
+	}
+	return chats, nil
+}
+
+// Settings returns settings of chatID, settings are stored as json to not migrate schema on every new field
+func (s *SQLite) Settings(chatID int64) (ChatSettings, error) {
+	var (
+		data     string
+		settings ChatSettings
+	)
+	err := s.db.QueryRow(`SELECT data FROM chat_settings WHERE chat_id = ?`, chatID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, fmt.Errorf("can't select settings: %w", err)
+	}
+	if err := json.Unmarshal([]byte(data), &settings); err != nil {
+		return settings, fmt.Errorf("can't decode settings: %w", err)
+	}
+	return settings, nil
+}
+
+// SetSettings saves settings of chatID
+func (s *SQLite) SetSettings(chatID int64, settings ChatSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("can't encode settings: %w", err)
+	}
+	return s.tx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			`INSERT INTO chat_settings (chat_id, data) VALUES (?, ?) ON CONFLICT (chat_id) DO UPDATE SET data = excluded.data`,
+			chatID, string(data),
+		)
+		return err
+	})
+}
+
+// Dump exports all items into gob dump
+func (s *SQLite) Dump() error {
+	d := &dumpData{
+		Lists:     make(map[int64]map[string][]Item),
+		Settings:  make(map[int64]ChatSettings),
+		History:   make(map[int64][]Purchase),
+		Undo:      make(map[int64][]UndoEntry),
+		Polls:     make(map[string]OpenPoll),
+		Staples:   make(map[int64]Staple),
+		Reminders: make(map[int64]Reminder),
+		Recipes:   make(map[int64]map[string]Recipe),
+		UserChats: make(map[int64][]UserChat),
+	}
+	chatLists := func(chatID int64) map[string][]Item {
+		if d.Lists[chatID] == nil {
+			d.Lists[chatID] = make(map[string][]Item)
+		}
+		return d.Lists[chatID]
+	}
+
+	// all tables are read in one transaction, so the dump is consistent
+	err := s.tx(func(tx *sql.Tx) error {
+		listRows, err := tx.Query(`SELECT chat_id, name FROM lists`)
+		if err != nil {
+			return fmt.Errorf("can't select lists: %w", err)
+		}
+		defer listRows.Close()
+
+		for listRows.Next() {
+			var (
+				chatID int64
+				name   string
+			)
+			if err := listRows.Scan(&chatID, &name); err != nil {
+				return fmt.Errorf("can't scan list: %w", err)
+			}
+			chatLists(chatID)[name] = []Item{}
+		}
+		if err := listRows.Err(); err != nil {
+			return fmt.Errorf("can't read lists: %w", err)
+		}
+
+		rows, err := tx.Query(`SELECT chat_id, list, name, quantity, unit, note FROM items ORDER BY id`)
+		if err != nil {
+			return fmt.Errorf("can't select items: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				chatID int64
+				list   string
+				item   Item
+			)
+			if err := rows.Scan(&chatID, &list, &item.Name, &item.Quantity, &item.Unit, &item.Note); err != nil {
+				return fmt.Errorf("can't scan item: %w", err)
+			}
+			lists := chatLists(chatID)
+			lists[list] = append(lists[list], item)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("can't read items: %w", err)
+		}
+
+		settingsRows, err := tx.Query(`SELECT chat_id, data FROM chat_settings`)
+		if err != nil {
+			return fmt.Errorf("can't select settings: %w", err)
+		}
+		defer settingsRows.Close()
+
+		for settingsRows.Next() {
+			var (
+				chatID   int64
+				data     string
+				settings ChatSettings
+			)
+			if err := settingsRows.Scan(&chatID, &data); err != nil {
+				return fmt.Errorf("can't scan settings: %w", err)
+			}
+			if err := json.Unmarshal([]byte(data), &settings); err != nil {
+				return fmt.Errorf("can't decode settings: %w", err)
+			}
+			d.Settings[chatID] = settings
+		}
+		if err := settingsRows.Err(); err != nil {
+			return fmt.Errorf("can't read settings: %w", err)
+		}
+
+		purchaseRows, err := tx.Query(
+			`SELECT chat_id, list, name, quantity, unit, note, user_id, user_name, bought_at FROM purchases ORDER BY id`,
+		)
+		if err != nil {
+			return fmt.Errorf("can't select purchases: %w", err)
+		}
+		defer purchaseRows.Close()
+
+		for purchaseRows.Next() {
+			var chatID int64
+			p, err := scanPurchase(purchaseRows, &chatID)
+			if err != nil {
+				return err
+			}
+			d.History[chatID] = append(d.History[chatID], p)
+		}
+		if err := purchaseRows.Err(); err != nil {
+			return fmt.Errorf("can't read purchases: %w", err)
+		}
+
+		undoRows, err := tx.Query(`SELECT chat_id, cmd, list, items, created_at FROM undo_journal ORDER BY id`)
+		if err != nil {
+			return fmt.Errorf("can't select undo journal: %w", err)
+		}
+		defer undoRows.Close()
+
+		for undoRows.Next() {
+			var chatID int64
+			e, err := scanUndo(undoRows, &chatID)
+			if err != nil {
+				return err
+			}
+			d.Undo[chatID] = append(d.Undo[chatID], e)
+		}
+		if err := undoRows.Err(); err != nil {
+			return fmt.Errorf("can't read undo journal: %w", err)
+		}
+
+		pollRows, err := tx.Query(`SELECT id, chat_id, message_id, list, items FROM open_polls ORDER BY chat_id, message_id`)
+		if err != nil {
+			return fmt.Errorf("can't select polls: %w", err)
+		}
+		polls, err := scanOpenPolls(pollRows)
+		if err != nil {
+			return err
+		}
+		for _, p := range polls {
+			d.Polls[p.ID] = p
+		}
+
+		stapleRows, err := tx.Query(`SELECT id, chat_id, list, item, every, next_at FROM staples`)
+		if err != nil {
+			return fmt.Errorf("can't select staples: %w", err)
+		}
+		staples, err := scanStaples(stapleRows)
+		if err != nil {
+			return err
+		}
+		for _, st := range staples {
+			d.Staples[st.ID] = st
+		}
+
+		reminderRows, err := tx.Query(`SELECT id, chat_id, list, weekday, daily, hour, minute, next_at FROM reminders`)
+		if err != nil {
+			return fmt.Errorf("can't select reminders: %w", err)
+		}
+		reminders, err := scanReminders(reminderRows)
+		if err != nil {
+			return err
+		}
+		for _, r := range reminders {
+			d.Reminders[r.ID] = r
+		}
+
+		recipeRows, err := tx.Query(`SELECT chat_id, name, servings, items FROM recipes`)
+		if err != nil {
+			return fmt.Errorf("can't select recipes: %w", err)
+		}
+		recipes, err := scanRecipes(recipeRows)
+		if err != nil {
+			return err
+		}
+		for _, r := range recipes {
+			if d.Recipes[r.chatID] == nil {
+				d.Recipes[r.chatID] = make(map[string]Recipe)
+			}
+			d.Recipes[r.chatID][foldName(r.Name)] = r.Recipe
+		}
+
+		userChatRows, err := tx.Query(`SELECT user_id, chat_id, title FROM user_chats ORDER BY id`)
+		if err != nil {
+			return fmt.Errorf("can't select chats of users: %w", err)
+		}
+		userChats, err := scanUserChats(userChatRows)
+		if err != nil {
+			return err
+		}
+		for _, uc := range userChats {
+			d.UserChats[uc.userID] = append(d.UserChats[uc.userID], uc.UserChat)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeDump(s.dumpPath, d, 0)
+}
+
+// Restore replaces all items in db with items from gob dump
+func (s *SQLite) Restore() error {
+	d, err := readDump(s.dumpPath)
+	if err != nil {
+		return err
+	}
+
+	return s.tx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM items; DELETE FROM lists; DELETE FROM chat_settings; DELETE FROM purchases; DELETE FROM undo_journal; DELETE FROM open_polls; DELETE FROM staples; DELETE FROM reminders; DELETE FROM recipes; DELETE FROM user_chats`); err != nil {
+			return err
+		}
+		for chatID, lists := range d.Lists {
+			for list, items := range lists {
+				if list != defaultList {
+					if _, err := tx.Exec(`INSERT INTO lists (chat_id, name) VALUES (?, ?)`, chatID, list); err != nil {
+						return err
+					}
+				}
+				for _, item := range items {
+					if err := insertItem(tx, chatID, list, item); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		for chatID, settings := range d.Settings {
+			data, err := json.Marshal(settings)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT INTO chat_settings (chat_id, data) VALUES (?, ?)`, chatID, string(data)); err != nil {
+				return err
+			}
+		}
+		for chatID, history := range d.History {
+			for _, p := range history {
+				if err := insertPurchase(tx, chatID, p); err != nil {
+					return err
+				}
+			}
+		}
+		for chatID, journal := range d.Undo {
+			for _, e := range journal {
+				if err := insertUndo(tx, chatID, e); err != nil {
+					return err
+				}
+			}
+		}
+		for _, p := range d.Polls {
+			if err := insertOpenPoll(tx, p); err != nil {
+				return err
+			}
+		}
+		for _, st := range d.Staples {
+			if _, err := insertStaple(tx, st); err != nil {
+				return err
+			}
+		}
+		for _, r := range d.Reminders {
+			if _, err := insertReminder(tx, r); err != nil {
+				return err
+			}
+		}
+		for chatID, recipes := range d.Recipes {
+			for _, r := range recipes {
+				if err := insertRecipe(tx, chatID, r); err != nil {
+					return err
+				}
+			}
+		}
+		for userID, chats := range d.UserChats {
+			for _, chat := range chats {
+				if err := insertUserChat(tx, userID, chat); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes underlying db
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// selectItems selects all items of the list of chatID with their ids in insertion order
+func selectItems(tx *sql.Tx, chatID int64, list string) (ids []int64, items []Item, err error) {
+	rows, err := tx.Query(`SELECT id, name, quantity, unit, note FROM items WHERE chat_id = ? AND list = ? ORDER BY id`, chatID, list)
+	if err != nil {
+		return nil, nil, err
+	}
+	return scanItems(rows)
+}
+
+// scanItems reads all items with their ids and closes rows
+func scanItems(rows *sql.Rows) (ids []int64, items []Item, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id   int64
+			item Item
+		)
+		if err := rows.Scan(&id, &item.Name, &item.Quantity, &item.Unit, &item.Note); err != nil {
+			return nil, nil, fmt.Errorf("can't scan item: %w", err)
+		}
+		ids = append(ids, id)
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("can't read items: %w", err)
+	}
+	return ids, items, nil
+}
+
+// addItem merges item with the same one in the list of chatID or inserts it if the list has room by limits
+func addItem(tx *sql.Tx, chatID int64, list string, item Item, limits Limits) error {
+	ids, items, err := selectItems(tx, chatID, list)
+	if err != nil {
+		return err
+	}
+
+	for i, existing := range items {
+		if merged, ok := mergeItems(existing, item); ok {
+			_, err := tx.Exec(
+				`UPDATE items SET quantity = ?, unit = ?, note = ? WHERE id = ?`,
+				merged.Quantity, merged.Unit, merged.Note, ids[i],
+			)
+			return err
+		}
+	}
+
+	if err := limits.checkItems(len(items)); err != nil {
+		return err
+	}
+	return insertItem(tx, chatID, list, item)
+}
+
+func insertItem(tx *sql.Tx, chatID int64, list string, item Item) error {
+	_, err := tx.Exec(
+		`INSERT INTO items (chat_id, list, name, quantity, unit, note) VALUES (?, ?, ?, ?, ?, ?)`,
+		chatID, list, item.Name, item.Quantity, item.Unit, item.Note,
+	)
+	return err
+}
+
+func insertPurchase(tx *sql.Tx, chatID int64, p Purchase) error {
+	_, err := tx.Exec(
+		`INSERT INTO purchases (chat_id, list, name, quantity, unit, note, user_id, user_name, bought_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		chatID, p.List, p.Item.Name, p.Item.Quantity, p.Item.Unit, p.Item.Note, p.UserID, p.UserName, p.Time.UnixNano(),
+	)
+	return err
+}
+
+// scanPurchase scans purchase row, leading columns go into dest if any
+func scanPurchase(rows *sql.Rows, dest ...interface{}) (Purchase, error) {
+	var (
+		p        Purchase
+		boughtAt int64
+	)
+	dest = append(dest, &p.List, &p.Item.Name, &p.Item.Quantity, &p.Item.Unit, &p.Item.Note, &p.UserID, &p.UserName, &boughtAt)
+	if err := rows.Scan(dest...); err != nil {
+		return p, fmt.Errorf("can't scan purchase: %w", err)
+	}
+	p.Time = time.Unix(0, boughtAt)
+	return p, nil
+}
+
+func insertUndo(tx *sql.Tx, chatID int64, e UndoEntry) error {
+	items, err := json.Marshal(e.Items)
+	if err != nil {
+		return fmt.Errorf("can't encode items: %w", err)
+	}
+	_, err = tx.Exec(
+		`INSERT INTO undo_journal (chat_id, cmd, list, items, created_at) VALUES (?, ?, ?, ?, ?)`,
+		chatID, e.Cmd, e.List, string(items), e.Time.UnixNano(),
+	)
+	return err
+}
+
+// scanUndo scans undo journal row, leading columns go into dest if any
+func scanUndo(rows *sql.Rows, dest ...interface{}) (UndoEntry, error) {
+	var (
+		e         UndoEntry
+		items     string
+		createdAt int64
+	)
+	dest = append(dest, &e.Cmd, &e.List, &items, &createdAt)
+	if err := rows.Scan(dest...); err != nil {
+		return e, fmt.Errorf("can't scan undo entry: %w", err)
+	}
+	if err := json.Unmarshal([]byte(items), &e.Items); err != nil {
+		return e, fmt.Errorf("can't decode items: %w", err)
+	}
+	e.Time = time.Unix(0, createdAt)
+	return e, nil
+}
+
+func insertOpenPoll(tx *sql.Tx, p OpenPoll) error {
+	items, err := json.Marshal(p.Items)
+	if err != nil {
+		return fmt.Errorf("can't encode poll items: %w", err)
+	}
+	_, err = tx.Exec(
+		`INSERT INTO open_polls (id, chat_id, message_id, list, items) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET items = excluded.items`,
+		p.ID, p.ChatID, p.MessageID, p.List, string(items),
+	)
+	return err
+}
+
+// scanOpenPolls reads all polls and closes rows
+func scanOpenPolls(rows *sql.Rows) ([]OpenPoll, error) {
+	defer rows.Close()
+	var polls []OpenPoll
+	for rows.Next() {
+		p, err := scanOpenPoll(rows)
+		if err != nil {
+			return nil, err
+		}
+		polls = append(polls, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't read polls: %w", err)
+	}
+	return polls, nil
+}
+
+func scanOpenPoll(rows *sql.Rows) (OpenPoll, error) {
+	var (
+		p     OpenPoll
+		items string
+	)
+	if err := rows.Scan(&p.ID, &p.ChatID, &p.MessageID, &p.List, &items); err != nil {
+		return p, fmt.Errorf("can't scan poll: %w", err)
+	}
+	if err := json.Unmarshal([]byte(items), &p.Items); err != nil {
+		return p, fmt.Errorf("can't decode poll items: %w", err)
+	}
+	return p, nil
+}
+
+// insertStaple inserts new staple, if its ID is zero, or replaces the one with the same ID
+func insertStaple(tx *sql.Tx, st Staple) (int64, error) {
+	item, err := json.Marshal(st.Item)
+	if err != nil {
+		return 0, fmt.Errorf("can't encode staple item: %w", err)
+	}
+	if st.ID == 0 {
+		res, err := tx.Exec(
+			`INSERT INTO staples (chat_id, list, item, every, next_at) VALUES (?, ?, ?, ?, ?)`,
+			st.ChatID, st.List, string(item), int64(st.Every), st.Next.UnixNano(),
+		)
+		if err != nil {
+			return 0, err
+		}
+		return res.LastInsertId()
+	}
+	_, err = tx.Exec(
+		`INSERT INTO staples (id, chat_id, list, item, every, next_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET list = excluded.list, item = excluded.item, every = excluded.every, next_at = excluded.next_at`,
+		st.ID, st.ChatID, st.List, string(item), int64(st.Every), st.Next.UnixNano(),
+	)
+	return st.ID, err
+}
+
+// scanStaples reads all staples and closes rows
+func scanStaples(rows *sql.Rows) ([]Staple, error) {
+	defer rows.Close()
+	var staples []Staple
+	for rows.Next() {
+		var (
+			st          Staple
+			item        string
+			every, next int64
+		)
+		if err := rows.Scan(&st.ID, &st.ChatID, &st.List, &item, &every, &next); err != nil {
+			return nil, fmt.Errorf("can't scan staple: %w", err)
+		}
+		if err := json.Unmarshal([]byte(item), &st.Item); err != nil {
+			return nil, fmt.Errorf("can't decode staple item: %w", err)
+		}
+		st.Every, st.Next = time.Duration(every), time.Unix(0, next)
+		staples = append(staples, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't read staples: %w", err)
+	}
+	return staples, nil
+}
+
+// insertReminder inserts new reminder, if its ID is zero, or replaces the one with the same ID
+func insertReminder(tx *sql.Tx, r Reminder) (int64, error) {
+	if r.ID == 0 {
+		res, err := tx.Exec(
+			`INSERT INTO reminders (chat_id, list, weekday, daily, hour, minute, next_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			r.ChatID, r.List, int(r.Weekday), r.Daily, r.Hour, r.Minute, r.Next.UnixNano(),
+		)
+		if err != nil {
+			return 0, err
+		}
+		return res.LastInsertId()
+	}
+	_, err := tx.Exec(
+		`INSERT INTO reminders (id, chat_id, list, weekday, daily, hour, minute, next_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET list = excluded.list, weekday = excluded.weekday, daily = excluded.daily,
+			hour = excluded.hour, minute = excluded.minute, next_at = excluded.next_at`,
+		r.ID, r.ChatID, r.List, int(r.Weekday), r.Daily, r.Hour, r.Minute, r.Next.UnixNano(),
+	)
+	return r.ID, err
+}
+
+// scanReminders reads all reminders and closes rows
+func scanReminders(rows *sql.Rows) ([]Reminder, error) {
+	defer rows.Close()
+	var reminders []Reminder
+	for rows.Next() {
+		var (
+			r             Reminder
+			weekday, next int64
+		)
+		if err := rows.Scan(&r.ID, &r.ChatID, &r.List, &weekday, &r.Daily, &r.Hour, &r.Minute, &next); err != nil {
+			return nil, fmt.Errorf("can't scan reminder: %w", err)
+		}
+		r.Weekday, r.Next = time.Weekday(weekday), time.Unix(0, next)
+		reminders = append(reminders, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't read reminders: %w", err)
+	}
+	return reminders, nil
+}
+
+// insertRecipe saves recipe or replaces the one with the same name regardless of case
+func insertRecipe(tx *sql.Tx, chatID int64, r Recipe) error {
+	items, err := json.Marshal(r.Items)
+	if err != nil {
+		return fmt.Errorf("can't encode recipe items: %w", err)
+	}
+	_, err = tx.Exec(
+		`INSERT INTO recipes (chat_id, key, name, servings, items) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (chat_id, key) DO UPDATE SET name = excluded.name, servings = excluded.servings, items = excluded.items`,
+		chatID, foldName(r.Name), r.Name, r.Servings, string(items),
+	)
+	return err
+}
+
+// chatRecipe is a recipe with its chat, as it's read from the db
+type chatRecipe struct {
+	Recipe
+	chatID int64
+}
+
+// scanRecipes reads all recipes and closes rows
+func scanRecipes(rows *sql.Rows) ([]chatRecipe, error) {
+	defer rows.Close()
+	var recipes []chatRecipe
+	for rows.Next() {
+		var (
+			r     chatRecipe
+			items string
+		)
+		if err := rows.Scan(&r.chatID, &r.Name, &r.Servings, &items); err != nil {
+			return nil, fmt.Errorf("can't scan recipe: %w", err)
+		}
+		if err := json.Unmarshal([]byte(items), &r.Items); err != nil {
+			return nil, fmt.Errorf("can't decode recipe items: %w", err)
+		}
+		recipes = append(recipes, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't read recipes: %w", err)
+	}
+	return recipes, nil
+}
+
+// tx runs fn in transaction and commits it, or rollbacks if fn fails
+func (s *SQLite) tx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("can't begin tx: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("can't exec tx: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit tx: %w", err)
+	}
+	return nil
+}
+
+// insertUserChat adds the chat of the user or updates its title, the order of chats is kept
+func insertUserChat(tx *sql.Tx, userID int64, chat UserChat) error {
+	_, err := tx.Exec(
+		`INSERT INTO user_chats (user_id, chat_id, title) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, chat_id) DO UPDATE SET title = excluded.title`,
+		userID, chat.ID, chat.Title,
+	)
+	return err
+}
+
+// chatOfUser is a chat with its user, as it's read from the db
+type chatOfUser struct {
+	UserChat
+	userID int64
+}
+
+// scanUserChats reads all chats of users and closes rows
+func scanUserChats(rows *sql.Rows) ([]chatOfUser, error) {
+	defer rows.Close()
+	var chats []chatOfUser
+	for rows.Next() {
+		var uc chatOfUser
+		if err := rows.Scan(&uc.userID, &uc.ID, &uc.Title); err != nil {
+			return nil, fmt.Errorf("can't scan chat of user: %w", err)
+		}
+		chats = append(chats, uc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't read chats of users: %w", err)
+	}
+	return chats, nil
+}
@@ -0,0 +1,641 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestSQLite(t *testing.T) *SQLite {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := NewSQLite(filepath.Join(dir, "items.db"), filepath.Join(dir, "items.gob"))
+	if err != nil {
+		t.Fatalf("NewSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestSQLite_AddRemoveGetAll(t *testing.T) {
+	db := newTestSQLite(t)
+
+	for _, item := range []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"} {
+		if err := db.Add(0, defaultList, Item{Name: item}); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if err := db.Add(1, defaultList, Item{Name: "foo"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	got, err := db.GetAll(0, defaultList)
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	want := [][]Item{plainItems("1", "2", "3", "4", "5", "6", "7", "8", "9"), plainItems("11", "10")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAll() = %v, want %v", got, want)
+	}
+
+	if err := db.Remove(0, defaultList, "10"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if err := db.Remove(0, defaultList, "missing"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	got, _ = db.GetAll(0, defaultList)
+	want = [][]Item{plainItems("1", "2", "3", "4", "5", "6", "7", "8", "9", "11")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAll() after Remove = %v, want %v", got, want)
+	}
+
+	got, _ = db.GetAll(2, defaultList)
+	if got != nil {
+		t.Errorf("GetAll() for missing chat = %v, want nil", got)
+	}
+}
+
+func TestSQLite_DumpRestore(t *testing.T) {
+	db := newTestSQLite(t)
+	_ = db.Add(0, defaultList, Item{Name: "foo"})
+	_ = db.Add(0, defaultList, Item{Name: "bar", Quantity: 2, Unit: "kg", Note: "big"})
+	_ = db.Add(1, defaultList, Item{Name: "baz"})
+	_ = db.Add(1, "hardware", Item{Name: "screws"})
+	_ = db.CreateList(1, "empty")
+	bought := Purchase{Item: Item{Name: "milk", Quantity: 2}, List: defaultList, UserID: 42, UserName: "@cat", Time: time.Unix(1666000000, 0)}
+	_ = db.AddPurchase(1, bought)
+	undo := UndoEntry{Cmd: doneCmd, List: "hardware", Items: []Item{{Name: "nails", Quantity: 100}}, Time: time.Unix(1666000000, 0)}
+	_ = db.PushUndo(1, undo)
+	if err := db.SetSettings(1, ChatSettings{View: viewChecklist}); err != nil {
+		t.Fatalf("SetSettings() error = %v", err)
+	}
+	poll := OpenPoll{ID: "p1", ChatID: 1, MessageID: 7, List: defaultList, Items: []PollItem{
+		{Item: Item{Name: "tea"}, Voters: []int64{42}, BoughtAt: time.Unix(1666000000, 0).UTC()},
+		{Item: Item{Name: "sugar"}},
+	}}
+	_ = db.SaveOpenPoll(poll)
+	staple := Staple{ChatID: 1, List: defaultList, Item: Item{Name: "milk", Quantity: 2}, Every: 7 * 24 * time.Hour, Next: time.Unix(1666000000, 0)}
+	staple.ID, _ = db.SaveStaple(staple)
+	reminder := Reminder{ChatID: 1, List: defaultList, Weekday: time.Saturday, Hour: 10, Next: time.Unix(1666000000, 0)}
+	reminder.ID, _ = db.SaveReminder(reminder)
+	recipe := Recipe{Name: "Pancakes", Servings: 4, Items: []Item{{Name: "eggs", Quantity: 3}, {Name: "milk", Quantity: 0.5, Unit: "l"}}}
+	_ = db.SaveRecipe(1, recipe)
+	userChats := []UserChat{{ID: 42}, {ID: 1, Title: "Family"}}
+	for _, uc := range userChats {
+		_ = db.SaveUserChat(42, uc)
+	}
+
+	if err := db.Dump(); err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+
+	_ = db.Add(0, defaultList, Item{Name: "not in dump"})
+	if err := db.Restore(); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	for chatID, want := range map[int64][][]Item{
+		0: {{{Name: "foo"}, {Name: "bar", Quantity: 2, Unit: "kg", Note: "big"}}},
+		1: {plainItems("baz")},
+	} {
+		got, err := db.GetAll(chatID, defaultList)
+		if err != nil {
+			t.Fatalf("GetAll() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("GetAll(%d) = %v, want %v", chatID, got, want)
+		}
+	}
+
+	if got, _ := db.List(1, "hardware"); !reflect.DeepEqual(got, plainItems("screws")) {
+		t.Errorf("List(1, hardware) = %v", got)
+	}
+	if got, _ := db.Lists(1); !reflect.DeepEqual(got, []string{defaultList, "empty", "hardware"}) {
+		t.Errorf("Lists(1) = %v", got)
+	}
+
+	if got, _ := db.History(1, time.Time{}); len(got) != 1 || got[0] != bought {
+		t.Errorf("History(1) = %v, want %v", got, bought)
+	}
+
+	if got, ok, _ := db.PopUndo(1); !ok || !reflect.DeepEqual(got, undo) {
+		t.Errorf("PopUndo(1) = %v, want %v", got, undo)
+	}
+
+	if got, ok, _ := db.OpenPoll("p1"); !ok || !reflect.DeepEqual(got, poll) {
+		t.Errorf("OpenPoll(p1) = %v, want %v", got, poll)
+	}
+
+	if got, _ := db.Staples(1); !reflect.DeepEqual(got, []Staple{staple}) {
+		t.Errorf("Staples(1) = %v, want %v", got, staple)
+	}
+	if got, _ := db.Reminders(1); !reflect.DeepEqual(got, []Reminder{reminder}) {
+		t.Errorf("Reminders(1) = %v, want %v", got, reminder)
+	}
+	if got, _ := db.Recipes(1); !reflect.DeepEqual(got, []Recipe{recipe}) {
+		t.Errorf("Recipes(1) = %v, want %v", got, recipe)
+	}
+	if got, _ := db.UserChats(42); !reflect.DeepEqual(got, userChats) {
+		t.Errorf("UserChats(42) = %v, want %v", got, userChats)
+	}
+
+	settings, err := db.Settings(1)
+	if err != nil {
+		t.Fatalf("Settings() error = %v", err)
+	}
+	if settings.View != viewChecklist {
+		t.Errorf("Settings().View = %q, want %q", settings.View, viewChecklist)
+	}
+}
+
+func TestSQLite_MergeOnAdd(t *testing.T) {
+	db := newTestSQLite(t)
+	_ = db.Add(0, defaultList, parseItem("milk"))
+	_ = db.Add(0, defaultList, parseItem("1 kg rice"))
+	_ = db.Add(0, defaultList, parseItem("2x Milk"))
+	_ = db.Add(0, defaultList, parseItem("rice 500g"))
+
+	got, err := db.List(0, defaultList)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []Item{{Name: "milk", Quantity: 3}, {Name: "rice", Quantity: 1.5, Unit: "kg"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+}
+
+func TestSQLite_TakeReplaceClear(t *testing.T) {
+	db := newTestSQLite(t)
+	for _, item := range []Item{{Name: "milk", Quantity: 2}, {Name: "mlk"}, {Name: "bread"}} {
+		_ = db.Add(1, defaultList, item)
+	}
+	_ = db.Add(1, "hardware", Item{Name: "screws"})
+
+	if got, ok, err := db.Take(1, defaultList, "Bread"); !ok || err != nil || got.Name != "bread" {
+		t.Errorf("Take() = %v, %v, %v", got, ok, err)
+	}
+	if _, ok, _ := db.Take(1, defaultList, "bread"); ok {
+		t.Errorf("Take() of taken item ok = true")
+	}
+
+	if ok, err := db.Replace(1, defaultList, "mlk", Item{Name: "milk"}); !ok || err != nil {
+		t.Errorf("Replace() = %v, %v", ok, err)
+	}
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, []Item{{Name: "milk", Quantity: 3}}) {
+		t.Errorf("items after merging Replace() = %v", got)
+	}
+	if ok, _ := db.Replace(1, defaultList, "milk", Item{Name: "oat milk", Quantity: 1, Unit: "l"}); !ok {
+		t.Errorf("Replace() ok = false")
+	}
+	if ok, _ := db.Replace(1, defaultList, "nope", Item{Name: "eggs"}); ok {
+		t.Errorf("Replace() of missing item ok = true")
+	}
+
+	got, err := db.Clear(1, defaultList)
+	if err != nil || !reflect.DeepEqual(got, []Item{{Name: "oat milk", Quantity: 1, Unit: "l"}}) {
+		t.Errorf("Clear() = %v, %v", got, err)
+	}
+	if got, _ := db.List(1, defaultList); len(got) != 0 {
+		t.Errorf("items after Clear() = %v", got)
+	}
+	if got, _ := db.List(1, "hardware"); len(got) != 1 {
+		t.Errorf("Clear() removed items of other list: %v", got)
+	}
+}
+
+func TestSQLite_Lists(t *testing.T) {
+	db := newTestSQLite(t)
+
+	if err := db.CreateList(1, "hardware"); err != nil {
+		t.Fatalf("CreateList() error = %v", err)
+	}
+	if err := db.CreateList(1, "hardware"); !errors.Is(err, errListExists) {
+		t.Errorf("CreateList() of existing list error = %v, want %v", err, errListExists)
+	}
+	if err := db.CreateList(1, defaultList); !errors.Is(err, errListExists) {
+		t.Errorf("CreateList() of default list error = %v, want %v", err, errListExists)
+	}
+	_ = db.Add(1, "bakery", Item{Name: "bread"})
+	_ = db.Add(1, "hardware", Item{Name: "screws"})
+	_ = db.Add(1, "hardware", Item{Name: "Screws"})
+	_ = db.Add(2, "garden", Item{Name: "seeds"})
+
+	got, err := db.Lists(1)
+	if err != nil {
+		t.Fatalf("Lists() error = %v", err)
+	}
+	if want := []string{defaultList, "bakery", "hardware"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Lists() = %v, want %v", got, want)
+	}
+	if items, _ := db.List(1, "hardware"); !reflect.DeepEqual(items, plainItems("screws")) {
+		t.Errorf("List(hardware) = %v", items)
+	}
+
+	_ = db.Remove(1, defaultList, "bread")
+	if items, _ := db.List(1, "bakery"); !reflect.DeepEqual(items, plainItems("bread")) {
+		t.Errorf("Remove() from the other list changed bakery = %v", items)
+	}
+}
+
+func TestSQLite_History(t *testing.T) {
+	db := newTestSQLite(t)
+	start := time.Unix(1666000000, 0)
+
+	purchase := func(i int) Purchase {
+		return Purchase{Item: Item{Name: fmt.Sprint(i)}, List: defaultList, Time: start.Add(time.Duration(i) * time.Minute)}
+	}
+	// fill the history in one tx, it's too slow to do it one by one
+	err := db.tx(func(tx *sql.Tx) error {
+		for i := 0; i < historyLimit; i++ {
+			if err := insertPurchase(tx, 1, purchase(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("can't fill history: %v", err)
+	}
+	for i := historyLimit; i < historyLimit+5; i++ {
+		if err := db.AddPurchase(1, purchase(i)); err != nil {
+			t.Fatalf("AddPurchase() error = %v", err)
+		}
+	}
+	_ = db.AddPurchase(2, Purchase{Item: Item{Name: "other"}, List: defaultList, Time: start})
+
+	got, err := db.History(1, time.Time{})
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(got) != historyLimit || got[0].Item.Name != "5" {
+		t.Errorf("History() has %d purchases from %v, want %d from 5", len(got), got[0].Item.Name, historyLimit)
+	}
+
+	got, _ = db.History(1, start.Add(time.Duration(historyLimit+3)*time.Minute))
+	if len(got) != 2 || got[0].Item.Name != fmt.Sprint(historyLimit+3) || !got[0].Time.Equal(start.Add(time.Duration(historyLimit+3)*time.Minute)) {
+		t.Errorf("History() since = %v", got)
+	}
+	if got, _ := db.History(2, time.Time{}); len(got) != 1 {
+		t.Errorf("History() of the other chat = %v", got)
+	}
+}
+
+func TestSQLite_Undo(t *testing.T) {
+	db := newTestSQLite(t)
+	at := time.Unix(1666000000, 0)
+
+	for i := 0; i < undoLimit+2; i++ {
+		e := UndoEntry{Cmd: doneCmd, List: defaultList, Items: plainItems(fmt.Sprint(i)), Time: at}
+		if err := db.PushUndo(1, e); err != nil {
+			t.Fatalf("PushUndo() error = %v", err)
+		}
+	}
+	_ = db.PushUndo(2, UndoEntry{Cmd: doneCmd, List: defaultList, Items: plainItems("other"), Time: at})
+
+	for i := undoLimit + 1; i >= 2; i-- {
+		e, ok, err := db.PopUndo(1)
+		if err != nil || !ok {
+			t.Fatalf("PopUndo() = %v, %v", ok, err)
+		}
+		if want := plainItems(fmt.Sprint(i)); !reflect.DeepEqual(e.Items, want) || !e.Time.Equal(at) {
+			t.Errorf("PopUndo() = %v, want items %v", e, want)
+		}
+	}
+	if _, ok, err := db.PopUndo(1); ok || err != nil {
+		t.Errorf("PopUndo() of empty journal = %v, %v", ok, err)
+	}
+
+	_ = db.AddPurchase(1, Purchase{Item: Item{Name: "milk"}, List: defaultList, Time: at})
+	_ = db.AddPurchase(1, Purchase{Item: Item{Name: "eggs"}, List: defaultList, Time: at.Add(time.Second)})
+	if err := db.RemovePurchases(1, at); err != nil {
+		t.Fatalf("RemovePurchases() error = %v", err)
+	}
+	if got, _ := db.History(1, time.Time{}); len(got) != 1 || got[0].Item.Name != "eggs" {
+		t.Errorf("History() after RemovePurchases() = %v", got)
+	}
+
+	// purchases made at the same time are removed one by one
+	_ = db.AddPurchase(1, Purchase{Item: Item{Name: "milk"}, List: defaultList, Time: at.Add(time.Second)})
+	_ = db.AddPurchase(1, Purchase{Item: Item{Name: "milk"}, List: "party", Time: at.Add(time.Second)})
+	if err := db.RemovePurchase(1, defaultList, "milk", at.Add(time.Second)); err != nil {
+		t.Fatalf("RemovePurchase() error = %v", err)
+	}
+	got, _ := db.History(1, time.Time{})
+	if len(got) != 2 || got[0].Item.Name != "eggs" || got[1].List != "party" {
+		t.Errorf("History() after RemovePurchase() = %v", got)
+	}
+}
+
+func TestSQLite_OpenPolls(t *testing.T) {
+	db := newTestSQLite(t)
+
+	p := OpenPoll{ID: "p1", ChatID: 1, MessageID: 7, List: "hardware", Items: []PollItem{{Item: Item{Name: "screws"}}, {Item: Item{Name: "nails"}}}}
+	if err := db.SaveOpenPoll(p); err != nil {
+		t.Fatalf("SaveOpenPoll() error = %v", err)
+	}
+	p.Items[1].Voters = []int64{42}
+	p.Items[1].BoughtAt = time.Unix(1666000000, 0).UTC()
+	if err := db.SaveOpenPoll(p); err != nil {
+		t.Fatalf("SaveOpenPoll() of existing poll error = %v", err)
+	}
+
+	got, ok, err := db.OpenPoll("p1")
+	if err != nil || !ok {
+		t.Fatalf("OpenPoll() = %v, %v", ok, err)
+	}
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("OpenPoll() = %v, want %v", got, p)
+	}
+
+	_ = db.SaveOpenPoll(OpenPoll{ID: "p0", ChatID: 1, MessageID: 5, List: "hardware"})
+	_ = db.SaveOpenPoll(OpenPoll{ID: "p2", ChatID: 2, MessageID: 1, List: "hardware"})
+	if polls, _ := db.OpenPolls(1, "hardware"); len(polls) != 2 || polls[0].ID != "p0" || polls[1].ID != "p1" {
+		t.Errorf("OpenPolls() = %v, want p0 and p1", polls)
+	}
+	if polls, _ := db.AllOpenPolls(); len(polls) != 3 {
+		t.Errorf("AllOpenPolls() = %v, want 3 polls", polls)
+	}
+
+	if err := db.DeleteOpenPoll("p1"); err != nil {
+		t.Fatalf("DeleteOpenPoll() error = %v", err)
+	}
+	if _, ok, err := db.OpenPoll("p1"); ok || err != nil {
+		t.Errorf("OpenPoll() of deleted poll = %v, %v", ok, err)
+	}
+}
+
+func TestSQLite_Staples(t *testing.T) {
+	db := newTestSQLite(t)
+	at := time.Unix(1666000000, 0)
+
+	milk := Staple{ChatID: 1, List: defaultList, Item: Item{Name: "milk"}, Every: week, Next: at}
+	id, err := db.SaveStaple(milk)
+	if err != nil {
+		t.Fatalf("SaveStaple() error = %v", err)
+	}
+	milk.ID = id
+	milk.Item.Quantity, milk.Next = 2, at.Add(week)
+	if id, err := db.SaveStaple(milk); err != nil || id != milk.ID {
+		t.Fatalf("SaveStaple() of existing staple = %d, %v, want %d", id, err, milk.ID)
+	}
+	bread := Staple{ChatID: 1, List: defaultList, Item: Item{Name: "bread"}, Every: day, Next: at}
+	bread.ID, _ = db.SaveStaple(bread)
+	screws := Staple{ChatID: 2, List: "hardware", Item: Item{Name: "screws"}, Every: month, Next: at.Add(-time.Hour)}
+	screws.ID, _ = db.SaveStaple(screws)
+
+	if got, _ := db.Staples(1); !reflect.DeepEqual(got, []Staple{milk, bread}) {
+		t.Errorf("Staples(1) = %v, want milk and bread", got)
+	}
+	if got, _ := db.DueStaples(at); !reflect.DeepEqual(got, []Staple{bread, screws}) {
+		t.Errorf("DueStaples() = %v, want bread and screws", got)
+	}
+
+	if ok, err := db.DeleteStaple(1, screws.ID); ok || err != nil {
+		t.Errorf("DeleteStaple() of another chat = %v, %v", ok, err)
+	}
+	if ok, err := db.DeleteStaple(2, screws.ID); !ok || err != nil {
+		t.Errorf("DeleteStaple() = %v, %v", ok, err)
+	}
+	if got, _ := db.Staples(2); len(got) != 0 {
+		t.Errorf("Staples(2) after delete = %v", got)
+	}
+}
+
+func TestSQLite_Reminders(t *testing.T) {
+	db := newTestSQLite(t)
+	at := time.Unix(1666000000, 0)
+
+	daily := Reminder{ChatID: 1, List: defaultList, Daily: true, Hour: 18, Minute: 30, Next: at}
+	id, err := db.SaveReminder(daily)
+	if err != nil {
+		t.Fatalf("SaveReminder() error = %v", err)
+	}
+	daily.ID = id
+	daily.Next = at.Add(day)
+	if id, err := db.SaveReminder(daily); err != nil || id != daily.ID {
+		t.Fatalf("SaveReminder() of existing reminder = %d, %v, want %d", id, err, daily.ID)
+	}
+	weekly := Reminder{ChatID: 1, List: "hardware", Weekday: time.Saturday, Hour: 10, Next: at}
+	weekly.ID, _ = db.SaveReminder(weekly)
+	other := Reminder{ChatID: 2, List: defaultList, Daily: true, Hour: 9, Next: at.Add(-time.Minute)}
+	other.ID, _ = db.SaveReminder(other)
+
+	if got, _ := db.Reminders(1); !reflect.DeepEqual(got, []Reminder{daily, weekly}) {
+		t.Errorf("Reminders(1) = %v, want daily and weekly", got)
+	}
+	if got, _ := db.DueReminders(at); !reflect.DeepEqual(got, []Reminder{weekly, other}) {
+		t.Errorf("DueReminders() = %v, want weekly and other", got)
+	}
+
+	if ok, err := db.DeleteReminder(1, other.ID); ok || err != nil {
+		t.Errorf("DeleteReminder() of another chat = %v, %v", ok, err)
+	}
+	if ok, err := db.DeleteReminder(2, other.ID); !ok || err != nil {
+		t.Errorf("DeleteReminder() = %v, %v", ok, err)
+	}
+	if got, _ := db.Reminders(2); len(got) != 0 {
+		t.Errorf("Reminders(2) after delete = %v", got)
+	}
+}
+
+func TestSQLite_Recipes(t *testing.T) {
+	db := newTestSQLite(t)
+
+	pancakes := Recipe{Name: "pancakes", Items: []Item{{Name: "eggs", Quantity: 3}}}
+	if err := db.SaveRecipe(1, pancakes); err != nil {
+		t.Fatalf("SaveRecipe() error = %v", err)
+	}
+	pancakes = Recipe{Name: "Pancakes", Servings: 4, Items: []Item{{Name: "eggs", Quantity: 3}, {Name: "milk", Quantity: 0.5, Unit: "l"}}}
+	if err := db.SaveRecipe(1, pancakes); err != nil {
+		t.Fatalf("SaveRecipe() of existing recipe error = %v", err)
+	}
+	borscht := Recipe{Name: "borscht", Items: []Item{{Name: "beetroot"}}}
+	_ = db.SaveRecipe(1, borscht)
+	_ = db.SaveRecipe(2, Recipe{Name: "tea", Items: []Item{{Name: "tea"}}})
+
+	if got, ok, err := db.Recipe(1, "PANCAKES"); err != nil || !ok || !reflect.DeepEqual(got, pancakes) {
+		t.Errorf("Recipe() = %v, %v, %v, want %v", got, ok, err, pancakes)
+	}
+	if _, ok, err := db.Recipe(1, "tea"); ok || err != nil {
+		t.Errorf("Recipe() of another chat = %v, %v", ok, err)
+	}
+	if got, _ := db.Recipes(1); !reflect.DeepEqual(got, []Recipe{borscht, pancakes}) {
+		t.Errorf("Recipes() = %v, want borscht and pancakes", got)
+	}
+
+	if ok, err := db.DeleteRecipe(1, "Borscht"); !ok || err != nil {
+		t.Errorf("DeleteRecipe() = %v, %v", ok, err)
+	}
+	if ok, _ := db.DeleteRecipe(1, "borscht"); ok {
+		t.Errorf("DeleteRecipe() of deleted recipe = true")
+	}
+}
+
+func TestSQLite_Limits(t *testing.T) {
+	db := newTestSQLite(t)
+	db.limits = Limits{Items: 2, Lists: 2, ItemLen: 10}
+
+	var qe quotaError
+	_ = db.Add(1, defaultList, Item{Name: "milk"})
+	_ = db.Add(1, defaultList, Item{Name: "bread"})
+	if err := db.Add(1, defaultList, Item{Name: "milk", Quantity: 2}); err != nil {
+		t.Errorf("Add() of existing item into full list error = %v", err)
+	}
+	if err := db.Add(1, defaultList, Item{Name: "eggs"}); !errors.As(err, &qe) {
+		t.Errorf("Add() into full list error = %v, want quota error", err)
+	}
+	if err := db.Add(2, defaultList, Item{Name: "a very long cheese"}); !errors.As(err, &qe) {
+		t.Errorf("Add() of long item error = %v, want quota error", err)
+	}
+	if _, err := db.Replace(1, defaultList, "bread", Item{Name: "bread of the day"}); !errors.As(err, &qe) {
+		t.Errorf("Replace() with long item error = %v, want quota error", err)
+	}
+	// removed items are put back regardless of quotas
+	if err := db.PutBack(1, defaultList, []Item{{Name: "bread"}, {Name: "eggs"}, {Name: "tea"}}); err != nil {
+		t.Errorf("PutBack() into full list error = %v", err)
+	}
+	want := []Item{{Name: "milk", Quantity: 3}, {Name: "bread"}, {Name: "eggs"}, {Name: "tea"}}
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, want) {
+		t.Errorf("items after PutBack() = %v, want %v", got, want)
+	}
+
+	if err := db.CreateList(1, "hardware"); err != nil {
+		t.Fatalf("CreateList() error = %v", err)
+	}
+	if err := db.CreateList(1, "hardware"); !errors.Is(err, errListExists) {
+		t.Errorf("CreateList() of existing list error = %v, want %v", err, errListExists)
+	}
+	if err := db.CreateList(1, "garden"); !errors.As(err, &qe) {
+		t.Errorf("CreateList() over quota error = %v, want quota error", err)
+	}
+}
+
+func TestSQLite_CreateListsConcurrently(t *testing.T) {
+	db := newTestSQLite(t)
+	db.limits = Limits{Lists: 3}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = db.CreateList(1, fmt.Sprintf("list%d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	if got, _ := db.Lists(1); len(got) != 3 {
+		t.Errorf("lists = %v, want 3 of them", got)
+	}
+}
+
+func TestSQLite_UserChats(t *testing.T) {
+	db := newTestSQLite(t)
+
+	_ = db.SaveUserChat(42, UserChat{ID: 1, Title: "Family"})
+	_ = db.SaveUserChat(42, UserChat{ID: 42})
+	_ = db.SaveUserChat(7, UserChat{ID: 1, Title: "Family"})
+	if err := db.SaveUserChat(42, UserChat{ID: 1, Title: "Home"}); err != nil {
+		t.Fatalf("SaveUserChat() of existing chat error = %v", err)
+	}
+
+	want := []UserChat{{ID: 1, Title: "Home"}, {ID: 42}}
+	if got, err := db.UserChats(42); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("UserChats() = %v, %v, want %v", got, err, want)
+	}
+	if got, _ := db.UserChats(8); len(got) != 0 {
+		t.Errorf("UserChats() of unknown user = %v", got)
+	}
+}
+
+func TestSQLite_MigrateLegacySchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.db")
+
+	legacy, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("can't open db: %v", err)
+	}
+	_, err = legacy.Exec(`
+		CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT, chat_id INTEGER NOT NULL, item TEXT NOT NULL);
+		INSERT INTO items (chat_id, item) VALUES (0, '2 kg potatoes'), (0, 'eggs (free range)');
+	`)
+	if err != nil {
+		t.Fatalf("can't create legacy schema: %v", err)
+	}
+	_ = legacy.Close()
+
+	db, err := NewSQLite(path, filepath.Join(t.TempDir(), "items.gob"))
+	if err != nil {
+		t.Fatalf("NewSQLite() error = %v", err)
+	}
+	defer db.Close()
+
+	got, err := db.List(0, defaultList)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []Item{{Name: "potatoes", Quantity: 2, Unit: "kg"}, {Name: "eggs", Note: "free range"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+	if lists, _ := db.Lists(0); !reflect.DeepEqual(lists, []string{defaultList}) {
+		t.Errorf("Lists() = %v, want only default", lists)
+	}
+}
+
+func TestMakeSQLiteStore_ImportOnce(t *testing.T) {
+	dir := t.TempDir()
+	dbPath, dumpPath := filepath.Join(dir, "items.db"), filepath.Join(dir, "items.gob")
+	dump := func(names ...string) {
+		t.Helper()
+		inmem := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}, path: dumpPath}
+		for _, name := range names {
+			if err := inmem.Add(1, defaultList, Item{Name: name}); err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+		}
+		if err := inmem.Dump(); err != nil {
+			t.Fatalf("Dump() error = %v", err)
+		}
+	}
+
+	// the fresh db imports the dump of inmem storage
+	dump("milk")
+	db, restored, err := makeSQLiteStore(dbPath, dumpPath, Limits{})
+	if err != nil || !restored {
+		t.Fatalf("makeSQLiteStore() = %v, %v", restored, err)
+	}
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, plainItems("milk")) {
+		t.Errorf("imported items = %v", got)
+	}
+	// everything is bought, so there are no items, but there is history
+	bought := time.Date(2022, 10, 17, 15, 0, 0, 0, time.UTC)
+	if _, err := db.Clear(1, defaultList); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if err := db.AddPurchase(1, Purchase{Item: Item{Name: "milk"}, List: defaultList, Time: bought}); err != nil {
+		t.Fatalf("AddPurchase() error = %v", err)
+	}
+	_ = db.Close()
+
+	// the db in use is never replaced by the dump, even an empty one
+	dump("bread")
+	db, restored, err = makeSQLiteStore(dbPath, dumpPath, Limits{})
+	if err != nil || !restored {
+		t.Fatalf("makeSQLiteStore() of the db in use = %v, %v", restored, err)
+	}
+	defer db.Close() //nolint:errcheck
+	if got, _ := db.List(1, defaultList); len(got) != 0 {
+		t.Errorf("items of the db in use = %v, want none", got)
+	}
+	if got, _ := db.History(1, time.Time{}); len(got) != 1 || got[0].Item.Name != "milk" {
+		t.Errorf("history of the db in use = %+v", got)
+	}
+}
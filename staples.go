@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// dayFormat is the format of the next time of scheduled jobs
+const dayFormat = "Mon, Jan 2"
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+	// month is rough, but good enough for shopping
+	month = 30 * day
+)
+
+// endpoint of staple remove buttons
+var unstapleBtn = tele.Btn{Unique: "unstaple"}
+
+var everyRx = regexp.MustCompile(`^(\d+)?\s*(h|hours?|d|days?|w|weeks?|months?)$`)
+
+// Staple is an item which comes back to the list every interval, e.g. milk every week
+type Staple struct {
+	ID     int64
+	ChatID int64
+	List   string
+	Item   Item
+	Every  time.Duration
+	// Next is when the item is added to the list next time
+	Next time.Time
+}
+
+// addStaple registers recurring item of the list: "/staple milk every week".
+// The same item registered again gets the new interval
+func (s *Srv) addStaple(c tele.Context) error {
+	chatID := c.Chat().ID
+	list, payload, err := s.targetList(c)
+	if err != nil {
+		return s.reply(c, err)
+	}
+	i := strings.LastIndex(strings.ToLower(payload), " every ")
+	if i <= 0 {
+		return c.Send(fmt.Sprintf("Usage: %s <item> every <interval>, like %s milk every week", stapleCmd, stapleCmd))
+	}
+	item := parseItem(strings.TrimSpace(payload[:i]))
+	every, err := parseEvery(payload[i+len(" every "):])
+	if err != nil {
+		return s.reply(c, err)
+	}
+
+	staples, err := s.db.Staples(chatID)
+	if err != nil {
+		return fmt.Errorf("can't get staples: %w", err)
+	}
+	settings, err := s.db.Settings(chatID)
+	if err != nil {
+		return fmt.Errorf("can't get settings: %w", err)
+	}
+	st := Staple{ChatID: chatID, List: list, Item: item, Every: every, Next: s.now().Add(every)}
+	for _, existing := range staples {
+		if existing.List == list && s.itemKey(existing.Item.Name) == s.itemKey(item.Name) {
+			st.ID = existing.ID
+		}
+	}
+	if _, err := s.db.SaveStaple(st); err != nil {
+		return fmt.Errorf("can't save staple: %w", err)
+	}
+
+	return c.Send(fmt.Sprintf("%s will be added to %s every %s, next time on %s",
+		item, listRef(list), formatEvery(every), st.Next.In(s.location(settings)).Format(dayFormat)))
+}
+
+// showStaples sends all staples of the chat with buttons to remove them
+func (s *Srv) showStaples(c tele.Context) error {
+	text, rm, err := s.renderStaples(c.Chat().ID)
+	if err != nil {
+		return err
+	}
+	return c.Send(text, rm)
+}
+
+// removeStaple forgets the staple by tap on its button and redraws the rest of staples
+func (s *Srv) removeStaple(c tele.Context) error {
+	chatID := c.Chat().ID
+	id, err := strconv.ParseInt(c.Data(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("can't parse staple id: %w", err)
+	}
+	if _, err := s.db.DeleteStaple(chatID, id); err != nil {
+		return fmt.Errorf("can't delete staple: %w", err)
+	}
+
+	text, rm, err := s.renderStaples(chatID)
+	if err != nil {
+		return err
+	}
+	_ = c.Respond()
+	return c.Edit(text, rm)
+}
+
+// renderStaples describes staples of the chat, one remove button per staple
+func (s *Srv) renderStaples(chatID int64) (string, *tele.ReplyMarkup, error) {
+	staples, err := s.db.Staples(chatID)
+	if err != nil {
+		return "", nil, fmt.Errorf("can't get staples: %w", err)
+	}
+	rm := &tele.ReplyMarkup{}
+	if len(staples) == 0 {
+		return fmt.Sprintf("There are no staples, add one by %s milk every week", stapleCmd), rm, nil
+	}
+
+	settings, err := s.db.Settings(chatID)
+	if err != nil {
+		return "", nil, fmt.Errorf("can't get settings: %w", err)
+	}
+	loc := s.location(settings)
+
+	lines := []string{"Staples:"}
+	var rows []tele.Row
+	for _, st := range staples {
+		line := fmt.Sprintf("%s every %s, next time on %s", st.Item, formatEvery(st.Every), st.Next.In(loc).Format(dayFormat))
+		if st.List != defaultList {
+			line += " @" + st.List
+		}
+		lines = append(lines, line)
+		rows = append(rows, rm.Row(rm.Data("✕ "+st.Item.Name, unstapleBtn.Unique, strconv.FormatInt(st.ID, 10))))
+	}
+	rm.Inline(rows...)
+	return strings.Join(lines, "\n"), rm, nil
+}
+
+// addDueStaples adds staples due at the time to their lists, unless they are already there,
+// and tells chats what is back on their lists. Every staple is scheduled for the next time after that
+func (s *Srv) addDueStaples(at time.Time) error {
+	staples, err := s.db.DueStaples(at)
+	if err != nil {
+		return fmt.Errorf("can't get staples: %w", err)
+	}
+
+	added := make(map[listKey][]string)
+	var keys []listKey
+	for _, st := range staples {
+		items, err := s.db.List(st.ChatID, st.List)
+		if err != nil {
+			return fmt.Errorf("can't get items: %w", err)
+		}
+		if !s.hasItem(items, st.Item.Name) {
+			var qe quotaError
+			switch err := s.db.Add(st.ChatID, st.List, st.Item); {
+			case errors.As(err, &qe):
+				s.rejected.inc(rejectQuota)
+				s.logger.Info("staple is skipped", "chat", st.ChatID, "staple", st.ID, "err", err)
+			case err != nil:
+				return fmt.Errorf("can't add staple: %w", err)
+			default:
+				k := listKey{st.ChatID, st.List}
+				if _, ok := added[k]; !ok {
+					keys = append(keys, k)
+				}
+				added[k] = append(added[k], st.Item.String())
+			}
+		}
+
+		st.Next = st.Next.Add((at.Sub(st.Next)/st.Every + 1) * st.Every)
+		if _, err := s.db.SaveStaple(st); err != nil {
+			return fmt.Errorf("can't save staple: %w", err)
+		}
+	}
+
+	for _, k := range keys {
+		text := fmt.Sprintf("Back on %s: %s", listRef(k.list), strings.Join(added[k], ", "))
+		if err := s.send(&tele.Chat{ID: k.chatID}, text); err != nil {
+			s.logger.Warn("can't tell chat about staples", "chat", k.chatID, "err", err)
+		}
+	}
+	return nil
+}
+
+// hasItem reports if the item with the same name is in items
+func (s *Srv) hasItem(items []Item, name string) bool {
+	for _, item := range items {
+		if s.itemKey(item.Name) == s.itemKey(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEvery parses intervals like "week", "2 weeks", "3d" or "12h"
+func parseEvery(text string) (time.Duration, error) {
+	m := everyRx.FindStringSubmatch(strings.ToLower(strings.TrimSpace(text)))
+	if m == nil {
+		return 0, userError(fmt.Sprintf("Can't understand %q, use intervals like day, 3 days, 2 weeks or month", text))
+	}
+	n := 1
+	if m[1] != "" {
+		var err error
+		if n, err = strconv.Atoi(m[1]); err != nil || n <= 0 || n > 1000 {
+			return 0, userError(fmt.Sprintf("Can't understand %q, the number should be from 1 to 1000", text))
+		}
+	}
+
+	unit := time.Hour
+	switch m[2][0] {
+	case 'd':
+		unit = day
+	case 'w':
+		unit = week
+	case 'm':
+		unit = month
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// formatEvery describes the interval in the largest whole units
+func formatEvery(d time.Duration) string {
+	for _, u := range []struct {
+		d    time.Duration
+		name string
+	}{{week, "week"}, {month, "month"}, {day, "day"}, {time.Hour, "hour"}} {
+		if d%u.d != 0 {
+			continue
+		}
+		if n := d / u.d; n != 1 {
+			return fmt.Sprintf("%d %ss", n, u.name)
+		}
+		return u.name
+	}
+	return d.String()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseEvery(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"week", week, false},
+		{"2 weeks", 2 * week, false},
+		{"3d", 3 * day, false},
+		{"Day", day, false},
+		{"12 hours", 12 * time.Hour, false},
+		{"month", month, false},
+		{"0 days", 0, true},
+		{"fortnight", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := parseEvery(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseEvery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseEvery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatEvery(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{week, "week"},
+		{2 * week, "2 weeks"},
+		{month, "month"},
+		{3 * day, "3 days"},
+		{12 * time.Hour, "12 hours"},
+		{90 * time.Minute, "1h30m0s"},
+	}
+	for _, tt := range tests {
+		if got := formatEvery(tt.d); got != tt.want {
+			t.Errorf("formatEvery(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestSrv_Staples(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.gob")
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), path: path, mu: &sync.Mutex{}}
+	srv := NewServer(db, bot)
+	now := time.Date(2022, 10, 17, 18, 30, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+
+	lastReply := func() interface{} { return bot.sent[1][len(bot.sent[1])-1] }
+	items := func() []Item {
+		got, _ := db.List(1, defaultList)
+		return got
+	}
+
+	bot.cmd(t, 1, "/staple", "milk")
+	if got := lastReply(); got != "Usage: /staple <item> every <interval>, like /staple milk every week" {
+		t.Errorf("/staple without interval = %q", got)
+	}
+	bot.cmd(t, 1, "/staple", "milk every fortnight")
+	if got := lastReply(); got != `Can't understand "fortnight", use intervals like day, 3 days, 2 weeks or month` {
+		t.Errorf("/staple with unknown interval = %q", got)
+	}
+
+	bot.cmd(t, 1, "/staple", "2x milk every day")
+	bot.cmd(t, 1, "/staple", "Milk every week")
+	if got := lastReply(); got != "Milk will be added to the list every week, next time on Mon, Oct 24" {
+		t.Errorf("/staple reply = %q", got)
+	}
+	bot.cmd(t, 1, "/staple", "bread every 3 days")
+	bot.cmd(t, 1, "/newlist", "hardware")
+	bot.cmd(t, 1, "/staple", "@hardware screws every month")
+
+	bot.cmd(t, 1, "/staples", "")
+	want := "Staples:\nMilk every week, next time on Mon, Oct 24\nbread every 3 days, next time on Thu, Oct 20\nscrews every month, next time on Wed, Nov 16 @hardware"
+	if got := lastReply(); got != want {
+		t.Errorf("/staples = %q, want %q", got, want)
+	}
+	stapleMsg := bot.lastID
+	if got := bot.buttons(stapleMsg); !reflect.DeepEqual(got, []string{"✕ Milk", "✕ bread", "✕ screws"}) {
+		t.Errorf("/staples buttons = %v", got)
+	}
+
+	// bread is due, but it's still on the list
+	bot.cmd(t, 1, "/add", "bread")
+	now = now.Add(3 * day)
+	if err := srv.addDueStaples(now); err != nil {
+		t.Fatalf("addDueStaples() error = %v", err)
+	}
+	if got := items(); !reflect.DeepEqual(got, plainItems("bread")) {
+		t.Errorf("items after bread is due = %v, want [bread]", got)
+	}
+
+	// the bot was down for a while, milk and bread are added once
+	_ = db.Remove(1, defaultList, "bread")
+	if err := db.Dump(); err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	restarted := &Inmem{path: path, mu: &sync.Mutex{}}
+	if err := restarted.Restore(); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	srv = NewServer(restarted, bot)
+	now = now.Add(10 * day)
+	if err := srv.addDueStaples(now); err != nil {
+		t.Fatalf("addDueStaples() error = %v", err)
+	}
+	if got, _ := restarted.List(1, defaultList); !reflect.DeepEqual(got, []Item{{Name: "Milk"}, {Name: "bread"}}) {
+		t.Errorf("items after downtime = %v, want [Milk bread]", got)
+	}
+	if got := lastReply(); got != "Back on the list: Milk, bread" {
+		t.Errorf("staples message = %q", got)
+	}
+	staples, _ := restarted.Staples(1)
+	if next := staples[1].Next; !next.Equal(time.Date(2022, 11, 1, 18, 30, 0, 0, time.UTC)) {
+		t.Errorf("next time of bread = %v", next)
+	}
+
+	bot.tap(t, 1, stapleMsg, unstapleBtn, "1")
+	if got := bot.edited[stapleMsg]; got != "Staples:\nbread every 3 days, next time on Tue, Nov 1\nscrews every month, next time on Wed, Nov 16 @hardware" {
+		t.Errorf("staples after remove = %q", got)
+	}
+	bot.tap(t, 2, stapleMsg, unstapleBtn, "2")
+	if staples, _ := restarted.Staples(1); len(staples) != 2 {
+		t.Errorf("staple has been removed from another chat: %v", staples)
+	}
+}
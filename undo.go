@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// undoWindow is how long removed items could be restored by /undo
+	undoWindow = 10 * time.Minute
+	// undoLimit is how many removals are kept in the journal of every chat
+	undoLimit = 10
+)
+
+// UndoEntry is a single removal of items from the list, which could be undone
+type UndoEntry struct {
+	// Cmd is the command removed the items, purchases are dropped from history on undo of doneCmd
+	Cmd   string
+	List  string
+	Items []Item
+	Time  time.Time
+}
+
+// journal pushes removal of items into the undo journal of chatID
+func (s *Srv) journal(chatID int64, cmd, list string, items []Item, at time.Time) error {
+	if len(items) == 0 {
+		return nil
+	}
+	if err := s.db.PushUndo(chatID, UndoEntry{Cmd: cmd, List: list, Items: items, Time: at}); err != nil {
+		return fmt.Errorf("can't save undo entry: %w", err)
+	}
+	return nil
+}
+
+// undo restores items removed by the last /done or /remove, if it was not so long ago
+func (s *Srv) undo(c tele.Context) error {
+	chatID := c.Chat().ID
+	e, ok, err := s.db.PopUndo(chatID)
+	if err != nil {
+		return fmt.Errorf("can't get undo entry: %w", err)
+	}
+	if !ok || s.now().Sub(e.Time) > undoWindow {
+		return c.Send("Nothing to undo")
+	}
+
+	if err := s.db.PutBack(chatID, e.List, e.Items); err != nil {
+		// nothing is restored, so the entry is kept for the next try
+		if err := s.db.PushUndo(chatID, e); err != nil {
+			s.log(c).Warn("can't return undo entry", "err", err)
+		}
+		return fmt.Errorf("can't restore items: %w", err)
+	}
+	names := make([]string, 0, len(e.Items))
+	for _, item := range e.Items {
+		names = append(names, item.String())
+	}
+	if e.Cmd == doneCmd {
+		if err := s.db.RemovePurchases(chatID, e.Time); err != nil {
+			return fmt.Errorf("can't remove purchases: %w", err)
+		}
+	}
+
+	msg := fmt.Sprintf("Restored %d item(s): %s", len(e.Items), strings.Join(names, ", "))
+	if e.List != defaultList {
+		msg = fmt.Sprintf("Restored %d item(s) to @%s: %s", len(e.Items), e.List, strings.Join(names, ", "))
+	}
+	return c.Send(msg)
+}
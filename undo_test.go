@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSrv_Undo(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}}
+	srv := NewServer(db, bot)
+	now := time.Date(2022, 10, 17, 18, 30, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+
+	lastReply := func(chatID int64) interface{} { return bot.sent[chatID][len(bot.sent[chatID])-1] }
+
+	bot.cmd(t, 1, "/undo", "")
+	if got := lastReply(1); got != "Nothing to undo" {
+		t.Errorf("/undo of empty journal = %q", got)
+	}
+
+	bot.cmd(t, 1, "/add", "2x milk, bread, eggs")
+	bot.cmd(t, 2, "/add", "tea, sugar")
+	bot.cmd(t, 1, "/list", "")
+	bot.vote(1, "milk ×2")
+	bot.vote(1, "eggs")
+	bot.cmd(t, 1, "/done", "")
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, plainItems("bread")) {
+		t.Fatalf("items after /done = %v", got)
+	}
+
+	// other chats have own journals
+	bot.cmd(t, 2, "/undo", "")
+	if got := lastReply(2); got != "Nothing to undo" {
+		t.Errorf("/undo in the other chat = %q", got)
+	}
+
+	now = now.Add(time.Minute)
+	bot.cmd(t, 1, "/undo", "")
+	if got := lastReply(1); got != "Restored 2 item(s): milk ×2, eggs" {
+		t.Errorf("/undo = %q", got)
+	}
+	want := []Item{{Name: "bread"}, {Name: "milk", Quantity: 2}, {Name: "eggs"}}
+	if got, _ := db.List(1, defaultList); !reflect.DeepEqual(got, want) {
+		t.Errorf("items after /undo = %v, want %v", got, want)
+	}
+	if got, _ := db.History(1, time.Time{}); len(got) != 0 {
+		t.Errorf("history after /undo = %v, want empty", got)
+	}
+	bot.cmd(t, 1, "/undo", "")
+	if got := lastReply(1); got != "Nothing to undo" {
+		t.Errorf("the second /undo = %q", got)
+	}
+
+	// checklist "Clear checked" is undone the same way, but only within undoWindow
+	bot.cmd(t, 1, "/view", "checklist")
+	bot.cmd(t, 1, "/list", "")
+	bot.tap(t, 1, bot.lastID, checkBtn, "default|0")
+	bot.tap(t, 1, bot.lastID, clearBtn, "default")
+	now = now.Add(undoWindow + time.Second)
+	bot.cmd(t, 1, "/undo", "")
+	if got := lastReply(1); got != "Nothing to undo" {
+		t.Errorf("/undo after window = %q", got)
+	}
+	if got, _ := db.History(1, time.Time{}); len(got) != 1 || got[0].Item.Name != "bread" {
+		t.Errorf("history after expired /undo = %v", got)
+	}
+}
+
+func TestSrv_UndoFullList(t *testing.T) {
+	bot := newFakeBot()
+	db := &Inmem{items: make(map[int64]map[string][]Item), mu: &sync.Mutex{}, limits: Limits{Items: 2}}
+	srv := NewServer(db, bot)
+	now := time.Date(2022, 10, 17, 18, 30, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+
+	bot.cmd(t, 1, "/add", "milk, bread")
+	bot.cmd(t, 1, "/list", "")
+	bot.vote(1, "milk")
+	bot.vote(1, "bread")
+	bot.cmd(t, 1, "/done", "")
+	bot.cmd(t, 1, "/add", "eggs, tea")
+
+	// removed items were in the list, so they are restored over the quota
+	bot.cmd(t, 1, "/undo", "")
+	if got, _ := db.List(1, defaultList); len(got) != 4 {
+		t.Errorf("items after /undo into full list = %v, want 4 items", got)
+	}
+	if got, _ := db.History(1, time.Time{}); len(got) != 0 {
+		t.Errorf("history after /undo = %v, want empty", got)
+	}
+}